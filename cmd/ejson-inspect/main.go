@@ -0,0 +1,71 @@
+// ejson-inspect decrypts the ejson files generated under .operator and prints their values, redacted unless -reveal is set
+//
+//	ejson-inspect [-cr cr.yaml] [-kubeconfig path] [-reveal] [file ...]
+//	ejson-inspect [-cr cr.yaml] [-kubeconfig path] -certificates
+//
+// the CR is read from -cr or the YAML_CONF environment variable, every ejson file under .operator is decrypted if no file is given,
+// -certificates prints the certificates held by the ejson files under .operator/keys and when they expire instead
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/cr"
+)
+
+func main() {
+	crFile := flag.String("cr", "", "CR yaml file, YAML_CONF is used if not set")
+	kubeConfigPath := flag.String("kubeconfig", "", "kubeconfig used to read the ejson keys from the cluster backup")
+	reveal := flag.Bool("reveal", false, "print the decrypted values instead of redacting them")
+	certificates := flag.Bool("certificates", false, "print the certificates under .operator/keys and when they expire")
+	flag.Parse()
+
+	kApiCr, err := readCR(*crFile)
+	if err != nil {
+		log.Fatalf("error reading the CR: %v", err)
+	}
+	if *certificates {
+		printCertificates(kApiCr, *kubeConfigPath)
+		return
+	}
+	contents, err := cr.InspectEjsonFiles(kApiCr, *kubeConfigPath, flag.Args(), *reveal)
+	if err != nil {
+		log.Fatalf("error inspecting the ejson files: %v", err)
+	}
+	for _, content := range contents {
+		fmt.Printf("%v (public key: %v)\n", content.Path, content.PublicKey)
+		for _, key := range content.Keys() {
+			fmt.Printf("  %v: %v\n", key, content.Values[key])
+		}
+	}
+}
+
+func printCertificates(kApiCr *config.KApiCr, kubeConfigPath string) {
+	scanned, err := cr.ScanCertificates(kApiCr, kubeConfigPath)
+	if err != nil {
+		log.Fatalf("error scanning the certificates: %v", err)
+	}
+	for _, certificate := range scanned {
+		fmt.Printf("%v %v\n", certificate.File, certificate.Key)
+		fmt.Printf("  common name: %v\n", certificate.CommonName)
+		fmt.Printf("  issuer: %v\n", certificate.Issuer)
+		fmt.Printf("  not after: %v\n", certificate.NotAfter.Format(time.RFC3339))
+	}
+}
+
+func readCR(crFile string) (*config.KApiCr, error) {
+	if crFile == "" {
+		return config.ReadCRSpecFromEnvYaml()
+	}
+	file, err := os.Open(crFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return config.ReadCRSpecFromFile(file)
+}
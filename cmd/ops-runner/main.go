@@ -0,0 +1,82 @@
+// ops-runner regenerates the patches of a CR when spec.opsRunner.watchBranch of its spec.git repository gets new commits
+//
+//	ops-runner [-cr cr.yaml] [-kubeconfig path] [-once] [-manifest] [-verify-keys alert|restore]
+//
+// the CR is read from -cr or the YAML_CONF environment variable. Without -once the watch branch is checked on
+// spec.opsRunner.schedule until the process is stopped, -manifest prints the CronJob running ops-runner -once instead.
+// -verify-keys overrides spec.opsRunner.verifyKeys
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/cr"
+	"github.com/qlik-oss/k-apis/pkg/opsrunner"
+)
+
+func main() {
+	crFile := flag.String("cr", "", "CR yaml file, YAML_CONF is used if not set")
+	kubeConfigPath := flag.String("kubeconfig", "", "kubeconfig of the cluster the last applied commit is recorded in")
+	once := flag.Bool("once", false, "check the watch branch once and exit")
+	manifest := flag.Bool("manifest", false, "print the CronJob manifest and exit")
+	verifyKeys := flag.String("verify-keys", "", "alert or restore, verifies the keys against the latest keys backup before generating the patches")
+	flag.Parse()
+
+	kApiCr, err := readCR(*crFile)
+	if err != nil {
+		log.Fatalf("error reading the CR: %v", err)
+	}
+	if *manifest {
+		cronJobManifest, err := opsrunner.GetCronJobManifest(kApiCr)
+		if err != nil {
+			log.Fatalf("error generating the CronJob manifest: %v", err)
+		}
+		fmt.Print(string(cronJobManifest))
+		return
+	}
+
+	runner, err := opsrunner.NewRunner(kApiCr, *kubeConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *verifyKeys != "" {
+		if runner.GeneratePatchesOptions.VerifyKeys, err = cr.ParseVerifyKeysMode(*verifyKeys); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *once {
+		if result, err := runner.Tick(); err != nil {
+			log.Fatalf("error checking the watch branch: %v", err)
+		} else if result.Applied {
+			log.Printf("generated the patches for commit: %v\n", result.Commit)
+		} else {
+			log.Printf("commit: %v was already applied\n", result.Commit)
+		}
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := runner.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
+
+func readCR(crFile string) (*config.KApiCr, error) {
+	if crFile == "" {
+		return config.ReadCRSpecFromEnvYaml()
+	}
+	file, err := os.Open(crFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return config.ReadCRSpecFromFile(file)
+}
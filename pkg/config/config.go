@@ -8,6 +8,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -31,10 +32,44 @@ func ReadCRSpecFromFile(file io.Reader) (*KApiCr, error) {
 		log.Fatal(err)
 		return nil, err
 	}
+	for _, warning := range kCr.GetWarnings() {
+		log.Printf("warning: %v\n", warning)
+	}
 
 	return &kCr, nil
 }
 
+// GetWarnings returns the warnings about the settings of the CR, they are logged when the CR is read
+func (cr *KApiCr) GetWarnings() []string {
+	if cr.Spec == nil || cr.Spec.Git == nil {
+		return nil
+	}
+	return cr.Spec.Git.GetCredentialWarnings()
+}
+
+// GetCredentialWarnings returns a warning for every credential set inline in the repo,
+// the CR keeps them in plain text and the SecretName secret should hold them instead
+func (repo *Repo) GetCredentialWarnings() []string {
+	var warnings []string
+	if repositoryUrl, err := url.Parse(repo.Repository); err == nil && strings.Contains(repo.Repository, "://") && repositoryUrl.User != nil {
+		scheme := strings.ToLower(repositoryUrl.Scheme)
+		if _, hasPassword := repositoryUrl.User.Password(); hasPassword {
+			warnings = append(warnings, "spec.git.repository embeds credentials, they are removed from the URL and only used to authenticate, "+
+				"store them in the spec.git.secretName secret instead")
+		} else if scheme == "http" || scheme == "https" {
+			warnings = append(warnings, "spec.git.repository embeds a user name without a password, it is removed from the URL and only used "+
+				"as the access token when neither spec.git nor the spec.git.secretName secret hold credentials, store it in the secret instead")
+		}
+	}
+	if repo.Password != "" {
+		warnings = append(warnings, "spec.git.password is set in the CR, store it in the spec.git.secretName secret instead")
+	}
+	if repo.AccessToken != "" {
+		warnings = append(warnings, "spec.git.accessToken is set in the CR, store it in the spec.git.secretName secret instead")
+	}
+	return warnings
+}
+
 // ReadCRSpecFromEnvYaml return CR config from env yaml
 func ReadCRSpecFromEnvYaml() (*KApiCr, error) {
 	content := os.Getenv("YAML_CONF")
@@ -226,6 +261,26 @@ func (repo *Repo) GetAccessToken() (string, error) {
 	}
 }
 
+// GetTls returns the TLS section of the spec, falling back to the flat TlsCertHost and TlsCertOrg fields
+func (crs *CRSpec) GetTls() *TlsSpec {
+	tls := &TlsSpec{}
+	if crs.Tls != nil {
+		*tls = *crs.Tls
+	}
+	if tls.CommonName == "" {
+		tls.CommonName = crs.TlsCertHost
+	}
+	if tls.Organization == "" {
+		tls.Organization = crs.TlsCertOrg
+	}
+	return tls
+}
+
+// IsEnabled is true if Enabled is yes or true, case insensitive
+func (o *OpsRunner) IsEnabled() bool {
+	return o != nil && (strings.EqualFold(o.Enabled, "yes") || strings.EqualFold(o.Enabled, "true"))
+}
+
 func (crs *CRSpec) IsEqualExceptOpsRunner(anotherSpec *CRSpec) bool {
 	selftempGitOps := crs.OpsRunner
 	othertempGitOps := anotherSpec.OpsRunner
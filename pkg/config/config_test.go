@@ -49,6 +49,24 @@ func TestReadCRSpecFromFile(t *testing.T) {
 
 }
 
+func TestGetWarnings(t *testing.T) {
+	cfg, err := ReadCRSpecFromFile(strings.NewReader(`
+  spec:
+    git:
+      repository: https://token@github.com/qlik/manifests
+      accessToken: 12345`))
+	if err != nil {
+		t.Fatalf("error reading config from file")
+	}
+	if warnings := cfg.GetWarnings(); len(warnings) != 2 || !strings.Contains(warnings[0], "user name without a password") ||
+		!strings.Contains(warnings[1], "spec.git.accessToken") {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	if warnings := (&KApiCr{Spec: &CRSpec{}}).GetWarnings(); len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got: %v", warnings)
+	}
+}
+
 func TestReadCRSpecFromEnvYaml(t *testing.T) {
 	os.Setenv("YAML_CONF", "")
 	_, err := ReadCRSpecFromEnvYaml()
@@ -339,3 +357,26 @@ func Test_GetImageRegistry(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTls(t *testing.T) {
+	cr, err := ReadCRSpecFromFile(strings.NewReader(`
+  apiVersion: qlik.com/v1
+  kind: Qliksense
+  metadata:
+    name: test-cr
+  spec:
+    tlsCertHost: elastic.flat
+    tlsCertOrg: flat-org
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tls := cr.Spec.GetTls(); tls.CommonName != "elastic.flat" || tls.Organization != "flat-org" {
+		t.Fatalf("expected the flat fields to be used, got: %v", tls)
+	}
+
+	cr.Spec.Tls = &TlsSpec{CommonName: "elastic.structured", IPAddresses: []string{"10.0.0.1"}}
+	if tls := cr.Spec.GetTls(); tls.CommonName != "elastic.structured" || tls.Organization != "flat-org" || len(tls.IPAddresses) != 1 {
+		t.Fatalf("expected the structured fields to take precedence, got: %v", tls)
+	}
+}
@@ -11,15 +11,19 @@ import (
 // customer will add patch into .configuration folder
 type CRSpec struct {
 	// relative to manifestsRoot folder, ex. ./manifests/base
-	Profile          string                `json:"profile" yaml:"profile"`
-	Secrets          map[string]NameValues `json:"secrets,omitempty" yaml:"secrets,omitempty"`
-	Configs          map[string]NameValues `json:"configs,omitempty" yaml:"configs,omitempty"`
-	ManifestsRoot    string                `json:"manifestsRoot,omitempty" yaml:"manifestsRoot,omitempty"`
-	StorageClassName string                `json:"storageClassName,omitempty" yaml:"storageClassName,omitempty"`
-	Git              *Repo                 `json:"git,omitempty" yaml:"git,omitempty"`
-	OpsRunner        *OpsRunner            `json:"opsRunner,omitempty" yaml:"opsRunner,omitempty"`
-	TlsCertHost      string                `json:"tlsCertHost,omitempty" yaml:"tlsCertHost,omitempty"`
-	TlsCertOrg       string                `json:"tlsCertOrg,omitempty" yaml:"tlsCertOrg,omitempty"`
+	Profile           string                `json:"profile" yaml:"profile"`
+	Secrets           map[string]NameValues `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Configs           map[string]NameValues `json:"configs,omitempty" yaml:"configs,omitempty"`
+	ManifestsRoot     string                `json:"manifestsRoot,omitempty" yaml:"manifestsRoot,omitempty"`
+	StorageClassName  string                `json:"storageClassName,omitempty" yaml:"storageClassName,omitempty"`
+	Git               *Repo                 `json:"git,omitempty" yaml:"git,omitempty"`
+	OpsRunner         *OpsRunner            `json:"opsRunner,omitempty" yaml:"opsRunner,omitempty"`
+	TlsCertHost       string                `json:"tlsCertHost,omitempty" yaml:"tlsCertHost,omitempty"`
+	TlsCertOrg        string                `json:"tlsCertOrg,omitempty" yaml:"tlsCertOrg,omitempty"`
+	InternalCA        *InternalCA           `json:"internalCA,omitempty" yaml:"internalCA,omitempty"`
+	Tls               *TlsSpec              `json:"tls,omitempty" yaml:"tls,omitempty"`
+	BackupStore       *BackupStore          `json:"backupStore,omitempty" yaml:"backupStore,omitempty"`
+	SecretsEncryption *SecretsEncryption    `json:"secretsEncryption,omitempty" yaml:"secretsEncryption,omitempty"`
 }
 
 type KApiCr struct {
@@ -72,19 +76,150 @@ type SecretKeyRef struct {
 }
 
 type Repo struct {
-	Repository  string `json:"repository,omitempty" yaml:"repository,omitempty"`
-	UserName    string `json:"userName,omitempty" yaml:"userName,omitempty"`
-	Password    string `json:"password,omitempty" yaml:"password,omitempty"`
+	Repository string `json:"repository,omitempty" yaml:"repository,omitempty"`
+	UserName   string `json:"userName,omitempty" yaml:"userName,omitempty"`
+	// password of UserName, kept in plain text in the CR, prefer the password of SecretName
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	// access token, kept in plain text in the CR, prefer the accessToken of SecretName
 	AccessToken string `json:"accessToken,omitempty" yaml:"accessToken,omitempty"`
-	SecretName  string `json:"secretName,omitempty" yaml:"secretName,omitempty"`
+	// secret with the userName, password, accessToken, sshPrivateKey, sshPrivateKeyPassword and knownHosts
+	// of the repository, they take precedence over the ones above
+	SecretName string `json:"secretName,omitempty" yaml:"secretName,omitempty"`
+	// authenticates to ssh repositories with the agent at SSH_AUTH_SOCK
+	UseSSHAgent bool `json:"useSSHAgent,omitempty" yaml:"useSSHAgent,omitempty"`
+	// author of the commits, userName or k-apis if empty
+	AuthorName  string `json:"authorName,omitempty" yaml:"authorName,omitempty"`
+	AuthorEmail string `json:"authorEmail,omitempty" yaml:"authorEmail,omitempty"`
+	// committer of the commits, the author if empty
+	CommitterName  string `json:"committerName,omitempty" yaml:"committerName,omitempty"`
+	CommitterEmail string `json:"committerEmail,omitempty" yaml:"committerEmail,omitempty"`
+	// gpg or ssh, signs the commits with the signingKey and signingKeyPassword of SecretName
+	SigningFormat string `json:"signingFormat,omitempty" yaml:"signingFormat,omitempty"`
+	// commits the patches on Branch and pushes it instead of a new pr-branch-* branch, if Branch moved in the meantime
+	// the patches are regenerated on top of it, not compatible with PullRequest
+	Branch string `json:"branch,omitempty" yaml:"branch,omitempty"`
+	// opens a pull request for the pushed patches if set
+	PullRequest *PullRequest `json:"pullRequest,omitempty" yaml:"pullRequest,omitempty"`
+	// how the repository is cloned into the manifests root, a full clone if nil
+	Clone *Clone `json:"clone,omitempty" yaml:"clone,omitempty"`
 }
 
+// Clone configures the clone of Repo, see git.CloneOptions
+type Clone struct {
+	// the number of commits fetched, the whole history if 0
+	Depth int `json:"depth,omitempty" yaml:"depth,omitempty"`
+	// clone only the branch the patches are based on, Branch, the pull request base branch or the ops runner watch branch
+	SingleRef bool `json:"singleRef,omitempty" yaml:"singleRef,omitempty"`
+	// a bare mirror of the repository is kept under this directory, ex. a mounted volume, and reused by the next clones
+	CacheDir string `json:"cacheDir,omitempty" yaml:"cacheDir,omitempty"`
+}
+
+// PullRequest configures the pull request opened for the patches pushed to Repo,
+// the open pull request of the same CR is updated instead of opening another one
+type PullRequest struct {
+	// github, gitlab or gitea
+	Provider string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	// REST API URL, defaults to https://api.github.com for github and https://gitlab.com/api/v4 for gitlab
+	ApiUrl string `json:"apiUrl,omitempty" yaml:"apiUrl,omitempty"`
+	// master if empty
+	BaseBranch string `json:"baseBranch,omitempty" yaml:"baseBranch,omitempty"`
+	// go templates, see cr.PullRequestTemplateData
+	TitleTemplate string   `json:"titleTemplate,omitempty" yaml:"titleTemplate,omitempty"`
+	BodyTemplate  string   `json:"bodyTemplate,omitempty" yaml:"bodyTemplate,omitempty"`
+	Labels        []string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// user names
+	Reviewers []string `json:"reviewers,omitempty" yaml:"reviewers,omitempty"`
+}
+
+// OpsRunner regenerates the patches when WatchBranch of spec.git changes, see the opsrunner package
 type OpsRunner struct {
-	Enabled     string `json:"enabled,omitempty" yaml:"enabled,omitempty"`
-	Schedule    string `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// yes or true
+	Enabled string `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// cron schedule of the checks, every 5 minutes if empty
+	Schedule string `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// master if empty
 	WatchBranch string `json:"watchBranch,omitempty" yaml:"watchBranch,omitempty"`
-	Image       string `json:"image,omitempty" yaml:"image,omitempty"`
+	// image of the CronJob, runs ops-runner
+	Image           string `json:"image,omitempty" yaml:"image,omitempty"`
 	ImagePullPolicy string `json:"imagePullPolicy,omitempty" yaml:"imagePullPolicy,omitempty"`
+	// service account of the CronJob, the default service account of the namespace if empty
+	ServiceAccountName string `json:"serviceAccountName,omitempty" yaml:"serviceAccountName,omitempty"`
+	// alert or restore, verifies the keys against the latest keys backup before the patches are generated
+	VerifyKeys string `json:"verifyKeys,omitempty" yaml:"verifyKeys,omitempty"`
+}
+
+// InternalCA configures the CA used to sign service certificates
+// if SecretName is empty a new CA is generated, otherwise the CA is imported from the kubernetes secret (tls.crt and tls.key)
+type InternalCA struct {
+	SecretName string `json:"secretName,omitempty" yaml:"secretName,omitempty"`
+	// services that need a CA signed TLS certificate, ex. elastic-infra
+	Services []string `json:"services,omitempty" yaml:"services,omitempty"`
+}
+
+// TlsSpec configures the TLS certificate generated for elastic-infra
+// TlsCertHost and TlsCertOrg are used when CommonName and Organization are not set
+type TlsSpec struct {
+	CommonName   string   `json:"commonName,omitempty" yaml:"commonName,omitempty"`
+	Organization string   `json:"organization,omitempty" yaml:"organization,omitempty"`
+	DNSNames     []string `json:"dnsNames,omitempty" yaml:"dnsNames,omitempty"`
+	IPAddresses  []string `json:"ipAddresses,omitempty" yaml:"ipAddresses,omitempty"`
+	// a duration, ex. 8760h
+	Validity string `json:"validity,omitempty" yaml:"validity,omitempty"`
+	// rsa2048, rsa4096, ecdsa256 or ecdsa384
+	KeyType string `json:"keyType,omitempty" yaml:"keyType,omitempty"`
+	// ex. digitalSignature, keyEncipherment, serverAuth, clientAuth
+	KeyUsages []string `json:"keyUsages,omitempty" yaml:"keyUsages,omitempty"`
+	// kubernetes secret (tls.crt and tls.key) holding the certificate to use instead of generating one
+	SecretName string `json:"secretName,omitempty" yaml:"secretName,omitempty"`
+	// certificates expiring within this duration are renewed by the RenewCertificates keys action, ex. 720h
+	RenewBefore string `json:"renewBefore,omitempty" yaml:"renewBefore,omitempty"`
+}
+
+// BackupStore configures where the keys backup is kept and the metadata of the backup objects
+type BackupStore struct {
+	// secret (default), directory or s3
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// local directory used by the directory store, ex. a mounted volume, the objects are kept under <directory>/<namespace>
+	Directory   string            `json:"directory,omitempty" yaml:"directory,omitempty"`
+	S3          *S3Storage        `json:"s3,omitempty" yaml:"s3,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	// the backup secrets are owned by the CR and garbage collected with it, by default they outlive the CR
+	OwnerReference bool `json:"ownerReference,omitempty" yaml:"ownerReference,omitempty"`
+	// encrypts the backup, BACKUP_ENCRYPTION_KEY_FILE or BACKUP_ENCRYPTION_KEY_SECRET are used if nil
+	Encryption *BackupEncryption `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	// number of backup generations kept, 5 if not set
+	Retention int `json:"retention,omitempty" yaml:"retention,omitempty"`
+}
+
+// BackupEncryption configures the 32 byte key, raw or base64 encoded, encrypting the keys backup
+// read from KeyFile, or from SecretKey of the SecretName secret in the CR namespace
+type BackupEncryption struct {
+	KeyFile    string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	SecretName string `json:"secretName,omitempty" yaml:"secretName,omitempty"`
+	// key if empty
+	SecretKey string `json:"secretKey,omitempty" yaml:"secretKey,omitempty"`
+}
+
+// S3Storage configures an S3 compatible object store
+// the credentials are read from the accessKeyId and secretAccessKey of CredentialsSecretName,
+// or from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY if it is empty. The objects are kept under <prefix>/<namespace>/
+type S3Storage struct {
+	Endpoint              string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	Bucket                string `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	Prefix                string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Region                string `json:"region,omitempty" yaml:"region,omitempty"`
+	CredentialsSecretName string `json:"credentialsSecretName,omitempty" yaml:"credentialsSecretName,omitempty"`
+}
+
+// SecretsEncryption selects how the CR secrets are encrypted in the generated manifests
+type SecretsEncryption struct {
+	// ejson (default) or sops-age, sops-age is rejected until the Gomplate transformer supports the sops data source
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// the age public keys sops-age encrypts to, the rendering side needs one of the private keys
+	AgeRecipients []string `json:"ageRecipients,omitempty" yaml:"ageRecipients,omitempty"`
+	// path of the sops binary of sops-age, looked up in PATH if empty
+	SopsBinary string `json:"sopsBinary,omitempty" yaml:"sopsBinary,omitempty"`
 }
 
 type CustomMetadata struct {
@@ -99,4 +234,10 @@ const (
 	KeysActionRestoreOrRotate KeysAction = ""
 	KeysActionForceRotate     KeysAction = "ForceRotate"
 	KeysActionDoNothing       KeysAction = "DoNothing"
+	// renews only the certificates that are about to expire, other keys are restored from the cluster
+	KeysActionRenewCertificates KeysAction = "RenewCertificates"
+	// only the ejson key pair is rotated, recorded in the backups taken by cr.RotateEjsonKeys
+	KeysActionRotateEjsonKeys KeysAction = "RotateEjsonKeys"
+	// the keys of a previous backup generation are restored, recorded in the backups taken by cr.RestoreKeysClusterBackupGeneration
+	KeysActionRestoreGeneration KeysAction = "RestoreGeneration"
 )
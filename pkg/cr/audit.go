@@ -0,0 +1,310 @@
+package cr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	crGit "github.com/qlik-oss/k-apis/pkg/git"
+	"github.com/qlik-oss/k-apis/pkg/qust"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// trailers of the commits of the patches
+	auditTrailerCrName       = "K-Apis-Cr-Name"
+	auditTrailerCrNamespace  = "K-Apis-Cr-Namespace"
+	auditTrailerCrGeneration = "K-Apis-Cr-Generation"
+	auditTrailerKeysAction   = "K-Apis-Keys-Action"
+
+	AuditKindConfig = "config"
+	AuditKindSecret = "secret"
+
+	auditRedactedValue = "<redacted>"
+	auditConfigsDir    = gitOpsCommitPath + "/configs/"
+	auditSecretsDir    = gitOpsCommitPath + "/secrets/"
+)
+
+// AuditOptions of GetAuditLog
+type AuditOptions struct {
+	// only the commits since Since if set
+	Since time.Time
+	// all the commits if 0
+	MaxEntries int
+	// ejson secrets are decrypted to only report the changed values, ejson encrypts every value again each time the patches
+	// are generated. The key pair is found by EjsonKeyDiscovery if neither is set, they are compared encrypted if there is none
+	EjsonKeyDir     string
+	EjsonPrivateKey string
+	// the cluster backup of the ejson keys is read with it
+	KubeConfigPath string
+}
+
+// AuditEntry is a commit of the patches of a CR
+type AuditEntry struct {
+	Commit     string
+	Author     string
+	When       time.Time
+	Name       string
+	Namespace  string
+	Generation int64
+	KeysAction string
+	Changes    []*AuditKeyChange
+}
+
+// AuditKeyChange is a config or secret key added, modified or removed by a commit
+type AuditKeyChange struct {
+	// config or secret
+	Kind    string
+	Service string
+	Key     string
+	// added, modified or removed
+	Change string
+	// always redacted, empty for a removed key
+	Value string
+}
+
+// auditValues are the values of the configs or secrets by service and key
+type auditValues map[string]map[string]string
+
+// GetAuditLog returns the commits of the patches of cr in the git history of the manifests root, newest first.
+// The commits are identified by the trailers recording the CR name, namespace, generation and keys action,
+// their changed config and secret keys are read from the .operator patches, with the values redacted.
+func GetAuditLog(cr *config.KApiCr, options *AuditOptions) ([]*AuditEntry, error) {
+	if options == nil {
+		options = &AuditOptions{}
+	}
+	options = getAuditEjsonOptions(cr, options)
+	r, err := crGit.OpenRepository(cr.Spec.GetManifestsRoot())
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository %v: %w", cr.Spec.GetManifestsRoot(), err)
+	}
+	commits, err := r.Log(&git.LogOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading the history of %v: %w", cr.Spec.GetManifestsRoot(), err)
+	}
+	defer commits.Close()
+
+	var entries []*AuditEntry
+	for {
+		commit, err := commits.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		} else if !options.Since.IsZero() && commit.Committer.When.Before(options.Since) {
+			// the history is not ordered by date, ex. merged or rebased commits
+			continue
+		}
+		trailers := crGit.GetTrailers(commit.Message)
+		if trailers[auditTrailerCrName] == "" || trailers[auditTrailerCrName] != cr.GetObjectMeta().GetName() ||
+			trailers[auditTrailerCrNamespace] != cr.GetObjectMeta().GetNamespace() {
+			continue
+		}
+		entry, err := getAuditEntry(commit, trailers, options)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the changes of commit %v: %w", commit.Hash, err)
+		}
+		if entries = append(entries, entry); options.MaxEntries > 0 && len(entries) == options.MaxEntries {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// getAuditEjsonOptions returns options with the ejson private key found by EjsonKeyDiscovery if no ejson key is set
+func getAuditEjsonOptions(cr *config.KApiCr, options *AuditOptions) *AuditOptions {
+	if options.EjsonKeyDir != "" || options.EjsonPrivateKey != "" {
+		return options
+	}
+	discoveredOptions := *options
+	discoveredOptions.EjsonKeyDir = getEjsonKeyDir(defaultEjsonKeydir)
+	discovery := &EjsonKeyDiscovery{KeyDir: discoveredOptions.EjsonKeyDir, Cr: cr, KubeConfigPath: options.KubeConfigPath}
+	_, ejsonPrivateKey, err := discovery.Discover()
+	var ambiguousErr *AmbiguousEjsonKeyError
+	if errors.As(err, &ambiguousErr) {
+		// every file is decrypted with the private key named after its public key in the key dir
+		return &discoveredOptions
+	} else if err != nil {
+		log.Printf("comparing the encrypted ejson values, error finding the ejson private key: %v\n", err)
+		return options
+	}
+	discoveredOptions.EjsonPrivateKey = ejsonPrivateKey
+	return &discoveredOptions
+}
+
+func getAuditEntry(commit *object.Commit, trailers map[string]string, options *AuditOptions) (*AuditEntry, error) {
+	entry := &AuditEntry{
+		Commit:     commit.Hash.String(),
+		Author:     commit.Author.String(),
+		When:       commit.Author.When,
+		Name:       trailers[auditTrailerCrName],
+		Namespace:  trailers[auditTrailerCrNamespace],
+		KeysAction: trailers[auditTrailerKeysAction],
+	}
+	if generation := trailers[auditTrailerCrGeneration]; generation != "" {
+		var err error
+		if entry.Generation, err = strconv.ParseInt(generation, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %v trailer: %v", auditTrailerCrGeneration, generation)
+		}
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	configs, secrets, err := readAuditValues(tree, options)
+	if err != nil {
+		return nil, err
+	}
+	// the first commit is compared to an empty tree
+	previousConfigs, previousSecrets := auditValues{}, auditValues{}
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+		if previousConfigs, previousSecrets, err = readAuditValues(parentTree, options); err != nil {
+			return nil, err
+		}
+	}
+	entry.Changes = append(getAuditKeyChanges(AuditKindConfig, previousConfigs, configs), getAuditKeyChanges(AuditKindSecret, previousSecrets, secrets)...)
+	return entry, nil
+}
+
+// readAuditValues reads the config values of the selective patches under .operator/configs and the secret values of
+// the data files under .operator/secrets/<service>, encrypted unless an ejson key is given, for the keys of their selective patches
+func readAuditValues(tree *object.Tree, options *AuditOptions) (configs auditValues, secrets auditValues, err error) {
+	configs, secrets = auditValues{}, auditValues{}
+	secretsData := make(map[string]map[string]string)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if strings.HasPrefix(file.Name, auditConfigsDir) {
+			fileName := strings.TrimPrefix(file.Name, auditConfigsDir)
+			if strings.Contains(fileName, "/") || path.Ext(fileName) != ".yaml" || fileName == "kustomization.yaml" {
+				return nil
+			}
+			return readAuditSelectivePatch(file, configs, strings.TrimSuffix(fileName, ".yaml"))
+		} else if !strings.HasPrefix(file.Name, auditSecretsDir) {
+			return nil
+		}
+		service, fileName := path.Split(strings.TrimPrefix(file.Name, auditSecretsDir))
+		service = strings.TrimSuffix(service, "/")
+		if service == "" || strings.Contains(service, "/") {
+			return nil
+		}
+		switch fileName {
+		case "selectivepatch.yaml":
+			return readAuditSelectivePatch(file, secrets, service)
+		case "edata.json", "edata.sops.json":
+			data, err := readAuditSecretsData(file, options)
+			if err != nil {
+				return err
+			}
+			secretsData[service] = data
+		}
+		return nil
+	})
+	for service, keys := range secrets {
+		for key := range keys {
+			keys[key] = secretsData[service][key]
+		}
+	}
+	return configs, secrets, err
+}
+
+// readAuditSelectivePatch adds the data of the patches of a selective patch to values
+func readAuditSelectivePatch(file *object.File, values auditValues, service string) error {
+	contents, err := file.Contents()
+	if err != nil {
+		return err
+	}
+	var selectivePatch config.SelectivePatch
+	if err := yaml.Unmarshal([]byte(contents), &selectivePatch); err != nil {
+		return fmt.Errorf("error parsing %v: %w", file.Name, err)
+	}
+	if values[service] == nil {
+		values[service] = make(map[string]string)
+	}
+	for _, patch := range selectivePatch.Patches {
+		// the data of a SuperConfigMap or a SuperSecret
+		var superConfigMap config.SupperConfigMap
+		if err := yaml.Unmarshal([]byte(patch.Patch), &superConfigMap); err != nil {
+			return fmt.Errorf("error parsing a patch of %v: %w", file.Name, err)
+		}
+		for key, value := range superConfigMap.Data {
+			values[service][key] = value
+		}
+	}
+	return nil
+}
+
+func readAuditSecretsData(file *object.File, options *AuditOptions) (map[string]string, error) {
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	if path.Base(file.Name) == "edata.json" && (options.EjsonKeyDir != "" || options.EjsonPrivateKey != "") {
+		if data, err := qust.DecryptEjsonData([]byte(contents), options.EjsonKeyDir, options.EjsonPrivateKey); err == nil {
+			return data, nil
+		} else {
+			// ex. encrypted with a rotated ejson key pair
+			log.Printf("comparing the encrypted values of %v, error decrypting it: %v\n", file.Name, err)
+		}
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(contents), &data); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %w", file.Name, err)
+	}
+	values := make(map[string]string)
+	for key, value := range data {
+		if stringValue, ok := value.(string); ok {
+			values[key] = stringValue
+		} else if jsonBytes, err := json.Marshal(value); err != nil {
+			return nil, err
+		} else {
+			values[key] = string(jsonBytes)
+		}
+	}
+	return values, nil
+}
+
+// getAuditKeyChanges returns the keys added, modified or removed from previous to current, by service and key
+func getAuditKeyChanges(kind string, previous, current auditValues) []*AuditKeyChange {
+	var changes []*AuditKeyChange
+	for service, values := range current {
+		for key, value := range values {
+			if previousValue, ok := previous[service][key]; !ok {
+				changes = append(changes, &AuditKeyChange{Kind: kind, Service: service, Key: key, Change: "added", Value: auditRedactedValue})
+			} else if previousValue != value {
+				changes = append(changes, &AuditKeyChange{Kind: kind, Service: service, Key: key, Change: "modified", Value: auditRedactedValue})
+			}
+		}
+	}
+	for service, values := range previous {
+		for key := range values {
+			if _, ok := current[service][key]; !ok {
+				changes = append(changes, &AuditKeyChange{Kind: kind, Service: service, Key: key, Change: "removed"})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Service != changes[j].Service {
+			return changes[i].Service < changes[j].Service
+		}
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
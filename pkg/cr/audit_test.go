@@ -0,0 +1,184 @@
+package cr
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/ejson"
+	goGit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	crGit "github.com/qlik-oss/k-apis/pkg/git"
+	"gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// writeAuditSelectivePatch writes a selective patch with one patch of kind holding data
+func writeAuditSelectivePatch(t *testing.T, filePath, kind string, data map[string]string) {
+	patch, err := yaml.Marshal(&config.SupperConfigMap{ApiVersion: "qlik.com/v1", Kind: kind, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	selectivePatch, err := yaml.Marshal(&config.SelectivePatch{
+		ApiVersion: "qlik.com/v1",
+		Kind:       "SelectivePatch",
+		Enabled:    true,
+		Patches:    []types.Patch{{Patch: string(patch)}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestFile(t, filePath, string(selectivePatch))
+}
+
+func commitAuditPatches(t *testing.T, r *goGit.Repository, cr *config.KApiCr, keysAction config.KeysAction) {
+	commitOptions, err := getCommitOptions(cr, keysAction, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := crGit.AddCommit(r, commitOptions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestGetAuditLog(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	r, err := goGit.PlainInit(tmpDir, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cr := &config.KApiCr{Spec: &config.CRSpec{ManifestsRoot: tmpDir, Git: &config.Repo{AuthorName: "operator", AuthorEmail: "operator@example.com"}}}
+	cr.SetName("qliksense")
+	cr.SetNamespace("qlik")
+	configsFile := filepath.Join(tmpDir, ".operator", "configs", "qliksense.yaml")
+	secretsDir := filepath.Join(tmpDir, ".operator", "secrets", "qliksense")
+
+	cr.SetGeneration(1)
+	writeAuditSelectivePatch(t, configsFile, "SuperConfigMap", map[string]string{"acceptEULA": "no"})
+	writeAuditSelectivePatch(t, filepath.Join(secretsDir, "selectivepatch.yaml"), "SuperSecret", map[string]string{"mongodbUri": `(( index (ds "data") "mongodbUri" ))`})
+	writeTestFile(t, filepath.Join(secretsDir, "edata.json"), `{"_public_key": "key", "mongodbUri": "EJ[1:mongo]"}`)
+	commitAuditPatches(t, r, cr, config.KeysActionDoNothing)
+
+	// not a commit of the patches
+	writeTestFile(t, filepath.Join(tmpDir, ".operator", "configs", "other.yaml"), "")
+	if workTree, err := r.Worktree(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := workTree.Add(".operator/configs/other.yaml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := workTree.Commit("manual change", &goGit.CommitOptions{Author: &object.Signature{Name: "someone"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cr.SetGeneration(2)
+	writeAuditSelectivePatch(t, configsFile, "SuperConfigMap", map[string]string{"acceptEULA": "yes", "caCertificates": "cert"})
+	writeAuditSelectivePatch(t, filepath.Join(secretsDir, "selectivepatch.yaml"), "SuperSecret", map[string]string{"redisPassword": `(( index (ds "data") "redisPassword" ))`})
+	writeTestFile(t, filepath.Join(secretsDir, "edata.json"), `{"_public_key": "key", "redisPassword": "EJ[1:redis]"}`)
+	commitAuditPatches(t, r, cr, config.KeysActionForceRotate)
+
+	// the patches of another CR
+	otherCr := &config.KApiCr{Spec: cr.Spec}
+	otherCr.SetName("other")
+	writeAuditSelectivePatch(t, configsFile, "SuperConfigMap", map[string]string{"acceptEULA": "no"})
+	commitAuditPatches(t, r, otherCr, config.KeysActionDoNothing)
+
+	entries, err := GetAuditLog(cr, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(entries) != 2 {
+		t.Fatalf("expected the 2 commits of the CR, got: %v", entries)
+	}
+	if entry := entries[0]; entry.Name != "qliksense" || entry.Namespace != "qlik" || entry.Generation != 2 || entry.KeysAction != "ForceRotate" ||
+		entry.Author != "operator <operator@example.com>" || entry.When.IsZero() {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	expectedChanges := []*AuditKeyChange{
+		{Kind: "config", Service: "qliksense", Key: "acceptEULA", Change: "modified", Value: "<redacted>"},
+		{Kind: "config", Service: "qliksense", Key: "caCertificates", Change: "added", Value: "<redacted>"},
+		{Kind: "secret", Service: "qliksense", Key: "mongodbUri", Change: "removed"},
+		{Kind: "secret", Service: "qliksense", Key: "redisPassword", Change: "added", Value: "<redacted>"},
+	}
+	if !reflect.DeepEqual(entries[0].Changes, expectedChanges) {
+		t.Fatalf("expected changes: %v, got: %v", formatAuditKeyChanges(expectedChanges), formatAuditKeyChanges(entries[0].Changes))
+	}
+	expectedChanges = []*AuditKeyChange{
+		{Kind: "config", Service: "qliksense", Key: "acceptEULA", Change: "added", Value: "<redacted>"},
+		{Kind: "secret", Service: "qliksense", Key: "mongodbUri", Change: "added", Value: "<redacted>"},
+	}
+	if entry := entries[1]; entry.Generation != 1 || entry.KeysAction != "DoNothing" || !reflect.DeepEqual(entry.Changes, expectedChanges) {
+		t.Fatalf("unexpected entry: %+v, changes: %v", entry, formatAuditKeyChanges(entry.Changes))
+	}
+
+	if entries, err := GetAuditLog(cr, &AuditOptions{MaxEntries: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(entries) != 1 || entries[0].Generation != 2 {
+		t.Fatalf("expected the last commit of the CR, got: %v", entries)
+	}
+	// the manual change in between has no date, the older commits are still read
+	if entries, err := GetAuditLog(cr, &AuditOptions{Since: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(entries) != 2 {
+		t.Fatalf("expected the 2 commits of the CR, got: %v", entries)
+	}
+}
+
+func TestGetAuditLog_ejsonKeyDiscovery(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	manifestsRoot := filepath.Join(tmpDir, "manifests")
+	r, err := goGit.PlainInit(manifestsRoot, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ejsonPublicKey, ejsonPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Setenv("EJSON_KEY", os.Getenv("EJSON_KEY"))
+	os.Setenv("EJSON_KEY", ejsonPrivateKey)
+
+	cr := &config.KApiCr{Spec: &config.CRSpec{ManifestsRoot: manifestsRoot, Git: &config.Repo{}}}
+	cr.SetName("qliksense")
+	cr.SetNamespace("qlik")
+	secretsDir := filepath.Join(manifestsRoot, ".operator", "secrets", "qliksense")
+	writeAuditSelectivePatch(t, filepath.Join(secretsDir, "selectivepatch.yaml"), "SuperSecret", map[string]string{"mongodbUri": `(( index (ds "data") "mongodbUri" ))`})
+	// the same value is encrypted again by every generation
+	for generation := int64(1); generation <= 2; generation++ {
+		var encrypted bytes.Buffer
+		if _, err := ejson.Encrypt(strings.NewReader(fmt.Sprintf(`{"_public_key": "%v", "mongodbUri": "mongo"}`, ejsonPublicKey)), &encrypted); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		writeTestFile(t, filepath.Join(secretsDir, "edata.json"), encrypted.String())
+		writeAuditSelectivePatch(t, filepath.Join(manifestsRoot, ".operator", "configs", "qliksense.yaml"), "SuperConfigMap",
+			map[string]string{"generation": fmt.Sprint(generation)})
+		cr.SetGeneration(generation)
+		commitAuditPatches(t, r, cr, config.KeysActionDoNothing)
+	}
+
+	entries, err := GetAuditLog(cr, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(entries) != 2 || len(entries[0].Changes) != 1 || entries[0].Changes[0].Kind != AuditKindConfig || len(entries[1].Changes) != 2 {
+		t.Fatalf("expected only the first commit to change the secret, got: %v", entries)
+	}
+}
+
+func formatAuditKeyChanges(changes []*AuditKeyChange) string {
+	var lines []string
+	for _, change := range changes {
+		lines = append(lines, fmt.Sprintf("%+v", *change))
+	}
+	return strings.Join(lines, ", ")
+}
@@ -1,10 +1,13 @@
 package cr
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/qlik-oss/k-apis/pkg/config"
 	"github.com/qlik-oss/k-apis/pkg/qust"
+	"github.com/qlik-oss/k-apis/pkg/state"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 const (
@@ -12,91 +15,106 @@ const (
 	defaultBackupObjectName = "operator-state-backup"
 )
 
-func GeneratePatches(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string) {
-	if err := createPatches(cr, keysAction, kubeConfigPath); err != nil {
-		log.Printf("error creating patches: %v\n", err)
+// VerifyKeysMode selects what GeneratePatchesWithOptions does about keys drifting from the latest keys backup
+type VerifyKeysMode string
+
+const (
+	VerifyKeysOff VerifyKeysMode = ""
+	// the drift is logged and passed to OnKeysDrift
+	VerifyKeysAlert VerifyKeysMode = "alert"
+	// the drift is alerted and the keys are restored from the backup before the patches are generated
+	VerifyKeysRestore VerifyKeysMode = "restore"
+)
+
+// ParseVerifyKeysMode returns the VerifyKeysMode of mode, empty, alert or restore
+func ParseVerifyKeysMode(mode string) (VerifyKeysMode, error) {
+	switch verifyKeys := VerifyKeysMode(mode); verifyKeys {
+	case VerifyKeysOff, VerifyKeysAlert, VerifyKeysRestore:
+		return verifyKeys, nil
+	default:
+		return "", fmt.Errorf("invalid verify keys mode: %v, alert or restore", mode)
 	}
 }
 
-/**
-// keeping for future code reference
-else {
-		var r *git.Repository
-		var auth transport.AuthMethod
-		if cr.Spec.Git.UserName != "" && cr.Spec.Git.Password != "" {
-			auth = &http.BasicAuth{
-				Username: cr.Spec.Git.UserName,
-				Password: cr.Spec.Git.Password,
-			}
-		}
-		if cr.Spec.Git.AccessToken != "" {
-			username := cr.Spec.Git.UserName
-			if username == "" {
-				username = "installer"
-			}
-			auth = &http.BasicAuth{
-				Username: username,
-				Password: cr.Spec.Git.AccessToken,
-			}
-		}
+// GeneratePatchesOptions of GeneratePatchesWithOptions
+type GeneratePatchesOptions struct {
+	// the keys are verified against the latest keys backup before the patches are generated, see VerifyKeysClusterBackup
+	VerifyKeys VerifyKeysMode
+	// called when the keys drifted, ex. to raise an alert
+	OnKeysDrift func(result *state.VerifyResult)
+	// the branch the spec.git patches are based on, spec.git.branch or the base branch of the pull requests if empty
+	BaseRef string
+}
 
-		// Clone or open
-		if _, err := os.Stat(cr.Spec.GetManifestsRoot()); os.IsNotExist(err) {
-			r, err = crGit.CloneRepository(cr.Spec.GetManifestsRoot(), cr.Spec.Git.Repository, auth)
-			if err != nil {
-				log.Printf("error cloning repository %s: %v\n", cr.Spec.Git.Repository, err)
-				return
-			}
-		} else {
-			r, err = crGit.OpenRepository(cr.Spec.GetManifestsRoot())
-			if err != nil {
-				log.Printf("error opening repository %s: %v\n", cr.Spec.Git.Repository, err)
-				return
-			}
-		}
-		//set reference to head
-		headRef, err := r.Head()
-		if err != nil {
-			log.Printf("error seeting reference: %v\n", err)
-			return
-		}
+// GeneratePatches generates the patches into the manifests root, when spec.git is set the manifests root is a clone of
+// the repository and the patches are committed to a new branch and pushed, see GitOpsResult
+func GeneratePatches(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string) (result *GitOpsResult, err error) {
+	return GeneratePatchesWithOptions(cr, keysAction, kubeConfigPath, nil)
+}
 
-		toBranch := fmt.Sprintf("pr-branch-%s", crGit.TokenGenerator())
-		//checkout to new branch
-		err = crGit.Checkout(r, headRef.Hash().String(), toBranch, auth)
-		if err != nil {
-			log.Printf("error checking out to %s: %v\n", toBranch, err)
+// GeneratePatchesWithOptions is GeneratePatches verifying the keys against the latest keys backup first if options ask for it
+func GeneratePatchesWithOptions(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string, options *GeneratePatchesOptions) (result *GitOpsResult, err error) {
+	if options == nil {
+		options = &GeneratePatchesOptions{}
+	}
+	// the backup encryption key is loaded once per run
+	resetBackupKeyProviders()
+	createVerifiedPatches := func(keysAction config.KeysAction) error {
+		if err := verifyKeys(cr, kubeConfigPath, options); err != nil {
+			return err
 		}
+		return createPatches(cr, keysAction, kubeConfigPath)
+	}
+	if cr.Spec.Git != nil && cr.Spec.Git.Repository != "" {
+		result, err = generateGitOpsPatches(cr, keysAction, kubeConfigPath, options.BaseRef, createVerifiedPatches)
+	} else {
+		err = createVerifiedPatches(keysAction)
+	}
+	if err != nil {
+		log.Printf("error creating patches: %v\n", err)
+	}
+	return result, err
+}
 
-		err = createPatches(cr, kubeConfigPath)
-		if err != nil {
-			log.Printf("error creating patches: %v\n", err)
-			return
-		}
-		//commit patches
-		err = crGit.AddCommit(r, cr.Spec.Git.UserName)
-		if err != nil {
-			log.Printf("error adding commit: %v\n", err)
-			return
-		}
-		//push patches
-		err = crGit.Push(r, auth)
-		if err != nil {
-			log.Printf("error pushing to %s: %v\n", cr.Spec.Git.Repository, err)
-			return
+// verifyKeys verifies the keys against the latest keys backup as options.VerifyKeys asks, there is nothing to verify without a backup
+func verifyKeys(cr *config.KApiCr, kubeConfigPath string, options *GeneratePatchesOptions) error {
+	if options.VerifyKeys == VerifyKeysOff {
+		return nil
+	}
+	result, err := VerifyKeysClusterBackup(cr, kubeConfigPath, options.VerifyKeys == VerifyKeysRestore)
+	if kubeApiErrors.IsNotFound(err) {
+		log.Printf("no keys backup to verify the keys against\n")
+		return nil
+	} else if result != nil && result.HasDrift() {
+		log.Printf("warning: %v keys drifted from backup generation: %v\n", len(result.Drifts), result.Generation)
+		if options.OnKeysDrift != nil {
+			options.OnKeysDrift(result)
 		}
-		//create pr
-		// err = crGit.CreatePR(cr.GetManifestsRoot(), cr.Git.AccessToken, cr.Git.UserName, toBranch)
-		// if err != nil {
-		// 	log.Printf("error creating pr against %s: %v\n", cr.Git.Repository, err)
+	}
+	return err
+}
 
-		// }
+// normalizeKeysAction returns KeysActionRestoreOrRotate for unknown keys actions
+func normalizeKeysAction(keysAction config.KeysAction) config.KeysAction {
+	if keysAction != config.KeysActionForceRotate && keysAction != config.KeysActionDoNothing && keysAction != config.KeysActionRenewCertificates {
+		return config.KeysActionRestoreOrRotate
 	}
-*/
-func createPatches(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string) error {
-	if keysAction != config.KeysActionForceRotate && keysAction != config.KeysActionDoNothing {
-		keysAction = config.KeysActionRestoreOrRotate
+	return keysAction
+}
+
+// getKeysActionName returns the name of keysAction, RestoreOrRotate for KeysActionRestoreOrRotate and unknown keys actions
+func getKeysActionName(keysAction config.KeysAction) string {
+	switch keysAction {
+	case config.KeysActionForceRotate, config.KeysActionDoNothing, config.KeysActionRenewCertificates,
+		config.KeysActionRotateEjsonKeys, config.KeysActionRestoreGeneration:
+		return string(keysAction)
+	default:
+		return "RestoreOrRotate"
 	}
+}
+
+func createPatches(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string) error {
+	keysAction = normalizeKeysAction(keysAction)
 
 	//process cr.releaseName
 	if err := qust.ProcessReleaseName(cr); err != nil {
@@ -118,7 +136,7 @@ func createPatches(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPa
 	}
 
 	// regenerate the ejson key pair, or restore it from the cluster, or read it from the environment
-	ejsonPublicKey, _, err := processEjsonKeys(cr, keysAction, kubeConfigPath, defaultEjsonKeydir)
+	ejsonPublicKey, ejsonPrivateKey, err := processEjsonKeys(cr, keysAction, kubeConfigPath, defaultEjsonKeydir)
 	if err != nil {
 		return err
 	}
@@ -134,8 +152,8 @@ func createPatches(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPa
 	}
 
 	// rotate all application keys and back them up to cluster (also backup the ejson key pair)
-	// OR restore all application keys from cluster
-	if err := finalizeKeys(cr, keysAction, kubeConfigPath, ejsonPublicKey); err != nil {
+	// OR restore all application keys from cluster (and renew the expiring certificates)
+	if err := finalizeKeys(cr, keysAction, kubeConfigPath, ejsonPublicKey, ejsonPrivateKey); err != nil {
 		return err
 	}
 
@@ -416,3 +416,68 @@ func getDirMap(dir string) (map[string][]byte, error) {
 	}
 	return dirMap, nil
 }
+
+func Test_verifyKeys(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer os.Setenv("EJSON_KEYDIR", os.Getenv("EJSON_KEYDIR"))
+	os.Setenv("EJSON_KEYDIR", filepath.Join(tmpDir, "ejson-keys"))
+	cr := &config.KApiCr{Spec: &config.CRSpec{
+		ManifestsRoot: filepath.Join(tmpDir, "manifests"),
+		BackupStore:   &config.BackupStore{Type: "directory", Directory: filepath.Join(tmpDir, "backups")},
+	}}
+	cr.SetName("qliksense")
+	cr.SetNamespace("qlik")
+	keyFile := filepath.Join(tmpDir, "manifests", ".operator", "keys", "key.pem")
+	writeTestFile(t, keyFile, "key")
+	writeTestFile(t, filepath.Join(tmpDir, "ejson-keys", "public"), "private")
+
+	var drifts []*state.VerifyResult
+	options := &GeneratePatchesOptions{VerifyKeys: VerifyKeysAlert, OnKeysDrift: func(result *state.VerifyResult) {
+		drifts = append(drifts, result)
+	}}
+	// nothing to verify without a backup
+	if err := verifyKeys(cr, "", options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := backupKeys(cr, "", config.KeysActionDoNothing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestFile(t, keyFile, "modified")
+	if err := verifyKeys(cr, "", &GeneratePatchesOptions{}); err != nil || len(drifts) != 0 {
+		t.Fatalf("expected no verification, drifts: %v, error: %v", drifts, err)
+	}
+	if err := verifyKeys(cr, "", options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(drifts) != 1 || len(drifts[0].Drifts) != 1 {
+		t.Fatalf("expected the drift of the key file, got: %v", drifts)
+	} else if data, err := ioutil.ReadFile(keyFile); err != nil || string(data) != "modified" {
+		t.Fatalf("expected the alert to keep the key file, got: %v, error: %v", string(data), err)
+	}
+
+	options.VerifyKeys = VerifyKeysRestore
+	if err := verifyKeys(cr, "", options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(drifts) != 2 {
+		t.Fatalf("expected the drift to be alerted, got: %v", drifts)
+	} else if data, err := ioutil.ReadFile(keyFile); err != nil || string(data) != "key" {
+		t.Fatalf("expected the key file to be restored, got: %v, error: %v", string(data), err)
+	}
+}
+
+func Test_getKeysActionName(t *testing.T) {
+	for keysAction, expectedName := range map[config.KeysAction]string{
+		config.KeysActionRestoreOrRotate:   "RestoreOrRotate",
+		config.KeysActionForceRotate:       "ForceRotate",
+		config.KeysActionRotateEjsonKeys:   "RotateEjsonKeys",
+		config.KeysActionRestoreGeneration: "RestoreGeneration",
+		config.KeysAction("unknown"):       "RestoreOrRotate",
+	} {
+		if name := getKeysActionName(keysAction); name != expectedName {
+			t.Fatalf("expected the name of keys action: %q to be: %v, got: %v", keysAction, expectedName, name)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package cr
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/state"
+	"golang.org/x/crypto/curve25519"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// EjsonKeyNotFoundError is returned when none of the searched sources holds the ejson key pair
+type EjsonKeyNotFoundError struct {
+	Sources []string
+	// the requested public key, if any
+	PublicKey string
+}
+
+func (e *EjsonKeyNotFoundError) Error() string {
+	if e.PublicKey != "" {
+		return fmt.Sprintf("no ejson private key for public key: %v found in: %v", e.PublicKey, strings.Join(e.Sources, ", "))
+	}
+	return fmt.Sprintf("no ejson key pair found in: %v", strings.Join(e.Sources, ", "))
+}
+
+// EjsonKeyMismatchError is returned when the requested public key is not the one of the given private key
+type EjsonKeyMismatchError struct {
+	// EJSON_KEY or the explicit private key
+	Source string
+	// the requested public key and the one of the private key
+	PublicKey        string
+	PrivateKeyPublic string
+}
+
+func (e *EjsonKeyMismatchError) Error() string {
+	return fmt.Sprintf("the ejson private key from %v belongs to public key: %v, not: %v", e.Source, e.PrivateKeyPublic, e.PublicKey)
+}
+
+// AmbiguousEjsonKeyError is returned when a key dir holds several key pairs and none was selected by public key
+type AmbiguousEjsonKeyError struct {
+	KeyDir     string
+	PublicKeys []string
+}
+
+func (e *AmbiguousEjsonKeyError) Error() string {
+	return fmt.Sprintf("the key dir: %v holds several ejson key pairs: %v, select one by public key", e.KeyDir, strings.Join(e.PublicKeys, ", "))
+}
+
+// EjsonKeyDiscovery finds the ejson key pair, in order of precedence: the explicit PrivateKey, EJSON_KEY,
+// the key dir and the cluster backup. The public key of a given private key is derived from it.
+type EjsonKeyDiscovery struct {
+	PrivateKey string
+	// selects the key pair in a key dir or cluster backup holding several
+	PublicKey string
+	// EJSON_KEYDIR or the default key dir if empty
+	KeyDir string
+	// the cluster backup is only searched if Cr is set
+	Cr             *config.KApiCr
+	KubeConfigPath string
+}
+
+// Discover returns the key pair of the first source holding one, or one of the typed errors
+// EjsonKeyNotFoundError, EjsonKeyMismatchError or AmbiguousEjsonKeyError
+func (d *EjsonKeyDiscovery) Discover() (ejsonPublicKey, ejsonPrivateKey string, err error) {
+	keyDir := d.KeyDir
+	if keyDir == "" {
+		keyDir = getEjsonKeyDir(defaultEjsonKeydir)
+	}
+
+	if d.PrivateKey != "" {
+		return d.withPrivateKey("the explicit private key", d.PrivateKey)
+	} else if privateKey := os.Getenv("EJSON_KEY"); privateKey != "" {
+		return d.withPrivateKey("EJSON_KEY", privateKey)
+	}
+
+	sources := []string{"EJSON_KEY", keyDir}
+	var notFoundErr *EjsonKeyNotFoundError
+	if ejsonPublicKey, ejsonPrivateKey, err = selectEjsonKeyPair(keyDir, d.PublicKey); err == nil {
+		return ejsonPublicKey, ejsonPrivateKey, nil
+	} else if !errors.As(err, &notFoundErr) {
+		return "", "", err
+	}
+
+	if d.Cr != nil {
+		sources = append(sources, fmt.Sprintf("the cluster backup: %v", getBackupObjectName(d.Cr)))
+		if ejsonPublicKey, ejsonPrivateKey, err = restoreEjsonKeysFromCluster(d.Cr, d.KubeConfigPath, d.PublicKey); err == nil {
+			return ejsonPublicKey, ejsonPrivateKey, nil
+		} else if !errors.As(err, &notFoundErr) && !kubeApiErrors.IsNotFound(err) {
+			return "", "", err
+		}
+	}
+	return "", "", &EjsonKeyNotFoundError{Sources: sources, PublicKey: d.PublicKey}
+}
+
+func (d *EjsonKeyDiscovery) withPrivateKey(source, privateKey string) (string, string, error) {
+	privateKey = strings.TrimSpace(privateKey)
+	publicKey, err := getEjsonPublicKey(privateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid ejson private key from %v: %w", source, err)
+	} else if d.PublicKey != "" && d.PublicKey != publicKey {
+		return "", "", &EjsonKeyMismatchError{Source: source, PublicKey: d.PublicKey, PrivateKeyPublic: publicKey}
+	}
+	return publicKey, privateKey, nil
+}
+
+// getEjsonPublicKey derives the public key of an ejson private key, both are hex encoded curve25519 keys
+func getEjsonPublicKey(privateKey string) (string, error) {
+	privateKeyBytes, err := hex.DecodeString(privateKey)
+	if err != nil {
+		return "", err
+	} else if len(privateKeyBytes) != curve25519.ScalarSize {
+		return "", fmt.Errorf("expected %v bytes, got: %v", curve25519.ScalarSize, len(privateKeyBytes))
+	}
+	publicKeyBytes, err := curve25519.X25519(privateKeyBytes, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(publicKeyBytes), nil
+}
+
+// selectEjsonKeyPair returns the key pair of publicKey in keyDir, or the only key pair if publicKey is empty
+func selectEjsonKeyPair(keyDir, publicKey string) (ejsonPublicKey, ejsonPrivateKey string, err error) {
+	if publicKey == "" {
+		fileInfos, err := ioutil.ReadDir(keyDir)
+		if err != nil && !os.IsNotExist(err) {
+			return "", "", err
+		}
+		var publicKeys []string
+		for _, fileInfo := range fileInfos {
+			if fileInfo.Mode().IsRegular() {
+				publicKeys = append(publicKeys, fileInfo.Name())
+			}
+		}
+		if len(publicKeys) == 0 {
+			return "", "", &EjsonKeyNotFoundError{Sources: []string{keyDir}}
+		} else if len(publicKeys) > 1 {
+			return "", "", &AmbiguousEjsonKeyError{KeyDir: keyDir, PublicKeys: publicKeys}
+		}
+		publicKey = publicKeys[0]
+	}
+
+	if fileContent, err := ioutil.ReadFile(filepath.Join(keyDir, publicKey)); os.IsNotExist(err) {
+		return "", "", &EjsonKeyNotFoundError{Sources: []string{keyDir}, PublicKey: publicKey}
+	} else if err != nil {
+		return "", "", err
+	} else {
+		return publicKey, strings.TrimSpace(string(fileContent)), nil
+	}
+}
+
+// restoreEjsonKeysFromCluster returns the key pair of publicKey, or the only key pair, of the cluster backup,
+// the keys are restored into a temp dir and the key dir is left alone
+func restoreEjsonKeysFromCluster(cr *config.KApiCr, kubeConfigPath, publicKey string) (ejsonPublicKey, ejsonPrivateKey string, err error) {
+	tmpKeyDir, err := ioutil.TempDir("", "ejson-keys")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tmpKeyDir)
+
+	if backupOptions, err := getBackupOptions(cr, kubeConfigPath); err != nil {
+		return "", "", err
+	} else if err := state.RestoreWithOptions(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), []state.BackupDir{
+		{Key: "ejson-keys", Directory: tmpKeyDir},
+	}, backupOptions); err != nil {
+		return "", "", err
+	}
+	return selectEjsonKeyPair(tmpKeyDir, publicKey)
+}
@@ -0,0 +1,94 @@
+package cr
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/ejson"
+)
+
+func Test_EjsonKeyDiscovery(t *testing.T) {
+	keyDir, err := ioutil.TempDir("", "ejson-keys")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(keyDir)
+	defer os.Setenv("EJSON_KEY", os.Getenv("EJSON_KEY"))
+	os.Setenv("EJSON_KEY", "")
+
+	discovery := &EjsonKeyDiscovery{KeyDir: keyDir}
+	if _, _, err := discovery.Discover(); err == nil {
+		t.Fatal("expected an error for an empty key dir")
+	} else if notFoundErr := (*EjsonKeyNotFoundError)(nil); !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected an EjsonKeyNotFoundError, got: %v", err)
+	}
+
+	var publicKeys, privateKeys []string
+	for i := 0; i < 2; i++ {
+		publicKey, privateKey, err := ejson.GenerateKeypair()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := ioutil.WriteFile(filepath.Join(keyDir, publicKey), []byte(privateKey+"\n"), os.ModePerm); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		publicKeys = append(publicKeys, publicKey)
+		privateKeys = append(privateKeys, privateKey)
+	}
+
+	// several key pairs need a selection
+	if _, _, err := discovery.Discover(); err == nil {
+		t.Fatal("expected an error for several key pairs")
+	} else if ambiguousErr := (*AmbiguousEjsonKeyError)(nil); !errors.As(err, &ambiguousErr) || len(ambiguousErr.PublicKeys) != 2 {
+		t.Fatalf("expected an AmbiguousEjsonKeyError with 2 public keys, got: %v", err)
+	}
+	discovery.PublicKey = publicKeys[1]
+	if publicKey, privateKey, err := discovery.Discover(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if publicKey != publicKeys[1] || privateKey != privateKeys[1] {
+		t.Fatalf("expected the second key pair, got: %v", publicKey)
+	}
+	discovery.PublicKey = "unknown"
+	if _, _, err := discovery.Discover(); err == nil {
+		t.Fatal("expected an error for an unknown public key")
+	} else if notFoundErr := (*EjsonKeyNotFoundError)(nil); !errors.As(err, &notFoundErr) || notFoundErr.PublicKey != "unknown" {
+		t.Fatalf("expected an EjsonKeyNotFoundError for the unknown public key, got: %v", err)
+	}
+
+	// EJSON_KEY takes precedence over the key dir, its public key is derived from it
+	discovery.PublicKey = ""
+	os.Setenv("EJSON_KEY", privateKeys[0])
+	if publicKey, privateKey, err := discovery.Discover(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if publicKey != publicKeys[0] || privateKey != privateKeys[0] {
+		t.Fatalf("expected the first key pair, got: %v", publicKey)
+	}
+	unknownPublicKey, unknownPrivateKey, _ := ejson.GenerateKeypair()
+	os.Setenv("EJSON_KEY", unknownPrivateKey)
+	if publicKey, _, err := discovery.Discover(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if publicKey != unknownPublicKey {
+		t.Fatalf("expected the public key of EJSON_KEY: %v, got: %v", unknownPublicKey, publicKey)
+	}
+	discovery.PublicKey = publicKeys[0]
+	if _, _, err := discovery.Discover(); err == nil {
+		t.Fatal("expected an error for a private key of another public key")
+	} else if mismatchErr := (*EjsonKeyMismatchError)(nil); !errors.As(err, &mismatchErr) || mismatchErr.PrivateKeyPublic != unknownPublicKey {
+		t.Fatalf("expected an EjsonKeyMismatchError, got: %v", err)
+	}
+	os.Setenv("EJSON_KEY", "invalid")
+	if _, _, err := discovery.Discover(); err == nil {
+		t.Fatal("expected an error for an invalid private key")
+	}
+
+	// and the explicit private key over EJSON_KEY
+	discovery.PublicKey = ""
+	discovery.PrivateKey = privateKeys[1]
+	if publicKey, _, err := discovery.Discover(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if publicKey != publicKeys[1] {
+		t.Fatalf("expected the second key pair, got: %v", publicKey)
+	}
+}
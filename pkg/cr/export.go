@@ -0,0 +1,136 @@
+package cr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/state"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	exportCRFile              = "cr.yaml"
+	exportEjsonPublicKeyFile  = "ejson-public-key"
+	exportEjsonPrivateKeyFile = "ejson-private-key"
+)
+
+// ExportKeysClusterBackup returns a password protected file holding the keys backup of cr with all of its generations,
+// the ejson key pair and a snapshot of cr, to move the install to another cluster with ImportKeysClusterBackup
+func ExportKeysClusterBackup(cr *config.KApiCr, kubeConfigPath string, password []byte) ([]byte, error) {
+	backupOptions, err := getBackupOptions(cr, kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	discovery := &EjsonKeyDiscovery{Cr: cr, KubeConfigPath: kubeConfigPath}
+	ejsonPublicKey, ejsonPrivateKey, err := discovery.Discover()
+	if err != nil {
+		return nil, err
+	}
+	crBytes, err := yaml.Marshal(cr)
+	if err != nil {
+		return nil, err
+	}
+	export, err := state.Export(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), map[string][]byte{
+		exportCRFile:              crBytes,
+		exportEjsonPublicKeyFile:  []byte(ejsonPublicKey),
+		exportEjsonPrivateKeyFile: []byte(ejsonPrivateKey),
+	}, password, backupOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting the keys backup: %w", err)
+	}
+	return export, nil
+}
+
+// ImportKeysClusterBackup stores the keys backup of an ExportKeysClusterBackup file as the keys backup of cr,
+// in the namespace of cr and the cluster of kubeConfigPath, and writes the ejson key pair to the ejson key dir.
+// Other ejson keys in the key dir are refused unless force is set, they are then copied to a backup directory first.
+// cr may have another name than the exported CR, the snapshot of the exported CR is returned.
+func ImportKeysClusterBackup(cr *config.KApiCr, kubeConfigPath string, export, password []byte, force bool) (*config.KApiCr, error) {
+	exported, err := state.ReadExport(export, password)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the keys backup export: %w", err)
+	}
+	ejsonPublicKey, ok := exported.Files[exportEjsonPublicKeyFile]
+	if !ok {
+		return nil, fmt.Errorf("no ejson keys found in the export")
+	}
+	keyDir := getEjsonKeyDir(defaultEjsonKeydir)
+	if differ, err := hasOtherEjsonKeys(keyDir, string(ejsonPublicKey), string(exported.Files[exportEjsonPrivateKeyFile])); err != nil {
+		return nil, err
+	} else if differ && !force {
+		return nil, fmt.Errorf("the ejson key dir: %v holds other keys than the exported ones, import with force to replace them", keyDir)
+	} else if differ {
+		backupDir := fmt.Sprintf("%v-%v", strings.TrimRight(keyDir, "/"), time.Now().UTC().Format("20060102150405"))
+		if err := copyEjsonKeys(keyDir, backupDir); err != nil {
+			return nil, fmt.Errorf("error backing up the ejson keys to: %v, error: %w", backupDir, err)
+		}
+		log.Printf("backed up the ejson keys replaced by the import to: %v\n", backupDir)
+	}
+
+	backupOptions, err := getBackupOptions(cr, kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	result, err := state.Import(kubeConfigPath, cr.GetObjectMeta().GetNamespace(), export, password, state.ImportTarget{
+		SecretName:        getBackupObjectName(cr),
+		ReleaseLabelValue: cr.GetName(),
+	}, backupOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error importing the keys backup: %w", err)
+	}
+	log.Printf("imported keys backup exported at: %v as: %v\n", result.ExportedAt, result.SecretName)
+
+	exportedCR := &config.KApiCr{}
+	if err := yaml.Unmarshal(result.Files[exportCRFile], exportedCR); err != nil {
+		return nil, fmt.Errorf("error parsing the exported CR: %w", err)
+	} else if err := rewriteEjsonKeys(defaultEjsonKeydir, string(ejsonPublicKey), string(result.Files[exportEjsonPrivateKeyFile])); err != nil {
+		return nil, err
+	}
+	return exportedCR, nil
+}
+
+// hasOtherEjsonKeys is true if keyDir holds anything but the ejson key pair ejsonPublicKey, ejsonPrivateKey
+func hasOtherEjsonKeys(keyDir, ejsonPublicKey, ejsonPrivateKey string) (bool, error) {
+	files, err := ioutil.ReadDir(keyDir)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, file := range files {
+		if file.Name() != ejsonPublicKey {
+			return true, nil
+		} else if privateKey, err := ioutil.ReadFile(filepath.Join(keyDir, file.Name())); err != nil {
+			return false, err
+		} else if strings.TrimSpace(string(privateKey)) != strings.TrimSpace(ejsonPrivateKey) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// copyEjsonKeys copies the key files of keyDir to backupDir
+func copyEjsonKeys(keyDir, backupDir string) error {
+	files, err := ioutil.ReadDir(keyDir)
+	if err != nil {
+		return err
+	} else if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		} else if data, err := ioutil.ReadFile(filepath.Join(keyDir, file.Name())); err != nil {
+			return err
+		} else if err := ioutil.WriteFile(filepath.Join(backupDir, file.Name()), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package cr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/ejson"
+	"github.com/qlik-oss/k-apis/pkg/config"
+)
+
+func TestImportKeysClusterBackup(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer os.Setenv("EJSON_KEY", os.Getenv("EJSON_KEY"))
+	defer os.Setenv("EJSON_KEYDIR", os.Getenv("EJSON_KEYDIR"))
+	os.Setenv("EJSON_KEY", "")
+	keyDir := filepath.Join(tmpDir, "ejson-keys")
+	os.Setenv("EJSON_KEYDIR", keyDir)
+	newCr := func(name string) *config.KApiCr {
+		cr := &config.KApiCr{Spec: &config.CRSpec{
+			ManifestsRoot: filepath.Join(tmpDir, name),
+			BackupStore:   &config.BackupStore{Type: "directory", Directory: filepath.Join(tmpDir, "backups")},
+		}}
+		cr.SetName(name)
+		cr.SetNamespace("qlik")
+		return cr
+	}
+
+	ejsonPublicKey, ejsonPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestFile(t, filepath.Join(keyDir, ejsonPublicKey), ejsonPrivateKey)
+	writeTestFile(t, filepath.Join(tmpDir, "exported", ".operator", "keys", "key.pem"), "key")
+	exportedCr := newCr("exported")
+	if err := backupKeys(exportedCr, "", config.KeysActionDoNothing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	export, err := ExportKeysClusterBackup(exportedCr, "", []byte("password"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the key dir already holds the exported keys
+	if importedCr, err := ImportKeysClusterBackup(newCr("same-keys"), "", export, []byte("password"), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if importedCr.GetName() != "exported" {
+		t.Fatalf("expected the snapshot of the exported CR, got: %v", importedCr.GetName())
+	}
+
+	otherPublicKey, otherPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := rewriteEjsonKeys(defaultEjsonKeydir, otherPublicKey, otherPrivateKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ImportKeysClusterBackup(newCr("other-keys"), "", export, []byte("password"), false); err == nil {
+		t.Fatal("expected the import to refuse to replace other ejson keys")
+	} else if data, err := ioutil.ReadFile(filepath.Join(keyDir, otherPublicKey)); err != nil || string(data) != otherPrivateKey {
+		t.Fatalf("expected the ejson keys to be kept, got: %v, error: %v", string(data), err)
+	}
+
+	if _, err := ImportKeysClusterBackup(newCr("other-keys"), "", export, []byte("password"), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if data, err := ioutil.ReadFile(filepath.Join(keyDir, ejsonPublicKey)); err != nil || string(data) != ejsonPrivateKey {
+		t.Fatalf("expected the exported ejson keys, got: %v, error: %v", string(data), err)
+	}
+	backupDirs, err := filepath.Glob(keyDir + "-*")
+	if err != nil || len(backupDirs) != 1 {
+		t.Fatalf("expected a backup of the replaced ejson keys, got: %v, error: %v", backupDirs, err)
+	} else if data, err := ioutil.ReadFile(filepath.Join(backupDirs[0], otherPublicKey)); err != nil || string(data) != otherPrivateKey {
+		t.Fatalf("expected the replaced ejson keys to be backed up, got: %v, error: %v", string(data), err)
+	}
+}
@@ -0,0 +1,113 @@
+package cr
+
+import (
+	"context"
+	"log"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	crGit "github.com/qlik-oss/k-apis/pkg/git"
+	"github.com/qlik-oss/k-apis/pkg/utils"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// keys of the spec.git.secretName secret, the signing key is the one of spec.git.signingFormat
+const (
+	gitSecretUserNameKey              = "userName"
+	gitSecretPasswordKey              = "password"
+	gitSecretAccessTokenKey           = "accessToken"
+	gitSecretSSHPrivateKeyKey         = "sshPrivateKey"
+	gitSecretSSHPrivateKeyPasswordKey = "sshPrivateKeyPassword"
+	gitSecretKnownHostsKey            = "knownHosts"
+	gitSecretSigningKeyKey            = "signingKey"
+	gitSecretSigningKeyPasswordKey    = "signingKeyPassword"
+)
+
+// GetGitCredentials returns the credentials of spec.git, the ones in the spec.git.secretName secret
+// take precedence over the ones in the CR
+func GetGitCredentials(cr *config.KApiCr, kubeConfigPath string) (*crGit.Credentials, error) {
+	secretData, err := getGitSecretData(cr, kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return getGitCredentials(cr.Spec.Git, secretData), nil
+}
+
+// getGitSecretData returns the data of the spec.git.secretName secret, nil if it is not set
+func getGitSecretData(cr *config.KApiCr, kubeConfigPath string) (map[string][]byte, error) {
+	if cr.Spec.Git.SecretName == "" {
+		return nil, nil
+	} else if secretsClient, err := utils.GetSecretsClient(kubeConfigPath, cr.GetObjectMeta().GetNamespace()); err != nil {
+		return nil, err
+	} else if secret, err := secretsClient.Get(context.TODO(), cr.Spec.Git.SecretName, metaV1.GetOptions{}); err != nil {
+		return nil, err
+	} else {
+		return secret.Data, nil
+	}
+}
+
+// GetGitAuth returns the auth method of spec.git.repository, nil if it takes no credentials
+func GetGitAuth(cr *config.KApiCr, kubeConfigPath string) (transport.AuthMethod, error) {
+	credentials, err := GetGitCredentials(cr, kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return crGit.NewAuthMethod(GetGitRepository(cr), credentials)
+}
+
+// GetGitRepository returns spec.git.repository without the credentials it embeds, the URL cloned, fetched and pushed to,
+// so that they never end up in .git/config or in the logs
+func GetGitRepository(cr *config.KApiCr) string {
+	repository, _ := crGit.StripUrlCredentials(cr.Spec.Git.Repository)
+	return repository
+}
+
+// GetGitCredentialWarnings returns a warning for every credential set inline in spec.git, see config.Repo.GetCredentialWarnings
+func GetGitCredentialWarnings(repo *config.Repo) []string {
+	return repo.GetCredentialWarnings()
+}
+
+// getGitCredentials returns the credentials of the spec.git.secretName secret, then the ones set inline in spec.git
+// and last the ones embedded in spec.git.repository. A user name alone in an http(s) URL is the access token
+// only if there are no other credentials, it never overrides them
+func getGitCredentials(repo *config.Repo, secretData map[string][]byte) *crGit.Credentials {
+	for _, warning := range GetGitCredentialWarnings(repo) {
+		log.Printf("warning: %v\n", warning)
+	}
+	credentials := &crGit.Credentials{
+		UserName:    repo.UserName,
+		Password:    repo.Password,
+		AccessToken: repo.AccessToken,
+		UseSSHAgent: repo.UseSSHAgent,
+	}
+	for key, value := range map[string]*string{
+		gitSecretUserNameKey:              &credentials.UserName,
+		gitSecretPasswordKey:              &credentials.Password,
+		gitSecretAccessTokenKey:           &credentials.AccessToken,
+		gitSecretSSHPrivateKeyPasswordKey: &credentials.SSHPrivateKeyPassword,
+	} {
+		if data, ok := secretData[key]; ok {
+			*value = string(data)
+		}
+	}
+	credentials.SSHPrivateKey = secretData[gitSecretSSHPrivateKeyKey]
+	credentials.KnownHosts = secretData[gitSecretKnownHostsKey]
+
+	if _, urlCredentials := crGit.StripUrlCredentials(repo.Repository); urlCredentials == nil {
+		return credentials
+	} else if urlCredentials.AccessToken != "" {
+		if credentials.UserName == "" && credentials.Password == "" && credentials.AccessToken == "" && len(credentials.SSHPrivateKey) == 0 {
+			credentials.AccessToken = urlCredentials.AccessToken
+		} else {
+			log.Printf("warning: the user name of spec.git.repository is not used as the access token, other credentials are set\n")
+		}
+	} else {
+		if credentials.UserName == "" {
+			credentials.UserName = urlCredentials.UserName
+		}
+		if credentials.Password == "" && credentials.AccessToken == "" {
+			credentials.Password = urlCredentials.Password
+		}
+	}
+	return credentials
+}
@@ -0,0 +1,260 @@
+package cr
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	crGit "github.com/qlik-oss/k-apis/pkg/git"
+)
+
+const (
+	gitOpsBranchPrefix = "pr-branch-"
+	defaultGitAuthor   = "k-apis"
+	// only the patches generated by the operator are committed
+	gitOpsCommitPath = ".operator"
+	// pushes of spec.git.branch rejected because it moved
+	maxGitOpsPushAttempts = 3
+)
+
+// GitOpsResult is the branch the patches were pushed to and the commit holding them,
+// Commit is empty if the patches did not change anything and nothing was pushed
+type GitOpsResult struct {
+	Branch string
+	Commit string
+	// the commit the patches are based on
+	BaseCommit string
+	// the pull request opened or updated for the branch if spec.git.pullRequest is set
+	PullRequestURL string
+}
+
+// GetGitCloneOptions returns the options of the clone of spec.git, only branch is cloned if spec.git.clone.singleRef is set
+func GetGitCloneOptions(cr *config.KApiCr, branch string) *crGit.CloneOptions {
+	clone := cr.Spec.Git.Clone
+	if clone == nil {
+		return &crGit.CloneOptions{}
+	}
+	options := &crGit.CloneOptions{Depth: clone.Depth, CacheDir: clone.CacheDir}
+	if clone.SingleRef {
+		options.Branch = branch
+	}
+	return options
+}
+
+// generateGitOpsPatches clones spec.git into the manifests root, or opens an existing clone,
+// runs createPatches on a new branch of baseRef, or on spec.git.branch, then commits the .operator changes and pushes the branch
+func generateGitOpsPatches(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string, baseRef string, createPatches func(keysAction config.KeysAction) error) (*GitOpsResult, error) {
+	if cr.Spec.Git.Branch != "" && cr.Spec.Git.PullRequest != nil {
+		return nil, fmt.Errorf("spec.git.branch and spec.git.pullRequest can not be set together")
+	} else if cr.Spec.Git.Branch != "" && baseRef != "" && baseRef != cr.Spec.Git.Branch {
+		return nil, fmt.Errorf("the patches pushed to spec.git.branch: %v can not be based on: %v", cr.Spec.Git.Branch, baseRef)
+	}
+	secretData, err := getGitSecretData(cr, kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the git credentials: %w", err)
+	}
+	repository := GetGitRepository(cr)
+	credentials := getGitCredentials(cr.Spec.Git, secretData)
+	auth, err := crGit.NewAuthMethod(repository, credentials)
+	if err != nil {
+		return nil, err
+	}
+	commitOptions, err := getCommitOptions(cr, keysAction, secretData)
+	if err != nil {
+		return nil, err
+	}
+	manifestsRoot := cr.Spec.GetManifestsRoot()
+	// the patches are based on baseRef, spec.git.branch, or the base branch of the pull requests
+	baseBranch := getPullRequestBaseBranch(cr.Spec.Git.PullRequest)
+	if baseRef != "" {
+		baseBranch = baseRef
+	} else if cr.Spec.Git.Branch != "" {
+		baseBranch = cr.Spec.Git.Branch
+	}
+
+	var r *git.Repository
+	if _, statErr := os.Stat(manifestsRoot); os.IsNotExist(statErr) {
+		if r, err = crGit.CloneRepositoryWithOptions(manifestsRoot, repository, auth, GetGitCloneOptions(cr, baseBranch)); err != nil {
+			return nil, fmt.Errorf("error cloning repository %v: %w", repository, err)
+		}
+	} else if r, err = crGit.OpenRepository(manifestsRoot); err != nil {
+		return nil, fmt.Errorf("error opening repository %v: %w", manifestsRoot, err)
+	} else if err := crGit.RemoveRemoteCredentials(r); err != nil {
+		return nil, fmt.Errorf("error removing the credentials of the remotes of %v: %w", manifestsRoot, err)
+	} else if err := crGit.DiscardAllUnstagedChanges(r); err != nil {
+		// left over by a previous run
+		return nil, fmt.Errorf("error discarding the unstaged changes of %v: %w", manifestsRoot, err)
+	}
+	if cr.Spec.Git.Branch != "" {
+		return generateGitOpsBranchPatches(cr, r, auth, commitOptions, keysAction, createPatches)
+	}
+
+	// a reused clone is behind the remote, the branch of the patches starts from the fetched base branch
+	base, err := crGit.FetchRef(r, "refs/heads/"+baseBranch, auth)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %v: %w", baseBranch, err)
+	}
+	result := &GitOpsResult{Branch: gitOpsBranchPrefix + crGit.TokenGenerator(), BaseCommit: base.String()}
+	if err := crGit.Checkout(r, base.String(), result.Branch, auth); err != nil {
+		return nil, fmt.Errorf("error checking out to %v: %w", result.Branch, err)
+	}
+
+	if err := createPatches(keysAction); err != nil {
+		return nil, err
+	}
+
+	if hash, err := crGit.AddCommit(r, commitOptions); err != nil {
+		return nil, fmt.Errorf("error committing the patches: %w", err)
+	} else if hash.IsZero() {
+		log.Printf("the patches did not change %v, nothing to push\n", gitOpsCommitPath)
+		return result, nil
+	} else {
+		result.Commit = hash.String()
+	}
+
+	var pullRequestProvider crGit.PullRequestProvider
+	var openPullRequest *crGit.PullRequest
+	if cr.Spec.Git.PullRequest != nil {
+		if pullRequestProvider, err = newPullRequestProvider(cr.Spec.Git, credentials); err != nil {
+			return nil, err
+		} else if openPullRequest, err = pullRequestProvider.FindOpenPullRequest(getPullRequestMarker(cr), gitOpsBranchPrefix); err != nil {
+			return nil, fmt.Errorf("error finding the open pull request: %w", err)
+		}
+	}
+	if openPullRequest != nil {
+		// the patches replace the branch of the open pull request of the CR
+		if err := crGit.ForcePushBranch(r, result.Branch, openPullRequest.Head, auth); err != nil {
+			return nil, fmt.Errorf("error pushing %v to %v: %w", openPullRequest.Head, repository, err)
+		}
+		result.Branch = openPullRequest.Head
+	} else if err := crGit.PushBranch(r, result.Branch, auth); err != nil {
+		return nil, fmt.Errorf("error pushing %v to %v: %w", result.Branch, repository, err)
+	}
+	log.Printf("pushed the patches to branch: %v, commit: %v\n", result.Branch, result.Commit)
+
+	if pullRequestProvider != nil {
+		if result.PullRequestURL, err = createOrUpdatePullRequest(cr, r, pullRequestProvider, openPullRequest, result); err != nil {
+			return nil, err
+		}
+		log.Printf("pull request: %v\n", result.PullRequestURL)
+	}
+	return result, nil
+}
+
+// generateGitOpsBranchPatches commits the patches on top of spec.git.branch and pushes it, if the branch moved since it was
+// fetched the push is rejected and the patches are regenerated on top of the new commits, at most maxGitOpsPushAttempts times
+func generateGitOpsBranchPatches(cr *config.KApiCr, r *git.Repository, auth transport.AuthMethod, commitOptions *crGit.CommitOptions,
+	keysAction config.KeysAction, createPatches func(keysAction config.KeysAction) error) (*GitOpsResult, error) {
+	branch := cr.Spec.Git.Branch
+	result := &GitOpsResult{Branch: branch}
+	for attempt := 1; ; attempt++ {
+		upstream, err := crGit.FetchRef(r, "refs/heads/"+branch, auth)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching %v: %w", branch, err)
+		} else if err := crGit.ResetBranch(r, branch, *upstream); err != nil {
+			return nil, fmt.Errorf("error resetting %v to %v: %w", branch, upstream, err)
+		}
+		result.BaseCommit = upstream.String()
+
+		if err := createPatches(keysAction); err != nil {
+			return nil, err
+		}
+		if hash, err := crGit.AddCommit(r, commitOptions); err != nil {
+			return nil, fmt.Errorf("error committing the patches: %w", err)
+		} else if hash.IsZero() {
+			log.Printf("the patches did not change %v, nothing to push\n", gitOpsCommitPath)
+			result.Commit = ""
+			return result, nil
+		} else {
+			result.Commit = hash.String()
+		}
+
+		if err := crGit.PushBranch(r, branch, auth); err == nil {
+			log.Printf("pushed the patches to branch: %v, commit: %v\n", branch, result.Commit)
+			return result, nil
+		} else if !crGit.IsNonFastForwardError(err) {
+			return nil, fmt.Errorf("error pushing %v to %v: %w", branch, GetGitRepository(cr), err)
+		} else if attempt == maxGitOpsPushAttempts {
+			return nil, fmt.Errorf("%v moved during each of the %v attempts to push the patches: %w", branch, maxGitOpsPushAttempts, err)
+		}
+		log.Printf("%v moved since it was fetched, regenerating the patches on top of it\n", branch)
+		keysAction = getRegenerationKeysAction(keysAction)
+	}
+}
+
+// getRegenerationKeysAction returns the keys action regenerating the patches with the keys of the previous attempt,
+// the keys it rotated or renewed were backed up to the cluster and are restored instead of being rotated again
+func getRegenerationKeysAction(keysAction config.KeysAction) config.KeysAction {
+	if keysAction == config.KeysActionDoNothing {
+		return keysAction
+	}
+	return config.KeysActionRestoreOrRotate
+}
+
+// getCommitOptions returns the options of the commit of the patches, its message and trailers name the CR and the keys action
+func getCommitOptions(cr *config.KApiCr, keysAction config.KeysAction, secretData map[string][]byte) (*crGit.CommitOptions, error) {
+	repo := cr.Spec.Git
+	commitOptions := &crGit.CommitOptions{
+		Path: gitOpsCommitPath,
+		Message: fmt.Sprintf("k-apis: update %v/%v\n\nKeys action: %v", cr.GetObjectMeta().GetNamespace(), cr.GetObjectMeta().GetName(),
+			getKeysActionName(keysAction)),
+		Author: &object.Signature{Name: repo.AuthorName, Email: repo.AuthorEmail},
+		// identify the commits of the CR for GetAuditLog
+		Trailers: []crGit.Trailer{
+			{Key: auditTrailerCrName, Value: cr.GetObjectMeta().GetName()},
+			{Key: auditTrailerCrNamespace, Value: cr.GetObjectMeta().GetNamespace()},
+			{Key: auditTrailerCrGeneration, Value: strconv.FormatInt(cr.GetObjectMeta().GetGeneration(), 10)},
+			{Key: auditTrailerKeysAction, Value: getKeysActionName(keysAction)},
+		},
+	}
+	if commitOptions.Author.Name == "" {
+		commitOptions.Author.Name = repo.UserName
+	}
+	if commitOptions.Author.Name == "" {
+		commitOptions.Author.Name = defaultGitAuthor
+	}
+	if repo.CommitterName != "" || repo.CommitterEmail != "" {
+		commitOptions.Committer = &object.Signature{Name: repo.CommitterName, Email: repo.CommitterEmail}
+		if commitOptions.Committer.Name == "" {
+			commitOptions.Committer.Name = commitOptions.Author.Name
+		}
+	}
+	if repo.SigningFormat != "" {
+		signingKey, ok := secretData[gitSecretSigningKeyKey]
+		if !ok {
+			return nil, fmt.Errorf("signingFormat: %v is set but the secret: %v has no %v", repo.SigningFormat, repo.SecretName, gitSecretSigningKeyKey)
+		}
+		commitOptions.SigningKey = &crGit.SigningKey{
+			Format:   repo.SigningFormat,
+			Key:      signingKey,
+			Password: string(secretData[gitSecretSigningKeyPasswordKey]),
+		}
+	}
+	return commitOptions, nil
+}
+
+func createOrUpdatePullRequest(cr *config.KApiCr, r *git.Repository, provider crGit.PullRequestProvider, openPullRequest *crGit.PullRequest, result *GitOpsResult) (string, error) {
+	changedFiles, err := crGit.GetChangedFiles(r, plumbing.NewHash(result.Commit))
+	if err != nil {
+		return "", err
+	}
+	pullRequest, err := getPullRequest(cr, result.Branch, result.Commit, changedFiles)
+	if err != nil {
+		return "", err
+	}
+	if openPullRequest != nil {
+		pullRequest.Number = openPullRequest.Number
+		if pullRequest, err = provider.UpdatePullRequest(pullRequest); err != nil {
+			return "", fmt.Errorf("error updating pull request %v: %w", openPullRequest.Number, err)
+		}
+	} else if pullRequest, err = provider.CreatePullRequest(pullRequest); err != nil {
+		return "", fmt.Errorf("error creating the pull request: %w", err)
+	}
+	return pullRequest.URL, nil
+}
@@ -0,0 +1,50 @@
+package cr
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/qust"
+)
+
+// InspectEjsonFiles decrypts the given ejson files, ex. edata.json, eprivate_key.json or ejwks.json,
+// or every ejson file under .operator if none is given. The private key is found by EjsonKeyDiscovery:
+// EJSON_KEY, the ejson key dir (EJSON_KEYDIR or the default) or the cluster backup, in that order.
+// Values are redacted unless reveal is set.
+func InspectEjsonFiles(cr *config.KApiCr, kubeConfigPath string, filePaths []string, reveal bool) (contents []*qust.EjsonFileContent, err error) {
+	ejsonKeyDir := getEjsonKeyDir(defaultEjsonKeydir)
+	// inspecting must not change the key dir, the cluster backup keys are only used in memory
+	discovery := &EjsonKeyDiscovery{KeyDir: ejsonKeyDir, Cr: cr, KubeConfigPath: kubeConfigPath}
+	_, ejsonPrivateKey, err := discovery.Discover()
+	var ambiguousErr *AmbiguousEjsonKeyError
+	if errors.As(err, &ambiguousErr) {
+		// every file is decrypted with the private key named after its public key in the key dir
+		ejsonPrivateKey = ""
+	} else if err != nil {
+		return nil, fmt.Errorf("error finding the ejson private key: %w", err)
+	}
+
+	if len(filePaths) == 0 {
+		return qust.InspectEjsonFiles(cr.Spec, ejsonKeyDir, ejsonPrivateKey, reveal)
+	}
+	for _, filePath := range filePaths {
+		if content, err := qust.InspectEjsonFile(filePath, ejsonKeyDir, ejsonPrivateKey, reveal); err != nil {
+			return nil, err
+		} else {
+			contents = append(contents, content)
+		}
+	}
+	return contents, nil
+}
+
+// ScanCertificates reports the certificates held by the ejson files under .operator/keys, ex. to check when they expire,
+// the private key is found by EjsonKeyDiscovery like for InspectEjsonFiles
+func ScanCertificates(cr *config.KApiCr, kubeConfigPath string) ([]*qust.ScannedCertificate, error) {
+	discovery := &EjsonKeyDiscovery{KeyDir: getEjsonKeyDir(defaultEjsonKeydir), Cr: cr, KubeConfigPath: kubeConfigPath}
+	_, ejsonPrivateKey, err := discovery.Discover()
+	if err != nil {
+		return nil, fmt.Errorf("error finding the ejson private key: %w", err)
+	}
+	return qust.ScanCertificates(cr.Spec, ejsonPrivateKey)
+}
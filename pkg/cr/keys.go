@@ -2,6 +2,8 @@ package cr
 
 import (
 	"context"
+	cryptoTls "crypto/tls"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -9,121 +11,316 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/qlik-oss/k-apis/pkg/keys"
 	"github.com/qlik-oss/k-apis/pkg/utils"
+	v1 "k8s.io/api/core/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/Shopify/ejson"
 	"github.com/qlik-oss/k-apis/pkg/config"
 	"github.com/qlik-oss/k-apis/pkg/qust"
 	"github.com/qlik-oss/k-apis/pkg/state"
-	"k8s.io/apimachinery/pkg/api/errors"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
-func finalizeKeys(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string, ejsonPublicKey string) error {
+const (
+	defaultCAValidity  = time.Hour * 24 * 365 * 10
+	defaultRenewBefore = time.Hour * 24 * 30
+)
+
+func finalizeKeys(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string, ejsonPublicKey, ejsonPrivateKey string) error {
 	if keysAction == config.KeysActionDoNothing {
 		log.Println("no keys operations")
 		return nil
 	}
 
+	backupOptions, err := getBackupOptions(cr, kubeConfigPath)
+	if err != nil {
+		return err
+	}
+
 	keysFound := false
-	if keysAction == config.KeysActionRestoreOrRotate {
-		if err := state.Restore(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), []state.BackupDir{
+	if keysAction == config.KeysActionRestoreOrRotate || keysAction == config.KeysActionRenewCertificates {
+		if err := state.RestoreWithOptions(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), []state.BackupDir{
 			{Key: "operator-keys", Directory: filepath.Join(cr.Spec.GetManifestsRoot(), ".operator/keys")},
-		}); err != nil {
-			if !errors.IsNotFound(err) {
+		}, backupOptions); err != nil {
+			if !kubeApiErrors.IsNotFound(err) {
 				return fmt.Errorf("error restoring keys from the cluster: %w", err)
 			}
 		} else {
 			log.Println("restored application keys from the cluster")
 			keysFound = true
+			// backups taken before encryption was enabled are encrypted in place
+			if migrated, err := state.Migrate(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), backupOptions); err != nil {
+				return fmt.Errorf("error encrypting the existing keys backup: %w", err)
+			} else if migrated {
+				log.Println("encrypted the existing keys backup in the cluster")
+			}
 		}
 	}
 
 	if keysAction == config.KeysActionForceRotate || !keysFound {
-		if err := qust.GenerateKeys(cr.Spec, ejsonPublicKey); err != nil {
+		keysOptions, err := getKeysOptions(cr, kubeConfigPath, true)
+		if err != nil {
+			return err
+		}
+		if err := qust.GenerateKeysWithOptions(cr.Spec, ejsonPublicKey, keysOptions); err != nil {
 			return fmt.Errorf("error generating application keys: %w", err)
+		} else if err := qust.GenerateCertificates(cr, ejsonPublicKey, keysOptions.CA); err != nil {
+			return fmt.Errorf("error generating service certificates: %w", err)
 		} else {
 			log.Println("generated application keys")
-			if err := state.Backup(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), cr.GetName(), []state.BackupDir{
-				{Key: "operator-keys", Directory: filepath.Join(cr.Spec.GetManifestsRoot(), ".operator/keys")},
-				{Key: "ejson-keys", Directory: getEjsonKeyDir(defaultEjsonKeydir)},
-			}); err != nil {
-				return fmt.Errorf("error backing up keys to the cluster: %w", err)
+			if err := backupKeys(cr, kubeConfigPath, keysAction); err != nil {
+				return err
 			}
 			log.Println("backed up application keys to the cluster")
 		}
+	} else if keysAction == config.KeysActionRenewCertificates {
+		if err := renewCertificates(cr, kubeConfigPath, ejsonPublicKey, ejsonPrivateKey); err != nil {
+			return err
+		}
+	} else if cr.Spec.InternalCA != nil {
+		// the internal CA was enabled, or replaced, after the keys had been backed up
+		certificatesExist := qust.CertificatesExist(cr.Spec)
+		keysOptions, err := getKeysOptions(cr, kubeConfigPath, !certificatesExist)
+		if err != nil {
+			return err
+		}
+		if !certificatesExist {
+			if err := qust.GenerateCertificates(cr, ejsonPublicKey, keysOptions.CA); err != nil {
+				return fmt.Errorf("error generating service certificates: %w", err)
+			}
+			log.Println("generated service certificates")
+		}
+		reissued, err := qust.ReissueElasticInfraCertificate(cr, ejsonPrivateKey, keysOptions)
+		if err != nil {
+			return fmt.Errorf("error re-issuing the elastic-infra certificate: %w", err)
+		} else if reissued {
+			log.Println("re-issued the elastic-infra certificate signed by the internal CA")
+		}
+		if !certificatesExist || reissued {
+			if err := backupKeys(cr, kubeConfigPath, keysAction); err != nil {
+				return err
+			}
+			log.Println("backed up the certificates to the cluster")
+		}
 	}
 
 	return nil
 }
 
-func extractEjsonKeysFromTheEnvironment() (ejsonPublicKey, ejsonPrivateKey string) {
-	if ejsonPrivateKey = os.Getenv("EJSON_KEY"); ejsonPrivateKey != "" {
-		ejsonKeyDir := os.Getenv("EJSON_KEYDIR")
-		if ejsonKeyDir == "" {
-			ejsonKeyDir = defaultEjsonKeydir
+// renewCertificates re-issues the restored certificates expiring within tls.renewBefore and backs them up
+func renewCertificates(cr *config.KApiCr, kubeConfigPath, ejsonPublicKey, ejsonPrivateKey string) error {
+	renewBefore := defaultRenewBefore
+	if tls := cr.Spec.GetTls(); tls.RenewBefore != "" {
+		var err error
+		if renewBefore, err = time.ParseDuration(tls.RenewBefore); err != nil {
+			return fmt.Errorf("invalid TLS renewBefore: %v, error: %w", tls.RenewBefore, err)
 		}
-		if fileInfos, err := ioutil.ReadDir(ejsonKeyDir); err != nil {
-			log.Printf("failed listing the EJSON_KEYDIR: %v\n", ejsonKeyDir)
-			return "", ""
-		} else {
-			for _, fileInfo := range fileInfos {
-				if fileInfo.Mode().IsRegular() {
-					possibleEjsonPublicKeyFilePath := filepath.Join(ejsonKeyDir, fileInfo.Name())
-					if fileContent, err := ioutil.ReadFile(possibleEjsonPublicKeyFilePath); err != nil {
-						log.Printf("failed reading file: %v while trying to find the EJSON publicKey\n", possibleEjsonPublicKeyFilePath)
-						return "", ""
-					} else if strings.TrimSpace(string(fileContent)) == ejsonPrivateKey {
-						ejsonPublicKey = fileInfo.Name()
-						return ejsonPublicKey, ejsonPrivateKey
-					}
-				}
-			}
+	}
+	// only an imported CA replaces the restored one, a new CA is generated by RenewCertificates when it expires
+	keysOptions, err := getKeysOptions(cr, kubeConfigPath, false)
+	if err != nil {
+		return err
+	}
+	renewed, err := qust.RenewCertificates(cr, ejsonPublicKey, ejsonPrivateKey, renewBefore, keysOptions)
+	if err != nil {
+		return fmt.Errorf("error renewing certificates: %w", err)
+	} else if len(renewed) == 0 {
+		log.Printf("no certificates expire within: %v\n", renewBefore)
+		return nil
+	} else if err := backupKeys(cr, kubeConfigPath, config.KeysActionRenewCertificates); err != nil {
+		return err
+	}
+	log.Printf("renewed certificates: %v and backed them up to the cluster\n", strings.Join(renewed, ", "))
+	return nil
+}
+
+// backupKeys backs up the application keys and the ejson key pair as a new backup generation
+func backupKeys(cr *config.KApiCr, kubeConfigPath string, keysAction config.KeysAction) error {
+	return backupRestoredKeys(cr, kubeConfigPath, keysAction, nil)
+}
+
+// backupRestoredKeys is backupKeys recording the backup generation the keys were restored from, if restoredGeneration is set
+func backupRestoredKeys(cr *config.KApiCr, kubeConfigPath string, keysAction config.KeysAction, restoredGeneration *int) error {
+	backupOptions, err := getBackupOptions(cr, kubeConfigPath)
+	if err != nil {
+		return err
+	}
+	backupOptions.CRGeneration = cr.GetObjectMeta().GetGeneration()
+	backupOptions.RestoredGeneration = restoredGeneration
+	backupOptions.KeysAction = getKeysActionName(keysAction)
+	if err := state.BackupWithOptions(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), cr.GetName(), []state.BackupDir{
+		{Key: "operator-keys", Directory: filepath.Join(cr.Spec.GetManifestsRoot(), ".operator/keys")},
+		{Key: "ejson-keys", Directory: getEjsonKeyDir(defaultEjsonKeydir)},
+	}, backupOptions); state.IsConflict(err) {
+		// the keys of this run were generated from an outdated backup, overwriting would lose the newer keys
+		return fmt.Errorf("another operator backed up the keys concurrently, restore the keys from the latest backup and retry: %w", err)
+	} else if err != nil {
+		return fmt.Errorf("error backing up keys to the cluster: %w", err)
+	}
+	return nil
+}
+
+// getBackupOptions encrypts the keys backup as the backup store of the CR configures, see getBackupKeyProvider,
+// keeps the retention and sets the metadata configured in the backup store of the CR
+func getBackupOptions(cr *config.KApiCr, kubeConfigPath string) (*state.Options, error) {
+	backupOptions := &state.Options{}
+	var err error
+	if backupOptions.Store, err = getBackupStore(cr, kubeConfigPath); err != nil {
+		return nil, fmt.Errorf("error configuring the backup store: %w", err)
+	}
+	if backupStore := cr.Spec.BackupStore; backupStore != nil {
+		backupOptions.Labels = backupStore.Labels
+		backupOptions.Annotations = backupStore.Annotations
+		backupOptions.Retention = backupStore.Retention
+		if backupStore.OwnerReference && cr.GetUID() == "" {
+			log.Println("not setting the owner reference of the keys backup, the CR has no uid")
+		} else if backupStore.OwnerReference {
+			backupOptions.OwnerReferences = []metaV1.OwnerReference{{
+				APIVersion: cr.APIVersion,
+				Kind:       cr.Kind,
+				Name:       cr.GetName(),
+				UID:        cr.GetUID(),
+			}}
 		}
-	} else {
-		ejsonKeyDir := os.Getenv("EJSON_KEYDIR")
-		if ejsonKeyDir == "" {
-			ejsonKeyDir = defaultEjsonKeydir
-		}
-		if fileInfos, err := ioutil.ReadDir(ejsonKeyDir); err != nil {
-			log.Printf("failed listing the EJSON_KEYDIR: %v\n", ejsonKeyDir)
-			return "", ""
-		} else if len(fileInfos) == 1 {
-			possibleEjsonPublicKeyFilePath := filepath.Join(ejsonKeyDir, fileInfos[0].Name())
-			if fileContent, err := ioutil.ReadFile(possibleEjsonPublicKeyFilePath); err != nil {
-				log.Printf("failed reading file: %v while trying to find the EJSON publicKey\n", possibleEjsonPublicKeyFilePath)
-				return "", ""
+	}
+	if backupOptions.KeyProvider, err = getBackupKeyProvider(cr, kubeConfigPath); err != nil {
+		return nil, err
+	}
+	return backupOptions, nil
+}
+
+// getBackupStore returns the store configured in cr.Spec.BackupStore, nil for the default kubernetes secrets
+func getBackupStore(cr *config.KApiCr, kubeConfigPath string) (state.BackupStore, error) {
+	if cr.Spec.BackupStore == nil {
+		return nil, nil
+	}
+	switch cr.Spec.BackupStore.Type {
+	case "", "secret":
+		return nil, nil
+	case "directory":
+		if cr.Spec.BackupStore.Directory == "" {
+			return nil, fmt.Errorf("the directory backup store requires a directory")
+		}
+		return state.NewDirectoryStore(cr.Spec.BackupStore.Directory, cr.GetObjectMeta().GetNamespace())
+	case "s3":
+		s3Storage := cr.Spec.BackupStore.S3
+		if s3Storage == nil {
+			return nil, fmt.Errorf("the s3 backup store requires the s3 settings")
+		}
+		s3Options := state.S3Options{
+			Endpoint:        s3Storage.Endpoint,
+			Bucket:          s3Storage.Bucket,
+			Prefix:          s3Storage.Prefix,
+			Namespace:       cr.GetObjectMeta().GetNamespace(),
+			Region:          s3Storage.Region,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+		if s3Storage.CredentialsSecretName != "" {
+			if secretsClient, err := utils.GetSecretsClient(kubeConfigPath, cr.GetObjectMeta().GetNamespace()); err != nil {
+				return nil, err
+			} else if secret, err := secretsClient.Get(context.TODO(), s3Storage.CredentialsSecretName, metaV1.GetOptions{}); err != nil {
+				return nil, err
 			} else {
-				ejsonPublicKey = fileInfos[0].Name()
-				ejsonPrivateKey = strings.TrimSpace(string(fileContent))
-				return ejsonPublicKey, ejsonPrivateKey
+				s3Options.AccessKeyID = string(secret.Data["accessKeyId"])
+				s3Options.SecretAccessKey = string(secret.Data["secretAccessKey"])
 			}
 		}
+		return state.NewS3Store(s3Options)
+	default:
+		return nil, fmt.Errorf("unsupported backup store type: %v", cr.Spec.BackupStore.Type)
+	}
+}
+
+// getKeysOptions resolves the internal CA and the user supplied TLS certificate referenced in the CR
+func getKeysOptions(cr *config.KApiCr, kubeConfigPath string, generateCA bool) (*qust.KeysOptions, error) {
+	keysOptions := &qust.KeysOptions{ServiceDNSNames: qust.GetServiceDNSNames(cr, "elastic-infra")}
+	var err error
+	if keysOptions.CA, err = getInternalCA(cr, kubeConfigPath, generateCA); err != nil {
+		return nil, fmt.Errorf("error loading the internal CA: %w", err)
+	}
+	if tls := cr.Spec.GetTls(); tls.SecretName != "" {
+		if keysOptions.TlsCertificate, keysOptions.TlsKey, err = readTlsSecret(cr, kubeConfigPath, tls.SecretName); err != nil {
+			return nil, fmt.Errorf("error loading the TLS certificate from secret: %v, error: %w", tls.SecretName, err)
+		} else if _, err := cryptoTls.X509KeyPair(keysOptions.TlsCertificate, keysOptions.TlsKey); err != nil {
+			return nil, fmt.Errorf("invalid TLS certificate in secret: %v, error: %w", tls.SecretName, err)
+		}
+		log.Printf("using the TLS certificate from secret: %v\n", tls.SecretName)
+	}
+	return keysOptions, nil
+}
+
+// getInternalCA imports the CA from the kubernetes secret referenced in the CR, or generates a new one if generate is true
+// returns nil if the internal CA is not enabled
+func getInternalCA(cr *config.KApiCr, kubeConfigPath string, generate bool) (*keys.CertificateAuthority, error) {
+	if cr.Spec.InternalCA == nil {
+		return nil, nil
+	}
+	if cr.Spec.InternalCA.SecretName == "" {
+		if !generate {
+			return nil, nil
+		}
+		return keys.GenerateCertificateAuthority(fmt.Sprintf("%v-internal-ca", cr.GetName()), cr.Spec.GetTls().Organization, defaultCAValidity)
+	}
+	if certificate, key, err := readTlsSecret(cr, kubeConfigPath, cr.Spec.InternalCA.SecretName); err != nil {
+		return nil, err
+	} else if ca, err := keys.LoadCertificateAuthority(certificate, key); err != nil {
+		return nil, fmt.Errorf("error loading the CA from secret: %v, error: %w", cr.Spec.InternalCA.SecretName, err)
+	} else {
+		log.Printf("imported the internal CA from secret: %v\n", cr.Spec.InternalCA.SecretName)
+		return ca, nil
+	}
+}
+
+// readTlsSecret returns tls.crt and tls.key of a kubernetes secret in the CR namespace
+func readTlsSecret(cr *config.KApiCr, kubeConfigPath, secretName string) (certificate, key []byte, err error) {
+	if secretsClient, err := utils.GetSecretsClient(kubeConfigPath, cr.GetObjectMeta().GetNamespace()); err != nil {
+		return nil, nil, err
+	} else if secret, err := secretsClient.Get(context.TODO(), secretName, metaV1.GetOptions{}); err != nil {
+		return nil, nil, err
+	} else {
+		return secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey], nil
 	}
-	return "", ""
 }
 
 func processEjsonKeys(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string, defaultEjsonKeydir string) (ejsonPublicKey string, ejsonPrivateKey string, err error) {
 	if keysAction == config.KeysActionDoNothing {
-		ejsonPublicKey, ejsonPrivateKey = extractEjsonKeysFromTheEnvironment()
+		if !usesEjsonSecrets(cr.Spec) {
+			log.Println("no secrets encrypted with ejson, the ejson key pair is not needed")
+			return "", "", nil
+		}
+		// the cluster is left alone, the key pair has to be in the environment
+		discovery := &EjsonKeyDiscovery{KeyDir: getEjsonKeyDir(defaultEjsonKeydir)}
+		if ejsonPublicKey, ejsonPrivateKey, err = discovery.Discover(); err != nil {
+			return "", "", fmt.Errorf("error finding the ejson key pair: %w", err)
+		}
 		return ejsonPublicKey, ejsonPrivateKey, nil
 	}
 
 	keysFound := false
-	if keysAction == config.KeysActionRestoreOrRotate {
-		if ejsonPublicKey, ejsonPrivateKey, err = restoreEjsonKeysFromCluster(cr, defaultEjsonKeydir, kubeConfigPath); err != nil {
-			if !errors.IsNotFound(err) {
+	if keysAction == config.KeysActionRestoreOrRotate || keysAction == config.KeysActionRenewCertificates {
+		if ejsonPublicKey, ejsonPrivateKey, err = restoreEjsonKeysFromCluster(cr, kubeConfigPath, ""); err != nil {
+			if !kubeApiErrors.IsNotFound(err) {
 				log.Printf("error restoring the ejson key pair from the cluster: %v\n", err)
 				return "", "", err
 			}
+		} else if err = rewriteEjsonKeys(defaultEjsonKeydir, ejsonPublicKey, ejsonPrivateKey); err != nil {
+			log.Printf("error rewriting ejson keys: %v\n", err)
+			return "", "", err
 		} else {
 			log.Println("restored ejson keys from the cluster")
 			keysFound = true
 		}
 	}
 
-	if keysAction == config.KeysActionForceRotate || !keysFound {
+	if keysAction == config.KeysActionForceRotate {
+		return rotateEjsonKeys(cr, kubeConfigPath, defaultEjsonKeydir)
+	} else if !keysFound {
 		if ejsonPublicKey, ejsonPrivateKey, err = ejson.GenerateKeypair(); err != nil {
 			log.Printf("error generating an ejson key pair: %v\n", err)
 			return "", "", err
@@ -136,6 +333,59 @@ func processEjsonKeys(cr *config.KApiCr, keysAction config.KeysAction, kubeConfi
 	return ejsonPublicKey, ejsonPrivateKey, err
 }
 
+// usesEjsonSecrets returns true if the CR has secrets to encrypt with ejson, sops-age doesn't use the ejson key pair
+func usesEjsonSecrets(spec *config.CRSpec) bool {
+	if spec.SecretsEncryption != nil && spec.SecretsEncryption.Type != "" && spec.SecretsEncryption.Type != qust.SecretsEncryptionEjson {
+		return false
+	}
+	for _, secrets := range spec.Secrets {
+		if len(secrets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RotateEjsonKeys replaces the ejson key pair, re-encrypts the ejson files under .operator with the new public key
+// and backs up the new key pair to the cluster. Returns the new public key.
+func RotateEjsonKeys(cr *config.KApiCr, kubeConfigPath string) (string, error) {
+	ejsonPublicKey, _, err := rotateEjsonKeys(cr, kubeConfigPath, defaultEjsonKeydir)
+	if err != nil {
+		return "", err
+	} else if err := backupKeys(cr, kubeConfigPath, config.KeysActionRotateEjsonKeys); err != nil {
+		return "", err
+	}
+	log.Println("backed up the rotated ejson key pair to the cluster")
+	return ejsonPublicKey, nil
+}
+
+// rotateEjsonKeys generates a new ejson key pair and re-encrypts the existing ejson files with it,
+// the current key pair, from the key dir or the cluster backup, is only replaced once every file was re-encrypted
+func rotateEjsonKeys(cr *config.KApiCr, kubeConfigPath string, defaultEjsonKeydir string) (ejsonPublicKey string, ejsonPrivateKey string, err error) {
+	discovery := &EjsonKeyDiscovery{KeyDir: getEjsonKeyDir(defaultEjsonKeydir), Cr: cr, KubeConfigPath: kubeConfigPath}
+	currentEjsonPublicKey, currentEjsonPrivateKey, err := discovery.Discover()
+	var notFoundErr *EjsonKeyNotFoundError
+	if err != nil && !errors.As(err, &notFoundErr) {
+		return "", "", fmt.Errorf("error finding the current ejson key pair: %w", err)
+	}
+
+	if ejsonPublicKey, ejsonPrivateKey, err = ejson.GenerateKeypair(); err != nil {
+		log.Printf("error generating an ejson key pair: %v\n", err)
+		return "", "", err
+	}
+	// fails if a file is encrypted with another key pair than the current one, it could not be decrypted after the rotation
+	if reencrypted, err := qust.ReencryptEjsonFiles(cr.Spec, currentEjsonPublicKey, currentEjsonPrivateKey, ejsonPublicKey); err != nil {
+		return "", "", fmt.Errorf("error re-encrypting the ejson files, the ejson key pair was not rotated: %w", err)
+	} else if len(reencrypted) > 0 {
+		log.Printf("re-encrypted %v ejson files with the new ejson public key\n", len(reencrypted))
+	}
+	if err = rewriteEjsonKeys(defaultEjsonKeydir, ejsonPublicKey, ejsonPrivateKey); err != nil {
+		log.Printf("error rewriting ejson keys: %v\n", err)
+		return "", "", err
+	}
+	return ejsonPublicKey, ejsonPrivateKey, nil
+}
+
 func getBackupObjectName(cr *config.KApiCr) string {
 	return fmt.Sprintf("%s-%s", cr.GetName(), defaultBackupObjectName)
 }
@@ -163,6 +413,57 @@ func rewriteEjsonKeys(defaultKeyDir, ejsonPublicKey, ejsonPrivateKey string) err
 	return nil
 }
 
+// backupKeyProviders are the key providers loaded by getBackupKeyProvider, by key source, until the next run
+var backupKeyProviders = struct {
+	sync.Mutex
+	providers map[string]state.KeyProvider
+}{providers: make(map[string]state.KeyProvider)}
+
+// resetBackupKeyProviders makes the next run load the backup encryption keys again, ex. after they were rotated
+func resetBackupKeyProviders() {
+	backupKeyProviders.Lock()
+	defer backupKeyProviders.Unlock()
+	backupKeyProviders.providers = make(map[string]state.KeyProvider)
+}
+
+// getBackupKeyProvider returns the key provider of spec.backupStore.encryption, or of BACKUP_ENCRYPTION_KEY_FILE,
+// or of the "key" of the BACKUP_ENCRYPTION_KEY_SECRET secret in the CR namespace, nil if none is set.
+// The key is loaded once per run
+func getBackupKeyProvider(cr *config.KApiCr, kubeConfigPath string) (state.KeyProvider, error) {
+	encryption := &config.BackupEncryption{KeyFile: os.Getenv("BACKUP_ENCRYPTION_KEY_FILE"), SecretName: os.Getenv("BACKUP_ENCRYPTION_KEY_SECRET")}
+	if cr.Spec.BackupStore != nil && cr.Spec.BackupStore.Encryption != nil {
+		encryption = cr.Spec.BackupStore.Encryption
+	}
+	var source string
+	if encryption.KeyFile != "" {
+		source = "file:" + encryption.KeyFile
+	} else if encryption.SecretName != "" {
+		source = fmt.Sprintf("secret:%v:%v/%v/%v", kubeConfigPath, cr.GetObjectMeta().GetNamespace(), encryption.SecretName, encryption.SecretKey)
+	} else {
+		return nil, nil
+	}
+
+	backupKeyProviders.Lock()
+	defer backupKeyProviders.Unlock()
+	if keyProvider, ok := backupKeyProviders.providers[source]; ok {
+		return keyProvider, nil
+	}
+	var keyProvider state.KeyProvider
+	if encryption.KeyFile != "" {
+		if fileKeyProvider, err := state.NewFileKeyProvider(encryption.KeyFile); err != nil {
+			return nil, fmt.Errorf("error loading the backup encryption key from file: %v, error: %w", encryption.KeyFile, err)
+		} else {
+			keyProvider = fileKeyProvider
+		}
+	} else if secretKeyProvider, err := state.NewSecretKeyProvider(kubeConfigPath, cr.GetObjectMeta().GetNamespace(), encryption.SecretName, encryption.SecretKey); err != nil {
+		return nil, fmt.Errorf("error loading the backup encryption key from secret: %v, error: %w", encryption.SecretName, err)
+	} else {
+		keyProvider = secretKeyProvider
+	}
+	backupKeyProviders.providers[source] = keyProvider
+	return keyProvider, nil
+}
+
 func cleanEjsonKeysDir(keyDir string) error {
 	if dirItems, err := ioutil.ReadDir(keyDir); err != nil {
 		log.Printf("error reading key directory: %v, error: %v\n", keyDir, err)
@@ -179,42 +480,77 @@ func cleanEjsonKeysDir(keyDir string) error {
 	}
 }
 
-func restoreEjsonKeysFromCluster(cr *config.KApiCr, defaultKeyDir string, kubeConfigPath string) (ejsonPublicKey, ejsonPrivateKey string, err error) {
-	if err := state.Restore(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), []state.BackupDir{
-		{Key: "ejson-keys", Directory: getEjsonKeyDir(defaultKeyDir)},
-	}); err != nil {
-		return "", "", err
-	} else {
-		return loadEjsonKeysFromKeyDir(defaultKeyDir)
+// ListKeysClusterBackupGenerations returns the kept generations of the keys backup, newest first
+func ListKeysClusterBackupGenerations(cr *config.KApiCr, kubeConfigPath string) ([]*state.BackupGeneration, error) {
+	backupOptions, err := getBackupOptions(cr, kubeConfigPath)
+	if err != nil {
+		return nil, err
 	}
+	return state.ListGenerations(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), backupOptions)
 }
 
-func loadEjsonKeysFromKeyDir(defaultKeyDir string) (ejsonPublicKey, ejsonPrivateKey string, err error) {
-	keyDir := getEjsonKeyDir(defaultKeyDir)
-	if dirItems, err := ioutil.ReadDir(keyDir); err != nil {
-		log.Printf("error reading key directory: %v, error: %v\n", keyDir, err)
-		return "", "", err
-	} else {
-		for _, fileInfo := range dirItems {
-			if fileInfo.IsDir() {
-				continue
-			} else if ejsonPrivateKeyBytes, err := ioutil.ReadFile(path.Join(keyDir, fileInfo.Name())); err != nil {
-				return "", "", err
-			} else {
-				return fileInfo.Name(), string(ejsonPrivateKeyBytes), nil
-			}
+// RestoreKeysClusterBackupGeneration restores the application keys and the ejson key pair of a previous backup generation
+// and backs them up again as the latest generation, so they are what the next RestoreOrRotate restores
+func RestoreKeysClusterBackupGeneration(cr *config.KApiCr, kubeConfigPath string, generation int) error {
+	backupOptions, err := getBackupOptions(cr, kubeConfigPath)
+	if err != nil {
+		return err
+	} else if err := state.RestoreGeneration(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), generation, []state.BackupDir{
+		{Key: "operator-keys", Directory: filepath.Join(cr.Spec.GetManifestsRoot(), ".operator/keys")},
+		{Key: "ejson-keys", Directory: getEjsonKeyDir(defaultEjsonKeydir)},
+	}, backupOptions); err != nil {
+		return fmt.Errorf("error restoring keys backup generation: %v, error: %w", generation, err)
+	}
+	log.Printf("restored keys backup generation: %v\n", generation)
+	return backupRestoredKeys(cr, kubeConfigPath, config.KeysActionRestoreGeneration, &generation)
+}
+
+// VerifyKeysClusterBackup compares the application keys and the ejson key pair on disk with the latest keys backup,
+// every drift is logged and with restoreOnDrift the missing and modified files are restored from the backup, extra files are kept
+func VerifyKeysClusterBackup(cr *config.KApiCr, kubeConfigPath string, restoreOnDrift bool) (*state.VerifyResult, error) {
+	backupOptions, err := getBackupOptions(cr, kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	backupDirs := []state.BackupDir{
+		{Key: "operator-keys", Directory: filepath.Join(cr.Spec.GetManifestsRoot(), ".operator/keys")},
+		{Key: "ejson-keys", Directory: getEjsonKeyDir(defaultEjsonKeydir)},
+	}
+	result, err := state.Verify(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), backupDirs, backupOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying the keys backup: %w", err)
+	} else if !result.HasDrift() {
+		return result, nil
+	}
+	for _, drift := range result.Drifts {
+		log.Printf("keys drift from backup generation: %v, %v file: %v is %v\n", result.Generation, drift.Key, drift.Path, drift.Type)
+	}
+	if restoreOnDrift {
+		if err := state.RestoreWithOptions(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), backupDirs, backupOptions); err != nil {
+			return result, fmt.Errorf("error restoring keys from the cluster: %w", err)
 		}
-		err = fmt.Errorf("no ejson keys found in directory: %v", keyDir)
-		log.Printf("%v\n", err)
-		return "", "", err
+		log.Printf("restored keys from backup generation: %v\n", result.Generation)
 	}
+	return result, nil
 }
 
+// DeleteKeysClusterBackup deletes the keys backup object together with its chunks and generations, then every other
+// keys backup object of the CR in its backup store, labeled release=<cr-name>, ex. left over by a renamed backup
 func DeleteKeysClusterBackup(cr *config.KApiCr, kubeConfigPath string) error {
-	if secretsClient, err := utils.GetSecretsClient(kubeConfigPath, cr.GetObjectMeta().GetNamespace()); err != nil {
+	backupOptions, err := getBackupOptions(cr, kubeConfigPath)
+	if err != nil {
 		return err
-	} else if err := secretsClient.Delete(context.TODO(), getBackupObjectName(cr), metaV1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+	} else if err := state.DeleteWithOptions(kubeConfigPath, getBackupObjectName(cr), cr.GetObjectMeta().GetNamespace(), backupOptions); err != nil {
 		return err
+	} else if cr.GetName() == "" {
+		return nil
+	}
+	selector := fmt.Sprintf("release=%v", cr.GetName())
+	deleted, err := state.DeleteBySelector(kubeConfigPath, cr.GetObjectMeta().GetNamespace(), selector, backupOptions)
+	if err != nil {
+		return fmt.Errorf("error deleting keys backups matching: %v, error: %w", selector, err)
+	} else if len(deleted) > 0 {
+		log.Printf("deleted %v other keys backup objects matching: %v\n", len(deleted), selector)
 	}
 	return nil
 }
@@ -7,10 +7,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/qlik-oss/k-apis/pkg/config"
 	"github.com/qlik-oss/k-apis/pkg/git"
+	"github.com/qlik-oss/k-apis/pkg/state"
 	"github.com/qlik-oss/k-apis/pkg/utils"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
@@ -176,3 +178,175 @@ metadata:
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func Test_processEjsonKeys_doNothing(t *testing.T) {
+	keyDir, err := ioutil.TempDir("", "ejson-keys")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(keyDir)
+	defer os.Setenv("EJSON_KEY", os.Getenv("EJSON_KEY"))
+	defer os.Setenv("EJSON_KEYDIR", os.Getenv("EJSON_KEYDIR"))
+	os.Setenv("EJSON_KEY", "")
+	os.Setenv("EJSON_KEYDIR", keyDir)
+
+	// the ejson key pair is only needed to encrypt secrets with ejson
+	cr := &config.KApiCr{Spec: &config.CRSpec{}}
+	if _, _, err := processEjsonKeys(cr, config.KeysActionDoNothing, "", keyDir); err != nil {
+		t.Fatalf("unexpected error without secrets: %v", err)
+	}
+	cr.Spec.Secrets = map[string]config.NameValues{"qliksense": {{Name: "mongodbUri", Value: "mongo"}}}
+	cr.Spec.SecretsEncryption = &config.SecretsEncryption{Type: "sops-age"}
+	if _, _, err := processEjsonKeys(cr, config.KeysActionDoNothing, "", keyDir); err != nil {
+		t.Fatalf("unexpected error with sops-age: %v", err)
+	}
+	cr.Spec.SecretsEncryption = nil
+	if _, _, err := processEjsonKeys(cr, config.KeysActionDoNothing, "", keyDir); err == nil {
+		t.Fatal("expected an error for ejson secrets without an ejson key pair")
+	}
+}
+
+func Test_DeleteKeysClusterBackup_deletesOtherBackupsOfCr(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer os.Setenv("EJSON_KEYDIR", os.Getenv("EJSON_KEYDIR"))
+	os.Setenv("EJSON_KEYDIR", filepath.Join(tmpDir, "ejson-keys"))
+	backupsDir := filepath.Join(tmpDir, "backups")
+	cr := &config.KApiCr{Spec: &config.CRSpec{
+		ManifestsRoot: filepath.Join(tmpDir, "manifests"),
+		BackupStore:   &config.BackupStore{Type: "directory", Directory: backupsDir},
+	}}
+	cr.SetName("qliksense")
+	cr.SetNamespace("qlik")
+	writeTestFile(t, filepath.Join(tmpDir, "manifests", ".operator", "keys", "key.pem"), "key")
+	writeTestFile(t, filepath.Join(tmpDir, "ejson-keys", "public"), "private")
+
+	backupOptions, err := getBackupOptions(cr, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := backupKeys(cr, "", config.KeysActionDoNothing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := state.BackupWithOptions("", "renamed-backup", "qlik", "qliksense", []state.BackupDir{
+		{Key: "ejson-keys", Directory: filepath.Join(tmpDir, "ejson-keys")},
+	}, backupOptions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := state.BackupWithOptions("", "other-backup", "qlik", "other", []state.BackupDir{
+		{Key: "ejson-keys", Directory: filepath.Join(tmpDir, "ejson-keys")},
+	}, backupOptions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := DeleteKeysClusterBackup(cr, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(backupsDir, "qlik"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".json" {
+			names = append(names, file.Name())
+		}
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, "other-backup") {
+			t.Fatalf("expected only the backup of the other CR to be kept, got: %v", names)
+		}
+	}
+	if len(names) == 0 {
+		t.Fatal("expected the backup of the other CR to be kept")
+	}
+}
+
+func Test_getBackupKeyProvider(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer resetBackupKeyProviders()
+	defer os.Setenv("BACKUP_ENCRYPTION_KEY_FILE", os.Getenv("BACKUP_ENCRYPTION_KEY_FILE"))
+	os.Setenv("BACKUP_ENCRYPTION_KEY_FILE", filepath.Join(tmpDir, "missing"))
+	keyFile := filepath.Join(tmpDir, "backup.key")
+	writeTestFile(t, keyFile, "0123456789abcdef0123456789abcdef")
+	cr := &config.KApiCr{Spec: &config.CRSpec{BackupStore: &config.BackupStore{Encryption: &config.BackupEncryption{KeyFile: keyFile}}}}
+
+	// the encryption of the CR takes precedence over the environment
+	keyProvider, err := getBackupKeyProvider(cr, "")
+	if err != nil || keyProvider == nil {
+		t.Fatalf("expected the key provider of the CR, got: %v, error: %v", keyProvider, err)
+	}
+	// the key is loaded once per run
+	writeTestFile(t, keyFile, "invalid")
+	if cachedKeyProvider, err := getBackupKeyProvider(cr, ""); err != nil || cachedKeyProvider != keyProvider {
+		t.Fatalf("expected the key provider to be reused, got: %v, error: %v", cachedKeyProvider, err)
+	}
+	resetBackupKeyProviders()
+	if _, err := getBackupKeyProvider(cr, ""); err == nil {
+		t.Fatal("expected the key to be loaded again by the next run")
+	}
+
+	cr.Spec.BackupStore.Encryption = nil
+	if _, err := getBackupKeyProvider(cr, ""); err == nil {
+		t.Fatal("expected an error for the missing BACKUP_ENCRYPTION_KEY_FILE")
+	}
+}
+
+func Test_getBackupOptions_retention(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	cr := &config.KApiCr{Spec: &config.CRSpec{BackupStore: &config.BackupStore{Type: "directory", Directory: tmpDir, Retention: 3}}}
+	if backupOptions, err := getBackupOptions(cr, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if backupOptions.Retention != 3 {
+		t.Fatalf("expected the retention of the backup store: 3, got: %v", backupOptions.Retention)
+	}
+}
+
+func TestRestoreKeysClusterBackupGeneration(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer os.Setenv("EJSON_KEYDIR", os.Getenv("EJSON_KEYDIR"))
+	os.Setenv("EJSON_KEYDIR", filepath.Join(tmpDir, "ejson-keys"))
+	cr := &config.KApiCr{Spec: &config.CRSpec{
+		ManifestsRoot: filepath.Join(tmpDir, "manifests"),
+		BackupStore:   &config.BackupStore{Type: "directory", Directory: filepath.Join(tmpDir, "backups")},
+	}}
+	cr.SetName("qliksense")
+	cr.SetNamespace("qlik")
+	keyFile := filepath.Join(tmpDir, "manifests", ".operator", "keys", "key.pem")
+	writeTestFile(t, filepath.Join(tmpDir, "ejson-keys", "public"), "private")
+	for _, content := range []string{"1", "2"} {
+		writeTestFile(t, keyFile, content)
+		if err := backupKeys(cr, "", config.KeysActionForceRotate); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := RestoreKeysClusterBackupGeneration(cr, "", 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if data, err := ioutil.ReadFile(keyFile); err != nil || string(data) != "1" {
+		t.Fatalf("expected the key of generation 1 to be restored, got: %v, error: %v", string(data), err)
+	}
+	generations, err := ListKeysClusterBackupGenerations(cr, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(generations) != 3 {
+		t.Fatalf("expected the restored keys to be backed up as generation 3, got: %v", generations)
+	} else if latest := generations[0]; latest.KeysAction != string(config.KeysActionRestoreGeneration) ||
+		latest.RestoredGeneration == nil || *latest.RestoredGeneration != 1 {
+		t.Fatalf("expected generation 3 to record the restore of generation 1, got: %+v", latest)
+	} else if generations[1].RestoredGeneration != nil {
+		t.Fatalf("expected generation 2 not to be restored, got: %+v", generations[1])
+	}
+}
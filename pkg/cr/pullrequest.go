@@ -0,0 +1,144 @@
+package cr
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+	crGit "github.com/qlik-oss/k-apis/pkg/git"
+)
+
+const (
+	defaultPullRequestBaseBranch    = "master"
+	defaultPullRequestTitleTemplate = "k-apis: update {{ .Namespace }}/{{ .Name }}"
+	defaultPullRequestBodyTemplate  = "Patches generated by k-apis for {{ .Namespace }}/{{ .Name }}.\n\n{{ .Summary }}"
+	redactedValue                   = "<redacted>"
+)
+
+// PullRequestTemplateData is the data of the pull request title and body templates
+type PullRequestTemplateData struct {
+	Name      string
+	Namespace string
+	Branch    string
+	Commit    string
+	// the files under .operator changed by the commit
+	ChangedFiles []string
+	// by service and name, the values of the secrets are redacted
+	Configs map[string]map[string]string
+	Secrets map[string]map[string]string
+	// markdown summary of the changed files, configs and secrets
+	Summary string
+}
+
+// getPullRequestMarker identifies the pull requests of a CR, the open one is updated instead of opening another one
+func getPullRequestMarker(cr *config.KApiCr) string {
+	return fmt.Sprintf("<!-- k-apis: %v/%v -->", cr.GetObjectMeta().GetNamespace(), cr.GetObjectMeta().GetName())
+}
+
+// getPullRequestBaseBranch returns the branch the patches are based on and the pull requests merge into, master by default
+func getPullRequestBaseBranch(pullRequestConfig *config.PullRequest) string {
+	if pullRequestConfig == nil || pullRequestConfig.BaseBranch == "" {
+		return defaultPullRequestBaseBranch
+	}
+	return pullRequestConfig.BaseBranch
+}
+
+// newPullRequestProvider authenticates with the access token, or the password, of the repository
+func newPullRequestProvider(repo *config.Repo, credentials *crGit.Credentials) (crGit.PullRequestProvider, error) {
+	token := credentials.AccessToken
+	if token == "" {
+		token = credentials.Password
+	}
+	repository, _ := crGit.StripUrlCredentials(repo.Repository)
+	return crGit.NewPullRequestProvider(repo.PullRequest.Provider, repo.PullRequest.ApiUrl, repository, token, nil)
+}
+
+// getPullRequest renders the pull request of the patches pushed to branch
+func getPullRequest(cr *config.KApiCr, branch, commit string, changedFiles []string) (*crGit.PullRequest, error) {
+	pullRequestConfig := cr.Spec.Git.PullRequest
+	data := &PullRequestTemplateData{
+		Name:         cr.GetObjectMeta().GetName(),
+		Namespace:    cr.GetObjectMeta().GetNamespace(),
+		Branch:       branch,
+		Commit:       commit,
+		ChangedFiles: changedFiles,
+		Configs:      getSummaryValues(cr.Spec.Configs, false),
+		Secrets:      getSummaryValues(cr.Spec.Secrets, true),
+	}
+	data.Summary = getPullRequestSummary(data)
+
+	pullRequest := &crGit.PullRequest{
+		Head:      branch,
+		Base:      getPullRequestBaseBranch(pullRequestConfig),
+		Labels:    pullRequestConfig.Labels,
+		Reviewers: pullRequestConfig.Reviewers,
+	}
+	var err error
+	if pullRequest.Title, err = renderPullRequestTemplate("title", pullRequestConfig.TitleTemplate, defaultPullRequestTitleTemplate, data); err != nil {
+		return nil, err
+	} else if pullRequest.Body, err = renderPullRequestTemplate("body", pullRequestConfig.BodyTemplate, defaultPullRequestBodyTemplate, data); err != nil {
+		return nil, err
+	}
+	pullRequest.Body = fmt.Sprintf("%v\n\n%v", strings.TrimSpace(pullRequest.Body), getPullRequestMarker(cr))
+	return pullRequest, nil
+}
+
+func renderPullRequestTemplate(name, text, defaultText string, data *PullRequestTemplateData) (string, error) {
+	if text == "" {
+		text = defaultText
+	}
+	var buffer bytes.Buffer
+	if t, err := template.New(name).Parse(text); err != nil {
+		return "", fmt.Errorf("error parsing the pull request %v template: %w", name, err)
+	} else if err := t.Execute(&buffer, data); err != nil {
+		return "", fmt.Errorf("error rendering the pull request %v template: %w", name, err)
+	}
+	return buffer.String(), nil
+}
+
+func getSummaryValues(values map[string]config.NameValues, redact bool) map[string]map[string]string {
+	summaryValues := make(map[string]map[string]string)
+	for svc, nameValues := range values {
+		summaryValues[svc] = make(map[string]string)
+		for _, nameValue := range nameValues {
+			if redact {
+				summaryValues[svc][nameValue.Name] = redactedValue
+			} else if nameValue.ValueFrom != nil && nameValue.ValueFrom.SecretKeyRef != nil {
+				summaryValues[svc][nameValue.Name] = fmt.Sprintf("from secret %v/%v", nameValue.ValueFrom.SecretKeyRef.Name, nameValue.ValueFrom.SecretKeyRef.Key)
+			} else {
+				summaryValues[svc][nameValue.Name] = nameValue.Value
+			}
+		}
+	}
+	return summaryValues
+}
+
+func getPullRequestSummary(data *PullRequestTemplateData) string {
+	var summary strings.Builder
+	if len(data.ChangedFiles) > 0 {
+		summary.WriteString("**Changed files**\n")
+		for _, changedFile := range data.ChangedFiles {
+			fmt.Fprintf(&summary, "- `%v`\n", changedFile)
+		}
+	}
+	for _, section := range []struct {
+		title  string
+		values map[string]map[string]string
+	}{{"Configs", data.Configs}, {"Secrets", data.Secrets}} {
+		var lines []string
+		for svc, values := range section.values {
+			for name, value := range values {
+				lines = append(lines, fmt.Sprintf("- %v.%v: %v\n", svc, name, value))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		sort.Strings(lines)
+		fmt.Fprintf(&summary, "\n**%v**\n%v", section.title, strings.Join(lines, ""))
+	}
+	return strings.TrimSpace(summary.String())
+}
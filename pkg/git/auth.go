@@ -0,0 +1,201 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitSsh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHUser       = "git"
+	defaultTokenUserName = "installer"
+)
+
+// scp-like ssh URLs, ex. git@github.com:qlik-oss/qliksense-k8s.git
+var scpLikeUrlRegexp = regexp.MustCompile(`^(?:([^@/]+)@)?([^:/]+):([^/].*)$`)
+
+// ErrUrlCredentials is returned when cloning a repository URL embedding credentials, go-git would store them in .git/config
+var ErrUrlCredentials = errors.New("the repository URL embeds credentials")
+
+// Credentials of a repository, only the ones matching the scheme of the repository URL may be set
+type Credentials struct {
+	// http(s)
+	UserName    string
+	Password    string
+	AccessToken string
+	// ssh
+	SSHPrivateKey         []byte
+	SSHPrivateKeyPassword string
+	// known_hosts file content the ssh host key is checked against,
+	// SSH_KNOWN_HOSTS or ~/.ssh/known_hosts are used if empty
+	KnownHosts  []byte
+	UseSSHAgent bool
+}
+
+// String redacts the secrets, credentials are never logged
+func (c *Credentials) String() string {
+	redact := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return "<redacted>"
+	}
+	return fmt.Sprintf("{UserName:%v Password:%v AccessToken:%v SSHPrivateKey:%v SSHPrivateKeyPassword:%v UseSSHAgent:%v}",
+		c.UserName, redact(c.Password), redact(c.AccessToken), redact(string(c.SSHPrivateKey)), redact(c.SSHPrivateKeyPassword), c.UseSSHAgent)
+}
+
+func (c *Credentials) hasHttpCredentials() bool {
+	return c.UserName != "" && c.Password != "" || c.AccessToken != ""
+}
+
+func (c *Credentials) hasSSHCredentials() bool {
+	return len(c.SSHPrivateKey) > 0 || c.UseSSHAgent
+}
+
+// NewAuthMethod returns the auth method of credentials for repository, nil if there are no credentials
+func NewAuthMethod(repository string, credentials *Credentials) (transport.AuthMethod, error) {
+	if credentials == nil {
+		return nil, nil
+	}
+	scheme, sshUser, err := getRepositoryScheme(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case "http", "https":
+		if credentials.hasSSHCredentials() {
+			return nil, fmt.Errorf("repository: %v uses %v, the ssh private key or ssh agent only apply to ssh repositories", repository, scheme)
+		} else if credentials.AccessToken != "" {
+			userName := credentials.UserName
+			if userName == "" {
+				userName = defaultTokenUserName
+			}
+			return &http.BasicAuth{Username: userName, Password: credentials.AccessToken}, nil
+		} else if credentials.UserName != "" && credentials.Password != "" {
+			return &http.BasicAuth{Username: credentials.UserName, Password: credentials.Password}, nil
+		}
+		return nil, nil
+	case "ssh":
+		if credentials.hasHttpCredentials() {
+			return nil, fmt.Errorf("repository: %v uses ssh, the access token or password only apply to http(s) repositories", repository)
+		} else if !credentials.hasSSHCredentials() {
+			return nil, nil
+		}
+		if sshUser == "" {
+			sshUser = defaultSSHUser
+		}
+		hostKeyCallback, err := getHostKeyCallback(credentials.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+		if len(credentials.SSHPrivateKey) > 0 {
+			publicKeys, err := gitSsh.NewPublicKeys(sshUser, credentials.SSHPrivateKey, credentials.SSHPrivateKeyPassword)
+			if err != nil {
+				return nil, fmt.Errorf("error reading the ssh private key: %w", err)
+			}
+			publicKeys.HostKeyCallback = hostKeyCallback
+			return publicKeys, nil
+		}
+		agentAuth, err := gitSsh.NewSSHAgentAuth(sshUser)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to the ssh agent: %w", err)
+		}
+		agentAuth.HostKeyCallback = hostKeyCallback
+		return agentAuth, nil
+	default:
+		if credentials.hasHttpCredentials() || credentials.hasSSHCredentials() {
+			return nil, fmt.Errorf("repository: %v uses %v, which takes no credentials", repository, scheme)
+		}
+		return nil, nil
+	}
+}
+
+// StripUrlCredentials returns repository without the credentials of its user info and the credentials, nil if there are none.
+// The user info of http(s) URLs is a user name and password, or a token alone, the user name of ssh URLs is the login and is kept.
+func StripUrlCredentials(repository string) (string, *Credentials) {
+	if !strings.Contains(repository, "://") {
+		// scp-like URLs and local paths have no password
+		return repository, nil
+	}
+	repositoryUrl, err := url.Parse(repository)
+	if err != nil || repositoryUrl.User == nil {
+		return repository, nil
+	}
+	password, hasPassword := repositoryUrl.User.Password()
+	switch strings.ToLower(repositoryUrl.Scheme) {
+	case "http", "https":
+		credentials := &Credentials{UserName: repositoryUrl.User.Username(), Password: password}
+		if !hasPassword {
+			credentials = &Credentials{AccessToken: repositoryUrl.User.Username()}
+		}
+		repositoryUrl.User = nil
+		return repositoryUrl.String(), credentials
+	default:
+		if !hasPassword {
+			return repository, nil
+		}
+		userName := repositoryUrl.User.Username()
+		repositoryUrl.User = url.User(userName)
+		return repositoryUrl.String(), &Credentials{UserName: userName, Password: password}
+	}
+}
+
+// HasUrlCredentials is true if repository embeds credentials, see StripUrlCredentials
+func HasUrlCredentials(repository string) bool {
+	_, credentials := StripUrlCredentials(repository)
+	return credentials != nil
+}
+
+// getRepositoryScheme returns the scheme of a repository URL, ssh for scp-like URLs and file for local paths
+func getRepositoryScheme(repository string) (scheme, user string, err error) {
+	if strings.Contains(repository, "://") {
+		repositoryUrl, err := url.Parse(repository)
+		if err != nil {
+			return "", "", err
+		}
+		return strings.ToLower(repositoryUrl.Scheme), repositoryUrl.User.Username(), nil
+	} else if matches := scpLikeUrlRegexp.FindStringSubmatch(repository); matches != nil {
+		return "ssh", matches[1], nil
+	}
+	return "file", "", nil
+}
+
+// getHostKeyCallback checks ssh host keys against knownHosts, or the default known_hosts files if empty,
+// host keys are never accepted unchecked
+func getHostKeyCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	if len(knownHosts) == 0 {
+		hostKeyCallback, err := gitSsh.NewKnownHostsCallback()
+		if err != nil {
+			return nil, fmt.Errorf("no known_hosts to check the ssh host key against: %w", err)
+		}
+		return hostKeyCallback, nil
+	}
+	// knownhosts only reads files, the content is parsed before the file is removed
+	knownHostsFile, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(knownHostsFile.Name())
+	if _, err := knownHostsFile.Write(knownHosts); err != nil {
+		knownHostsFile.Close()
+		return nil, err
+	} else if err := knownHostsFile.Close(); err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("error reading known_hosts: %w", err)
+	}
+	return hostKeyCallback, nil
+}
@@ -0,0 +1,195 @@
+package git
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/qlik-oss/k-apis/pkg/utils"
+)
+
+// a mirror is locked while it is fetched and cloned, other clones of the same repository wait for it
+const (
+	mirrorLockAttempts = 600
+	mirrorLockInterval = time.Second
+)
+
+// CloneOptions of CloneRepositoryWithOptions, the zero value is a full clone like CloneRepository
+type CloneOptions struct {
+	// the number of commits fetched, the whole history if 0
+	Depth int
+	// clone only this branch or tag, at most one of them may be set
+	Branch string
+	Tag    string
+	// a bare mirror of the repository is kept in a directory under CacheDir and fetched every time it is reused,
+	// the repository is cloned from the mirror
+	CacheDir string
+}
+
+// CloneRepositoryWithOptions clones repoUrl into path, the origin remote of the clone is always repoUrl,
+// even if it was cloned from the mirror in options.CacheDir
+func CloneRepositoryWithOptions(path string, repoUrl string, auth transport.AuthMethod, options *CloneOptions) (*git.Repository, error) {
+	cloneOptions := &git.CloneOptions{URL: repoUrl, Depth: options.Depth}
+	if HasUrlCredentials(repoUrl) {
+		return nil, ErrUrlCredentials
+	} else if options.Branch != "" && options.Tag != "" {
+		return nil, fmt.Errorf("only one of branch: %v and tag: %v may be cloned", options.Branch, options.Tag)
+	} else if options.Branch != "" {
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(options.Branch)
+		cloneOptions.SingleBranch = true
+	} else if options.Tag != "" {
+		cloneOptions.ReferenceName = plumbing.NewTagReferenceName(options.Tag)
+		cloneOptions.SingleBranch = true
+		cloneOptions.Tags = git.NoTags
+	}
+
+	if options.CacheDir == "" {
+		if auth != nil {
+			cloneOptions.Auth = auth
+		}
+		return git.PlainClone(path, false, cloneOptions)
+	}
+
+	mirrorPath := getMirrorPath(options.CacheDir, repoUrl)
+	if err := os.MkdirAll(options.CacheDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	unlock, err := utils.LockFile(mirrorPath+".lock", mirrorLockAttempts, mirrorLockInterval)
+	if err != nil {
+		return nil, fmt.Errorf("error locking the mirror of %v: %w", repoUrl, err)
+	}
+	defer unlock()
+	if err := updateMirror(mirrorPath, repoUrl, auth); err != nil {
+		return nil, err
+	}
+	cloneOptions.URL = mirrorPath
+	r, err := git.PlainClone(path, false, cloneOptions)
+	if err != nil {
+		return nil, err
+	}
+	// push and fetch from the repository, not from the mirror
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	remoteConfig := remote.Config()
+	remoteConfig.URLs = []string{repoUrl}
+	if err := r.DeleteRemote(git.DefaultRemoteName); err != nil {
+		return nil, err
+	} else if _, err := r.CreateRemote(remoteConfig); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// getMirrorPath returns the directory of the mirror of repoUrl under cacheDir
+func getMirrorPath(cacheDir, repoUrl string) string {
+	// named after the repository, the hash keeps the mirrors of repositories of the same name apart
+	name := strings.TrimSuffix(filepath.Base(strings.TrimRight(repoUrl, "/")), ".git")
+	hash := sha256.Sum256([]byte(repoUrl))
+	return filepath.Join(cacheDir, fmt.Sprintf("%v-%x.git", name, hash[:6]))
+}
+
+// updateMirror creates the bare mirror of repoUrl in mirrorPath, or fetches it if it already exists,
+// the caller holds the lock of the mirror
+func updateMirror(mirrorPath, repoUrl string, auth transport.AuthMethod) error {
+	var r *git.Repository
+	if _, err := os.Stat(mirrorPath); os.IsNotExist(err) {
+		if r, err = git.PlainInit(mirrorPath, true); err != nil {
+			return fmt.Errorf("error creating the mirror of %v: %w", repoUrl, err)
+		} else if _, err = r.CreateRemote(&config.RemoteConfig{
+			Name:  git.DefaultRemoteName,
+			URLs:  []string{repoUrl},
+			Fetch: []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"},
+		}); err != nil {
+			os.RemoveAll(mirrorPath)
+			return fmt.Errorf("error creating the mirror of %v: %w", repoUrl, err)
+		}
+	} else if r, err = git.PlainOpen(mirrorPath); err != nil {
+		return fmt.Errorf("error opening the mirror of %v: %w", repoUrl, err)
+	}
+
+	if err := FetchRepository(r, auth); err != nil {
+		return fmt.Errorf("error fetching the mirror of %v: %w", repoUrl, err)
+	}
+	return nil
+}
+
+// FetchRepository fetches the refs of the origin remote, it is up to date if there was nothing to fetch
+func FetchRepository(r *git.Repository, auth transport.AuthMethod) error {
+	fetchOptions := &git.FetchOptions{RemoteName: git.DefaultRemoteName, Force: true, Tags: git.AllTags}
+	if auth != nil {
+		fetchOptions.Auth = auth
+	}
+	if err := r.Fetch(fetchOptions); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+	return nil
+}
+
+// fetchRemoteTagOrBranch fetches only ref, a tag or branch name, from the remotes and returns its hash
+func fetchRemoteTagOrBranch(r *git.Repository, ref string, auth transport.AuthMethod) (*plumbing.Hash, error) {
+	remotes, err := r.Remotes()
+	if err != nil {
+		return nil, err
+	}
+	// a shallow repository stays shallow
+	depth := 0
+	if shallows, err := r.Storer.Shallow(); err == nil && len(shallows) > 0 {
+		depth = 1
+	}
+
+	var fetchErrors []string
+	for _, remote := range remotes {
+		remoteName := remote.Config().Name
+		for _, refSpec := range getTagOrBranchRefSpecs(remoteName, ref) {
+			fetchOptions := &git.FetchOptions{
+				RemoteName: remoteName,
+				RefSpecs:   []config.RefSpec{refSpec},
+				Depth:      depth,
+				Tags:       git.NoTags,
+			}
+			if auth != nil {
+				fetchOptions.Auth = auth
+			}
+			if err := r.Fetch(fetchOptions); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+				fetchErrors = append(fetchErrors, err.Error())
+				continue
+			}
+			if localRef, err := r.Reference(refSpec.Dst(plumbing.ReferenceName(refSpec.Src())), true); err == nil {
+				hash := localRef.Hash()
+				return &hash, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("ref is not a remote tag/branch: %v, %v", ref, strings.Join(fetchErrors, ", "))
+}
+
+// getTagOrBranchRefSpecs returns the refspecs fetching ref as a tag, then as a branch, into the refs of remoteName
+func getTagOrBranchRefSpecs(remoteName, ref string) []config.RefSpec {
+	tagRefSpec := func(tag string) config.RefSpec {
+		return config.RefSpec(fmt.Sprintf("+refs/tags/%v:refs/tags/%v", tag, tag))
+	}
+	branchRefSpec := func(branch string) config.RefSpec {
+		return config.RefSpec(fmt.Sprintf("+refs/heads/%v:refs/remotes/%v/%v", branch, remoteName, branch))
+	}
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return []config.RefSpec{tagRefSpec(strings.TrimPrefix(ref, "refs/tags/"))}
+	} else if strings.HasPrefix(ref, "refs/heads/") {
+		return []config.RefSpec{branchRefSpec(strings.TrimPrefix(ref, "refs/heads/"))}
+	}
+	return []config.RefSpec{tagRefSpec(ref), branchRefSpec(ref)}
+}
+
+// FetchRef fetches only ref, a tag or branch, from the remotes even if it exists locally and returns its hash
+func FetchRef(r *git.Repository, ref string, auth transport.AuthMethod) (*plumbing.Hash, error) {
+	return fetchRemoteTagOrBranch(r, ref, auth)
+}
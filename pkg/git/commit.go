@@ -0,0 +1,411 @@
+package git
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultCommitPath    = ".operator"
+	defaultCommitMessage = "k-apis pr"
+
+	SigningFormatGPG = "gpg"
+	SigningFormatSSH = "ssh"
+
+	sshSignatureNamespace     = "git"
+	sshSignatureHashAlgorithm = "sha512"
+)
+
+// CommitOptions of AddCommit
+type CommitOptions struct {
+	// only the changes under Path, relative to the worktree root, are staged, .operator if empty
+	Path string
+	// the summary of the staged changes is appended to Message
+	Message string
+	// When defaults to now
+	Author *object.Signature
+	// the author if nil
+	Committer *object.Signature
+	// signs the commit if set
+	SigningKey *SigningKey
+	// appended after the summary of the staged changes, see GetTrailers
+	Trailers []Trailer
+}
+
+// Trailer is a `Key: Value` line of the last paragraph of a commit message
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// SigningKey is an armored gpg private key or a PEM/OpenSSH ssh private key
+type SigningKey struct {
+	// gpg or ssh
+	Format   string
+	Key      []byte
+	Password string
+}
+
+// fileChange is a staged file and how it changed: added, modified or deleted
+type fileChange struct {
+	path   string
+	change string
+}
+
+// AddCommit stages only the changes under options.Path and commits them with the summary of the staged changes
+// by stage, the first directory under options.Path, returns a zero hash if there was nothing to commit
+func AddCommit(r *git.Repository, options *CommitOptions) (hash plumbing.Hash, err error) {
+	path := options.Path
+	if path == "" {
+		path = defaultCommitPath
+	}
+	commitOptions := &git.CommitOptions{
+		Author:    getSignature(options.Author, nil),
+		Committer: getSignature(options.Committer, options.Author),
+	}
+	var sshSigner ssh.Signer
+	if options.SigningKey != nil {
+		switch options.SigningKey.Format {
+		case SigningFormatGPG:
+			if commitOptions.SignKey, err = readGPGSigningKey(options.SigningKey); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		case SigningFormatSSH:
+			if sshSigner, err = readSSHSigningKey(options.SigningKey); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		default:
+			return plumbing.ZeroHash, fmt.Errorf("unsupported signing format: %v, expected gpg or ssh", options.SigningKey.Format)
+		}
+	}
+
+	workTree, err := r.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	// the changes staged outside of path are not committed, they are staged again after the commit
+	restageOutsidePath, err := unstageOutsidePath(r, workTree, path)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error unstaging the changes outside of %v: %w", path, err)
+	}
+	defer func() {
+		if restageErr := restageOutsidePath(); restageErr != nil && err == nil {
+			err = fmt.Errorf("error staging the changes outside of %v again: %w", path, restageErr)
+		}
+	}()
+	status, err := workTree.Status()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var changes []fileChange
+	for filePath, fileStatus := range status {
+		// changes left staged by a failed commit are committed too, they are in the worktree
+		if !isUnderPath(filePath, path) || fileStatus.Worktree == git.Unmodified && fileStatus.Staging == git.Unmodified {
+			continue
+		} else if fileStatus.Worktree == git.Deleted {
+			_, err = workTree.Remove(filePath)
+			changes = append(changes, fileChange{filePath, "deleted"})
+		} else if fileStatus.Staging == git.Deleted {
+			changes = append(changes, fileChange{filePath, "deleted"})
+		} else if fileStatus.Worktree == git.Untracked || fileStatus.Staging == git.Added {
+			_, err = workTree.Add(filePath)
+			changes = append(changes, fileChange{filePath, "added"})
+		} else {
+			_, err = workTree.Add(filePath)
+			changes = append(changes, fileChange{filePath, "modified"})
+		}
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	if len(changes) == 0 {
+		return plumbing.ZeroHash, nil
+	}
+
+	message := options.Message
+	if message == "" {
+		message = defaultCommitMessage
+	}
+	message = fmt.Sprintf("%v\n\n%v", strings.TrimSpace(message), getChangesSummary(path, changes))
+	if len(options.Trailers) > 0 {
+		var trailerLines []string
+		for _, trailer := range options.Trailers {
+			trailerLines = append(trailerLines, fmt.Sprintf("%v: %v", trailer.Key, trailer.Value))
+		}
+		message = fmt.Sprintf("%v\n\n%v", message, strings.Join(trailerLines, "\n"))
+	}
+	if hash, err = workTree.Commit(message, commitOptions); err != nil {
+		return plumbing.ZeroHash, err
+	} else if sshSigner != nil {
+		return signCommitWithSSH(r, hash, sshSigner)
+	}
+	return hash, nil
+}
+
+// unstageOutsidePath resets the index to HEAD, or keeps only the entries under path without HEAD, and returns the func
+// staging the index entries outside of path again
+func unstageOutsidePath(r *git.Repository, workTree *git.Worktree, path string) (func() error, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	var outsideEntries []*index.Entry
+	var underPathEntries []*index.Entry
+	for _, entry := range idx.Entries {
+		if isUnderPath(entry.Name, path) {
+			underPathEntries = append(underPathEntries, entry)
+		} else {
+			outsideEntries = append(outsideEntries, entry)
+		}
+	}
+
+	if head, err := r.Head(); err == plumbing.ErrReferenceNotFound {
+		// nothing committed yet
+		idx.Entries = underPathEntries
+		if err := r.Storer.SetIndex(idx); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if err := workTree.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.MixedReset}); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		idx, err := r.Storer.Index()
+		if err != nil {
+			return err
+		}
+		entries := outsideEntries
+		for _, entry := range idx.Entries {
+			if isUnderPath(entry.Name, path) {
+				entries = append(entries, entry)
+			}
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		idx.Entries = entries
+		return r.Storer.SetIndex(idx)
+	}, nil
+}
+
+// GetTrailers returns the trailers of the last paragraph of a commit message by key,
+// nil if one of its lines is not a `Key: Value` trailer
+func GetTrailers(message string) map[string]string {
+	paragraphs := strings.Split(strings.TrimSpace(strings.ReplaceAll(message, "\r\n", "\n")), "\n\n")
+	if len(paragraphs) < 2 {
+		// a message without a body has no trailers
+		return nil
+	}
+	trailers := make(map[string]string)
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		i := strings.Index(line, ": ")
+		if i <= 0 || strings.ContainsAny(line[:i], " \t") {
+			return nil
+		}
+		trailers[line[:i]] = strings.TrimSpace(line[i+2:])
+	}
+	return trailers
+}
+
+func isUnderPath(filePath, path string) bool {
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	return path == "" || path == "." || filePath == path || strings.HasPrefix(filePath, path+"/")
+}
+
+func getSignature(signature, defaultSignature *object.Signature) *object.Signature {
+	if signature == nil {
+		signature = defaultSignature
+	}
+	if signature == nil {
+		return nil
+	}
+	result := *signature
+	if result.When.IsZero() {
+		result.When = time.Now()
+	}
+	return &result
+}
+
+// getChangesSummary lists the counts of added, modified and deleted files of every stage, then the files
+func getChangesSummary(path string, changes []fileChange) string {
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	stages := make(map[string][]fileChange)
+	for _, change := range changes {
+		// the files directly under path are of the path stage
+		stage := strings.TrimPrefix(strings.TrimPrefix(change.path, path), "/")
+		if i := strings.Index(stage, "/"); i >= 0 {
+			stage = stage[:i]
+		} else {
+			stage = path
+		}
+		stages[stage] = append(stages[stage], change)
+	}
+	var stageNames []string
+	for stage := range stages {
+		stageNames = append(stageNames, stage)
+	}
+	sort.Strings(stageNames)
+
+	var summary strings.Builder
+	summary.WriteString("Changed files:\n")
+	for _, stage := range stageNames {
+		stageChanges := stages[stage]
+		sort.Slice(stageChanges, func(i, j int) bool { return stageChanges[i].path < stageChanges[j].path })
+		counts := make(map[string]int)
+		for _, change := range stageChanges {
+			counts[change.change]++
+		}
+		var countLines []string
+		for _, change := range []string{"added", "modified", "deleted"} {
+			if counts[change] > 0 {
+				countLines = append(countLines, fmt.Sprintf("%v %v", counts[change], change))
+			}
+		}
+		fmt.Fprintf(&summary, "%v: %v\n", stage, strings.Join(countLines, ", "))
+		for _, change := range stageChanges {
+			fmt.Fprintf(&summary, "  %v: %v\n", change.change, change.path)
+		}
+	}
+	return strings.TrimSpace(summary.String())
+}
+
+func readGPGSigningKey(signingKey *SigningKey) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(signingKey.Key))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the gpg signing key: %w", err)
+	} else if len(entities) == 0 || entities[0].PrivateKey == nil {
+		return nil, fmt.Errorf("the gpg signing key has no private key")
+	}
+	entity := entities[0]
+	if entity.PrivateKey.Encrypted {
+		if err := entity.PrivateKey.Decrypt([]byte(signingKey.Password)); err != nil {
+			return nil, fmt.Errorf("error decrypting the gpg signing key: %w", err)
+		}
+	}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			if err := subkey.PrivateKey.Decrypt([]byte(signingKey.Password)); err != nil {
+				return nil, fmt.Errorf("error decrypting the gpg signing subkey: %w", err)
+			}
+		}
+	}
+	return entity, nil
+}
+
+func readSSHSigningKey(signingKey *SigningKey) (ssh.Signer, error) {
+	var signer ssh.Signer
+	var err error
+	if signingKey.Password != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(signingKey.Key, []byte(signingKey.Password))
+	} else {
+		signer, err = ssh.ParsePrivateKey(signingKey.Key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the ssh signing key: %w", err)
+	}
+	return signer, nil
+}
+
+// signCommitWithSSH replaces the HEAD commit by a copy signed in the format of `ssh-keygen -Y sign -n git`,
+// the format git uses with gpg.format=ssh
+func signCommitWithSSH(r *git.Repository, hash plumbing.Hash, signer ssh.Signer) (plumbing.Hash, error) {
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	unsigned := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(unsigned); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	reader, err := unsigned.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if commit.PGPSignature, err = getSSHSignature(signer, data); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	signed := r.Storer.NewEncodedObject()
+	if err := commit.Encode(signed); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	signedHash, err := r.Storer.SetEncodedObject(signed)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	headName := plumbing.HEAD
+	if head.Type() == plumbing.SymbolicReference {
+		headName = head.Target()
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference(headName, signedHash)); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return signedHash, nil
+}
+
+// getSSHSignature returns the armored SSHSIG signature of data,
+// see https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+func getSSHSignature(signer ssh.Signer, data []byte) (string, error) {
+	hash := sha512.Sum512(data)
+	signedData := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sshSignatureNamespace, "", sshSignatureHashAlgorithm, hash[:]})...)
+
+	var signature *ssh.Signature
+	var err error
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// ssh-rsa (sha1) signatures are rejected by ssh-keygen -Y verify
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, signedData, ssh.SigAlgoRSASHA2512)
+	} else {
+		signature, err = signer.Sign(rand.Reader, signedData)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error signing the commit: %w", err)
+	}
+
+	blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{1, signer.PublicKey().Marshal(), sshSignatureNamespace, "", sshSignatureHashAlgorithm, ssh.Marshal(signature)})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var armored strings.Builder
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+	return armored.String(), nil
+}
@@ -0,0 +1,304 @@
+package git
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+func initCommitTestRepository(t *testing.T) (*git.Repository, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeCommitTestFile(t, dir, ".operator/configs/qliksense/config.yaml", "a")
+	writeCommitTestFile(t, dir, ".operator/secrets/qliksense/secret.yaml", "a")
+	if _, err := AddCommit(r, &CommitOptions{Author: &object.Signature{Name: "k-apis"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return r, dir
+}
+
+func writeCommitTestFile(t *testing.T, dir, filePath, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, filePath)), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, filePath), []byte(content), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestAddCommit(t *testing.T) {
+	r, dir := initCommitTestRepository(t)
+
+	writeCommitTestFile(t, dir, ".operator/configs/qliksense/config.yaml", "b")
+	writeCommitTestFile(t, dir, ".operator/configs/qliksense/other.yaml", "a")
+	if err := os.Remove(filepath.Join(dir, ".operator/secrets/qliksense/secret.yaml")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeCommitTestFile(t, dir, "manifests/unrelated.yaml", "a")
+
+	hash, err := AddCommit(r, &CommitOptions{
+		Message:   "k-apis: update qlik/qliksense",
+		Author:    &object.Signature{Name: "author", Email: "author@example.com"},
+		Committer: &object.Signature{Name: "committer", Email: "committer@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedMessage := `k-apis: update qlik/qliksense
+
+Changed files:
+configs: 1 added, 1 modified
+  modified: .operator/configs/qliksense/config.yaml
+  added: .operator/configs/qliksense/other.yaml
+secrets: 1 deleted
+  deleted: .operator/secrets/qliksense/secret.yaml`
+	if commit.Message != expectedMessage {
+		t.Fatalf("expected message:\n%v\ngot:\n%v", expectedMessage, commit.Message)
+	}
+	if commit.Author.Name != "author" || commit.Author.Email != "author@example.com" ||
+		commit.Committer.Name != "committer" || commit.Committer.Email != "committer@example.com" {
+		t.Fatalf("unexpected author: %v, committer: %v", commit.Author, commit.Committer)
+	}
+	if _, err := commit.File("manifests/unrelated.yaml"); err != object.ErrFileNotFound {
+		t.Fatalf("expected the change outside .operator not to be committed, got: %v", err)
+	}
+
+	// the change outside .operator is left unstaged
+	if hash, err := AddCommit(r, &CommitOptions{Author: &object.Signature{Name: "k-apis"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !hash.IsZero() {
+		t.Fatalf("expected nothing to commit, got: %v", hash)
+	}
+}
+
+func TestAddCommit_preStagedOutsidePath(t *testing.T) {
+	r, dir := initCommitTestRepository(t)
+	workTree, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeCommitTestFile(t, dir, "manifests/staged.yaml", "a")
+	if _, err := workTree.Add("manifests/staged.yaml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeCommitTestFile(t, dir, ".operator/configs/qliksense/config.yaml", "b")
+
+	hash, err := AddCommit(r, &CommitOptions{Author: &object.Signature{Name: "k-apis"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := commit.File("manifests/staged.yaml"); err == nil {
+		t.Fatal("expected the file staged outside of .operator not to be committed")
+	} else if file, err := commit.File(".operator/configs/qliksense/config.yaml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if contents, _ := file.Contents(); contents != "b" {
+		t.Fatalf("expected the change under .operator to be committed, got: %v", contents)
+	}
+	if status, err := workTree.Status(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if fileStatus := status.File("manifests/staged.yaml"); fileStatus.Staging != git.Added {
+		t.Fatalf("expected the file outside of .operator to stay staged, got: %+v", fileStatus)
+	}
+}
+
+func TestAddCommit_trailers(t *testing.T) {
+	r, dir := initCommitTestRepository(t)
+	writeCommitTestFile(t, dir, ".operator/configs/qliksense/config.yaml", "b")
+
+	hash, err := AddCommit(r, &CommitOptions{
+		Message:  "k-apis: update qlik/qliksense",
+		Author:   &object.Signature{Name: "k-apis"},
+		Trailers: []Trailer{{"K-Apis-Cr-Name", "qliksense"}, {"K-Apis-Cr-Generation", "3"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !strings.HasSuffix(commit.Message, "config.yaml\n\nK-Apis-Cr-Name: qliksense\nK-Apis-Cr-Generation: 3") {
+		t.Fatalf("expected the trailers after the changed files, got:\n%v", commit.Message)
+	}
+	if trailers := GetTrailers(commit.Message); len(trailers) != 2 || trailers["K-Apis-Cr-Name"] != "qliksense" || trailers["K-Apis-Cr-Generation"] != "3" {
+		t.Fatalf("unexpected trailers: %v", trailers)
+	}
+}
+
+func TestGetTrailers(t *testing.T) {
+	testCases := []struct {
+		message  string
+		trailers map[string]string
+	}{
+		{"subject", nil},
+		{"subject: not a trailer", nil},
+		{"subject\n\nSigned-off-by: a <a@example.com>\n", map[string]string{"Signed-off-by": "a <a@example.com>"}},
+		{"subject\n\nbody\n\nKey: value\r\nOther: value", map[string]string{"Key": "value", "Other": "value"}},
+		{"subject\n\nChanged files:\nconfigs: 1 added\n  added: .operator/configs/a.yaml", nil},
+		{"subject\n\nKey: value\nnot a trailer", nil},
+	}
+	for _, testCase := range testCases {
+		trailers := GetTrailers(testCase.message)
+		if len(trailers) != len(testCase.trailers) {
+			t.Fatalf("expected trailers: %v of %q, got: %v", testCase.trailers, testCase.message, trailers)
+		}
+		for key, value := range testCase.trailers {
+			if trailers[key] != value {
+				t.Fatalf("expected trailers: %v of %q, got: %v", testCase.trailers, testCase.message, trailers)
+			}
+		}
+	}
+}
+
+func TestAddCommit_gpgSigning(t *testing.T) {
+	r, dir := initCommitTestRepository(t)
+
+	entity, err := openpgp.NewEntity("k-apis", "", "k-apis@example.com", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var privateKey, publicKey bytes.Buffer
+	if w, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else {
+		w.Close()
+	}
+	if w, err := armor.Encode(&publicKey, openpgp.PublicKeyType, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := entity.Serialize(w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else {
+		w.Close()
+	}
+
+	writeCommitTestFile(t, dir, ".operator/configs/qliksense/config.yaml", "b")
+	hash, err := AddCommit(r, &CommitOptions{
+		Author:     &object.Signature{Name: "k-apis"},
+		SigningKey: &SigningKey{Format: SigningFormatGPG, Key: privateKey.Bytes()},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := commit.Verify(publicKey.String()); err != nil {
+		t.Fatalf("expected a valid gpg signature: %v", err)
+	}
+}
+
+func TestAddCommit_sshSigning(t *testing.T) {
+	r, dir := initCommitTestRepository(t)
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeCommitTestFile(t, dir, ".operator/configs/qliksense/config.yaml", "b")
+	if _, err := AddCommit(r, &CommitOptions{
+		Author:     &object.Signature{Name: "k-apis"},
+		SigningKey: &SigningKey{Format: SigningFormatSSH, Key: []byte("not a key")},
+	}); err == nil {
+		t.Fatal("expected an error for an invalid ssh signing key")
+	}
+
+	// the commit is signed directly, the key parsing is covered above
+	hash, err := AddCommit(r, &CommitOptions{Author: &object.Signature{Name: "k-apis"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hash, err = signCommitWithSSH(r, hash, signer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if head, err := r.Head(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if head.Hash() != hash {
+		t.Fatalf("expected HEAD to be the signed commit: %v, got: %v", hash, head.Hash())
+	}
+
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unsigned := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(unsigned); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reader, _ := unsigned.Reader()
+	data, _ := ioutil.ReadAll(reader)
+	verifySSHSignature(t, commit.PGPSignature, signer.PublicKey(), data)
+}
+
+func verifySSHSignature(t *testing.T, armored string, publicKey ssh.PublicKey, data []byte) {
+	t.Helper()
+	encoded := strings.TrimSpace(armored)
+	if !strings.HasPrefix(encoded, "-----BEGIN SSH SIGNATURE-----") || !strings.HasSuffix(encoded, "-----END SSH SIGNATURE-----") {
+		t.Fatalf("unexpected ssh signature: %v", armored)
+	}
+	encoded = strings.TrimSuffix(strings.TrimPrefix(encoded, "-----BEGIN SSH SIGNATURE-----"), "-----END SSH SIGNATURE-----")
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil || !bytes.HasPrefix(blob, []byte("SSHSIG")) {
+		t.Fatalf("unexpected ssh signature blob: %v", err)
+	}
+	var signature struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := ssh.Unmarshal(blob[len("SSHSIG"):], &signature); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !bytes.Equal(signature.PublicKey, publicKey.Marshal()) || signature.Namespace != "git" || signature.HashAlgorithm != "sha512" {
+		t.Fatalf("unexpected ssh signature: %+v", signature)
+	}
+	var sshSignature ssh.Signature
+	if err := ssh.Unmarshal(signature.Signature, &sshSignature); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hash := sha512.Sum512(data)
+	signedData := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{"git", "", "sha512", hash[:]})...)
+	if err := publicKey.Verify(signedData, &sshSignature); err != nil {
+		t.Fatalf("expected a valid ssh signature: %v", err)
+	}
+}
@@ -2,17 +2,22 @@ package git
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"sort"
-	"time"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
 func CloneRepository(path string, repoUrl string, auth transport.AuthMethod) (*git.Repository, error) {
+	if HasUrlCredentials(repoUrl) {
+		return nil, ErrUrlCredentials
+	}
 	cloneOptions := &git.CloneOptions{URL: repoUrl}
 	if auth != nil {
 		cloneOptions.Auth = auth
@@ -24,6 +29,28 @@ func OpenRepository(path string) (*git.Repository, error) {
 	return git.PlainOpen(path)
 }
 
+// RemoveRemoteCredentials removes the credentials embedded in the remote URLs of r, ex. by an older clone,
+// from .git/config
+func RemoveRemoteCredentials(r *git.Repository) error {
+	repoConfig, err := r.Config()
+	if err != nil {
+		return err
+	}
+	removed := false
+	for _, remote := range repoConfig.Remotes {
+		for i, remoteUrl := range remote.URLs {
+			if strippedUrl, credentials := StripUrlCredentials(remoteUrl); credentials != nil {
+				remote.URLs[i] = strippedUrl
+				removed = true
+			}
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return r.Storer.SetConfig(repoConfig)
+}
+
 func Checkout(r *git.Repository, ref string, toBranch string, auth transport.AuthMethod) error {
 	hash, err := resolveRevision(r, ref, auth)
 	if err != nil {
@@ -57,26 +84,55 @@ func Checkout(r *git.Repository, ref string, toBranch string, auth transport.Aut
 	}
 }
 
-func AddCommit(r *git.Repository, author string) error {
-	workTree, err := r.Worktree()
+// PushBranch pushes the local branch to the branch of the same name of the origin remote
+func PushBranch(r *git.Repository, branch string, auth transport.AuthMethod) error {
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	return r.Push(&git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%v:%v", branchRef, branchRef))},
+		Auth:     auth,
+	})
+}
+
+// ForcePushBranch replaces remoteBranch of the origin remote with the local branch
+func ForcePushBranch(r *git.Repository, branch, remoteBranch string, auth transport.AuthMethod) error {
+	return r.Push(&git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+%v:%v", plumbing.NewBranchReferenceName(branch), plumbing.NewBranchReferenceName(remoteBranch)))},
+		Auth:     auth,
+	})
+}
+
+// GetChangedFiles returns the files added, modified or deleted by a commit compared to its first parent, sorted
+func GetChangedFiles(r *git.Repository, hash plumbing.Hash) ([]string, error) {
+	commit, err := r.CommitObject(hash)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = workTree.Add(".")
+	tree, err := commit.Tree()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	_, err = workTree.Commit("k-apis pr", &git.CommitOptions{
-		Author: &object.Signature{
-			Name: author,
-			When: time.Now(),
-		},
-	})
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		if parent, err := commit.Parent(0); err != nil {
+			return nil, err
+		} else if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	changes, err := object.DiffTree(parentTree, tree)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	var changedFiles []string
+	for _, change := range changes {
+		if change.To.Name != "" {
+			changedFiles = append(changedFiles, change.To.Name)
+		} else {
+			changedFiles = append(changedFiles, change.From.Name)
+		}
+	}
+	sort.Strings(changedFiles)
+	return changedFiles, nil
 }
 
 func Push(r *git.Repository, auth transport.AuthMethod) error {
@@ -89,10 +145,11 @@ func Push(r *git.Repository, auth transport.AuthMethod) error {
 	return nil
 }
 
+// resolveRevision resolves ref locally, or fetches only ref if it is a tag or branch missing locally
 func resolveRevision(r *git.Repository, ref string, auth transport.AuthMethod) (*plumbing.Hash, error) {
 	hash, err := r.ResolveRevision(plumbing.Revision(ref))
 	if err != nil {
-		hash, err = resolveRemoteTagOrBranch(r, ref, auth)
+		hash, err = fetchRemoteTagOrBranch(r, ref, auth)
 		if err != nil {
 			return nil, err
 		}
@@ -100,31 +157,6 @@ func resolveRevision(r *git.Repository, ref string, auth transport.AuthMethod) (
 	return hash, nil
 }
 
-func resolveRemoteTagOrBranch(r *git.Repository, findRef string, auth transport.AuthMethod) (*plumbing.Hash, error) {
-	listOptions := &git.ListOptions{}
-	if auth != nil {
-		listOptions.Auth = auth
-	}
-	if remotes, err := r.Remotes(); err != nil {
-		return nil, err
-	} else {
-		for _, remote := range remotes {
-			if refs, err := remote.List(listOptions); err != nil {
-				return nil, err
-			} else {
-				for _, ref := range refs {
-					if (ref.Name().IsTag() || ref.Name().IsBranch()) &&
-						(ref.Name().String() == findRef || ref.Name().Short() == findRef) {
-						hash := ref.Hash()
-						return &hash, nil
-					}
-				}
-			}
-		}
-		return nil, fmt.Errorf("ref is not a remote tag/branch: %v", findRef)
-	}
-}
-
 // func CreatePR(path string, token string, username string, branch string) error {
 // 	ctx := context.Background()
 // 	ts := oauth2.StaticTokenSource(
@@ -168,6 +200,26 @@ func DiscardAllUnstagedChanges(r *git.Repository) error {
 	}
 }
 
+// ResetBranch checks out branch reset to hash, the local commits of branch and the changes of the worktree are discarded
+func ResetBranch(r *git.Repository, branch string, hash plumbing.Hash) error {
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if workTree, err := r.Worktree(); err != nil {
+		return err
+	} else if err := workTree.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return err
+	} else if err := r.Storer.SetReference(plumbing.NewHashReference(branchRef, hash)); err != nil {
+		return err
+	} else {
+		return workTree.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true})
+	}
+}
+
+// IsNonFastForwardError is true if a push was rejected because the remote branch has commits the local one does not
+func IsNonFastForwardError(err error) bool {
+	return err != nil && (errors.Is(err, git.ErrNonFastForwardUpdate) ||
+		strings.Contains(err.Error(), "non-fast-forward") || strings.Contains(err.Error(), "fetch first"))
+}
+
 type RemoteRefs struct {
 	Name     string
 	Branches []string
@@ -181,10 +233,23 @@ const (
 	RefSortOrderDescending
 )
 
+type RefSortMode byte
+
+const (
+	RefSortModeLexical RefSortMode = iota
+	// refs that aren't semantic versions, ex. v1.2.3, are left out
+	RefSortModeSemver
+)
+
 type RemoteRefConstraints struct {
 	Include   bool
 	Sort      bool
 	SortOrder RefSortOrder
+	SortMode  RefSortMode
+	// ex. ">=1.2 <2", refs that aren't semantic versions are left out if set
+	SemverConstraint string
+	// prereleases, ex. v1.2.0-rc.1, are left out of semver sorted or constrained refs unless set
+	IncludePrerelease bool
 }
 
 func sortStringSlice(data *[]string, sortOrder RefSortOrder) {
@@ -195,6 +260,61 @@ func sortStringSlice(data *[]string, sortOrder RefSortOrder) {
 	}
 }
 
+// semverRef is a ref name and its semantic version
+type semverRef struct {
+	name    string
+	version *semanticVersion
+}
+
+// applyRefConstraints filters and sorts refs by the semver options of constraints
+func applyRefConstraints(refs []string, constraints *RemoteRefConstraints) ([]string, error) {
+	if constraints.SortMode != RefSortModeSemver && constraints.SemverConstraint == "" {
+		if constraints.Sort {
+			sortStringSlice(&refs, constraints.SortOrder)
+		}
+		return refs, nil
+	}
+
+	var constraint semverConstraint
+	if constraints.SemverConstraint != "" {
+		var err error
+		if constraint, err = parseSemverConstraint(constraints.SemverConstraint); err != nil {
+			return nil, err
+		}
+	}
+	var semverRefs []semverRef
+	for _, ref := range refs {
+		if version, err := parseSemanticVersion(ref); err != nil {
+			continue
+		} else if version.isPrerelease() && !constraints.IncludePrerelease {
+			continue
+		} else if constraint != nil && !constraint.matches(version) {
+			continue
+		} else {
+			semverRefs = append(semverRefs, semverRef{ref, version})
+		}
+	}
+
+	if constraints.Sort {
+		sort.SliceStable(semverRefs, func(i, j int) bool {
+			result := semverRefs[i].version.compare(semverRefs[j].version)
+			if result == 0 {
+				// ex. v1.2.0 and 1.2.0
+				result = strings.Compare(semverRefs[i].name, semverRefs[j].name)
+			}
+			if constraints.SortOrder == RefSortOrderDescending {
+				return result > 0
+			}
+			return result < 0
+		})
+	}
+	result := []string{}
+	for _, ref := range semverRefs {
+		result = append(result, ref.name)
+	}
+	return result, nil
+}
+
 func GetRemoteRefs(
 	r *git.Repository,
 	auth transport.AuthMethod,
@@ -224,14 +344,41 @@ func GetRemoteRefs(
 						remoteReferences.Branches = append(remoteReferences.Branches, ref.Name().Short())
 					}
 				}
-				if tagConstraints.Include && tagConstraints.Sort {
-					sortStringSlice(&remoteReferences.Tags, tagConstraints.SortOrder)
+				if tagConstraints.Include {
+					if remoteReferences.Tags, err = applyRefConstraints(remoteReferences.Tags, tagConstraints); err != nil {
+						return nil, err
+					}
 				}
-				if branchConstraints.Include && branchConstraints.Sort {
-					sortStringSlice(&remoteReferences.Branches, branchConstraints.SortOrder)
+				if branchConstraints.Include {
+					if remoteReferences.Branches, err = applyRefConstraints(remoteReferences.Branches, branchConstraints); err != nil {
+						return nil, err
+					}
 				}
 			}
 		}
 		return remoteRefsList, nil
 	}
 }
+
+// GetLatestRemoteTags returns the latest semantic version tag matching semverConstraint by remote name,
+// remotes without a matching tag are left out, ex. ">"+installedVersion to check for upgrades
+func GetLatestRemoteTags(r *git.Repository, auth transport.AuthMethod, semverConstraint string, includePrerelease bool) (map[string]string, error) {
+	remoteRefsList, err := GetRemoteRefs(r, auth, &RemoteRefConstraints{
+		Include:           true,
+		Sort:              true,
+		SortOrder:         RefSortOrderDescending,
+		SortMode:          RefSortModeSemver,
+		SemverConstraint:  semverConstraint,
+		IncludePrerelease: includePrerelease,
+	}, &RemoteRefConstraints{})
+	if err != nil {
+		return nil, err
+	}
+	latestTags := make(map[string]string)
+	for _, remoteRefs := range remoteRefsList {
+		if len(remoteRefs.Tags) > 0 {
+			latestTags[remoteRefs.Name] = remoteRefs.Tags[0]
+		}
+	}
+	return latestTags, nil
+}
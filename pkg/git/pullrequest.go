@@ -0,0 +1,168 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	PullRequestProviderGitHub = "github"
+	PullRequestProviderGitLab = "gitlab"
+	PullRequestProviderGitea  = "gitea"
+
+	defaultGitHubApiUrl = "https://api.github.com"
+	defaultGitLabApiUrl = "https://gitlab.com/api/v4"
+)
+
+// PullRequest is a pull (or merge) request of the Head branch into the Base branch
+type PullRequest struct {
+	// set by the provider
+	Number int
+	URL    string
+
+	Head      string
+	Base      string
+	Title     string
+	Body      string
+	Labels    []string
+	Reviewers []string
+}
+
+// PullRequestProvider opens and updates pull requests over the REST API of a git hosting service
+type PullRequestProvider interface {
+	// FindOpenPullRequest returns the open pull request whose body contains marker and whose head is a branch of the
+	// repository itself, not of a fork, starting with headPrefix, nil if there is none. Every page of the open pull requests is read.
+	FindOpenPullRequest(marker, headPrefix string) (*PullRequest, error)
+	// CreatePullRequest opens pr and returns it with its Number and URL
+	CreatePullRequest(pr *PullRequest) (*PullRequest, error)
+	// UpdatePullRequest replaces the title, body and base of the open pull request pr.Number,
+	// and adds the labels and reviewers of pr
+	UpdatePullRequest(pr *PullRequest) (*PullRequest, error)
+}
+
+// NewPullRequestProvider returns the provider of the repository, ex. https://github.com/qlik-oss/qliksense-k8s,
+// apiUrl defaults to the public service of github and gitlab and is required for gitea
+func NewPullRequestProvider(provider, apiUrl, repository, token string, httpClient *http.Client) (PullRequestProvider, error) {
+	repositoryPath, err := getRepositoryPath(repository)
+	if err != nil {
+		return nil, err
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	switch provider {
+	case PullRequestProviderGitHub:
+		if apiUrl == "" {
+			apiUrl = defaultGitHubApiUrl
+		}
+		return &gitHubProvider{
+			client:         newRestClient(apiUrl, httpClient, "Authorization", "token "+token),
+			repositoryPath: getOwnerAndName(repositoryPath),
+		}, nil
+	case PullRequestProviderGitLab:
+		if apiUrl == "" {
+			apiUrl = defaultGitLabApiUrl
+		}
+		return &gitLabProvider{
+			client:    newRestClient(apiUrl, httpClient, "PRIVATE-TOKEN", token),
+			projectId: url.PathEscape(repositoryPath),
+		}, nil
+	case PullRequestProviderGitea:
+		if apiUrl == "" {
+			return nil, fmt.Errorf("the gitea pull request provider requires an API URL")
+		}
+		return &giteaProvider{
+			client:         newRestClient(apiUrl, httpClient, "Authorization", "token "+token),
+			repositoryPath: getOwnerAndName(repositoryPath),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported pull request provider: %v", provider)
+	}
+}
+
+// getRepositoryPath returns the path, ex. owner/name, of an http(s), ssh or scp-like (git@host:owner/name.git) repository URL
+func getRepositoryPath(repository string) (string, error) {
+	repositoryPath := ""
+	if strings.Contains(repository, "://") {
+		if repositoryUrl, err := url.Parse(repository); err != nil {
+			return "", err
+		} else {
+			repositoryPath = repositoryUrl.Path
+		}
+	} else if i := strings.Index(repository, ":"); i >= 0 {
+		repositoryPath = repository[i+1:]
+	}
+	repositoryPath = strings.TrimSuffix(strings.Trim(repositoryPath, "/"), ".git")
+	if !strings.Contains(repositoryPath, "/") {
+		return "", fmt.Errorf("cannot find the owner and name of repository: %v", repository)
+	}
+	return repositoryPath, nil
+}
+
+// getOwnerAndName returns the last two segments of a repository path, gitlab is the only one with nested groups
+func getOwnerAndName(repositoryPath string) string {
+	segments := strings.Split(repositoryPath, "/")
+	return strings.Join(segments[len(segments)-2:], "/")
+}
+
+// isOwnPullRequest is true if the pull request of body and head may be updated by the one of marker,
+// anyone may open a pull request with the marker in its body
+func isOwnPullRequest(body, marker, head, headPrefix string, headInRepository bool) bool {
+	return marker != "" && strings.Contains(body, marker) && headInRepository && headPrefix != "" && strings.HasPrefix(head, headPrefix)
+}
+
+type restClient struct {
+	apiUrl      string
+	httpClient  *http.Client
+	headerName  string
+	headerValue string
+}
+
+func newRestClient(apiUrl string, httpClient *http.Client, headerName, headerValue string) *restClient {
+	return &restClient{
+		apiUrl:      strings.TrimSuffix(apiUrl, "/"),
+		httpClient:  httpClient,
+		headerName:  headerName,
+		headerValue: headerValue,
+	}
+}
+
+// do sends request as json to the API path and decodes the json response into response, if not nil
+func (c *restClient) do(method, path string, request interface{}, response interface{}) error {
+	var body []byte
+	if request != nil {
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			return err
+		}
+	}
+	httpRequest, err := http.NewRequest(method, c.apiUrl+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Accept", "application/json")
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set(c.headerName, c.headerValue)
+
+	httpResponse, err := c.httpClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	responseBytes, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 {
+		return fmt.Errorf("%v %v failed with status: %v, response: %v", method, path, httpResponse.Status, string(responseBytes))
+	} else if response != nil && len(responseBytes) > 0 {
+		return json.Unmarshal(responseBytes, response)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type giteaProvider struct {
+	client         *restClient
+	repositoryPath string
+}
+
+// the default maximum page size of gitea
+const giteaPageSize = 50
+
+type giteaPullRequest struct {
+	Number  int    `json:"number,omitempty"`
+	HtmlUrl string `json:"html_url,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Head    struct {
+		Ref string `json:"ref"`
+		// nil if the fork was deleted
+		Repo *struct {
+			FullName string `json:"full_name"`
+		} `json:"repo"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (p *giteaProvider) FindOpenPullRequest(marker, headPrefix string) (*PullRequest, error) {
+	for page := 1; ; page++ {
+		var pullRequests []*giteaPullRequest
+		if err := p.client.do(http.MethodGet, fmt.Sprintf("/repos/%v/pulls?state=open&limit=%v&page=%v", p.repositoryPath, giteaPageSize, page), nil, &pullRequests); err != nil {
+			return nil, err
+		}
+		for _, pullRequest := range pullRequests {
+			headInRepository := pullRequest.Head.Repo != nil && strings.EqualFold(pullRequest.Head.Repo.FullName, p.repositoryPath)
+			if isOwnPullRequest(pullRequest.Body, marker, pullRequest.Head.Ref, headPrefix, headInRepository) {
+				return &PullRequest{
+					Number: pullRequest.Number,
+					URL:    pullRequest.HtmlUrl,
+					Head:   pullRequest.Head.Ref,
+					Base:   pullRequest.Base.Ref,
+					Title:  pullRequest.Title,
+					Body:   pullRequest.Body,
+				}, nil
+			}
+		}
+		if len(pullRequests) < giteaPageSize {
+			return nil, nil
+		}
+	}
+}
+
+func (p *giteaProvider) CreatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	labelIds, err := p.getLabelIds(pr.Labels)
+	if err != nil {
+		return nil, err
+	}
+	var created giteaPullRequest
+	if err := p.client.do(http.MethodPost, fmt.Sprintf("/repos/%v/pulls", p.repositoryPath), map[string]interface{}{
+		"title":  pr.Title,
+		"body":   pr.Body,
+		"head":   pr.Head,
+		"base":   pr.Base,
+		"labels": labelIds,
+	}, &created); err != nil {
+		return nil, err
+	}
+	result := *pr
+	result.Number, result.URL = created.Number, created.HtmlUrl
+	return &result, p.addReviewers(&result)
+}
+
+func (p *giteaProvider) UpdatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	labelIds, err := p.getLabelIds(pr.Labels)
+	if err != nil {
+		return nil, err
+	}
+	request := map[string]interface{}{
+		"title": pr.Title,
+		"body":  pr.Body,
+		"base":  pr.Base,
+	}
+	if len(labelIds) > 0 {
+		request["labels"] = labelIds
+	}
+	var updated giteaPullRequest
+	if err := p.client.do(http.MethodPatch, fmt.Sprintf("/repos/%v/pulls/%v", p.repositoryPath, pr.Number), request, &updated); err != nil {
+		return nil, err
+	}
+	result := *pr
+	result.URL = updated.HtmlUrl
+	return &result, p.addReviewers(&result)
+}
+
+func (p *giteaProvider) addReviewers(pr *PullRequest) error {
+	if len(pr.Reviewers) == 0 {
+		return nil
+	}
+	return p.client.do(http.MethodPost, fmt.Sprintf("/repos/%v/pulls/%v/requested_reviewers", p.repositoryPath, pr.Number), map[string]interface{}{
+		"reviewers": pr.Reviewers,
+	}, nil)
+}
+
+// getLabelIds looks up the ids gitea expects for label names
+func (p *giteaProvider) getLabelIds(labels []string) ([]int, error) {
+	if len(labels) == 0 {
+		return []int{}, nil
+	}
+	var repositoryLabels []*struct {
+		Id   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := p.client.do(http.MethodGet, fmt.Sprintf("/repos/%v/labels?limit=50", p.repositoryPath), nil, &repositoryLabels); err != nil {
+		return nil, err
+	}
+	labelIds := make([]int, 0, len(labels))
+	for _, label := range labels {
+		found := false
+		for _, repositoryLabel := range repositoryLabels {
+			if repositoryLabel.Name == label {
+				labelIds = append(labelIds, repositoryLabel.Id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("gitea label: %v not found in repository: %v", label, p.repositoryPath)
+		}
+	}
+	return labelIds, nil
+}
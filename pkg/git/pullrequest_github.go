@@ -0,0 +1,104 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type gitHubProvider struct {
+	client         *restClient
+	repositoryPath string
+}
+
+const gitHubPageSize = 100
+
+type gitHubPullRequest struct {
+	Number  int    `json:"number,omitempty"`
+	HtmlUrl string `json:"html_url,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Head    struct {
+		Ref string `json:"ref"`
+		// nil if the fork was deleted
+		Repo *struct {
+			FullName string `json:"full_name"`
+		} `json:"repo"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (p *gitHubProvider) FindOpenPullRequest(marker, headPrefix string) (*PullRequest, error) {
+	for page := 1; ; page++ {
+		var pullRequests []*gitHubPullRequest
+		if err := p.client.do(http.MethodGet, fmt.Sprintf("/repos/%v/pulls?state=open&per_page=%v&page=%v", p.repositoryPath, gitHubPageSize, page), nil, &pullRequests); err != nil {
+			return nil, err
+		}
+		for _, pullRequest := range pullRequests {
+			headInRepository := pullRequest.Head.Repo != nil && strings.EqualFold(pullRequest.Head.Repo.FullName, p.repositoryPath)
+			if isOwnPullRequest(pullRequest.Body, marker, pullRequest.Head.Ref, headPrefix, headInRepository) {
+				return &PullRequest{
+					Number: pullRequest.Number,
+					URL:    pullRequest.HtmlUrl,
+					Head:   pullRequest.Head.Ref,
+					Base:   pullRequest.Base.Ref,
+					Title:  pullRequest.Title,
+					Body:   pullRequest.Body,
+				}, nil
+			}
+		}
+		if len(pullRequests) < gitHubPageSize {
+			return nil, nil
+		}
+	}
+}
+
+func (p *gitHubProvider) CreatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	var created gitHubPullRequest
+	if err := p.client.do(http.MethodPost, fmt.Sprintf("/repos/%v/pulls", p.repositoryPath), map[string]interface{}{
+		"title": pr.Title,
+		"body":  pr.Body,
+		"head":  pr.Head,
+		"base":  pr.Base,
+	}, &created); err != nil {
+		return nil, err
+	}
+	result := *pr
+	result.Number, result.URL = created.Number, created.HtmlUrl
+	return &result, p.addLabelsAndReviewers(&result)
+}
+
+func (p *gitHubProvider) UpdatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	var updated gitHubPullRequest
+	if err := p.client.do(http.MethodPatch, fmt.Sprintf("/repos/%v/pulls/%v", p.repositoryPath, pr.Number), map[string]interface{}{
+		"title": pr.Title,
+		"body":  pr.Body,
+		"base":  pr.Base,
+	}, &updated); err != nil {
+		return nil, err
+	}
+	result := *pr
+	result.URL = updated.HtmlUrl
+	return &result, p.addLabelsAndReviewers(&result)
+}
+
+func (p *gitHubProvider) addLabelsAndReviewers(pr *PullRequest) error {
+	// pull requests share the labels API of issues
+	if len(pr.Labels) > 0 {
+		if err := p.client.do(http.MethodPost, fmt.Sprintf("/repos/%v/issues/%v/labels", p.repositoryPath, pr.Number), map[string]interface{}{
+			"labels": pr.Labels,
+		}, nil); err != nil {
+			return err
+		}
+	}
+	if len(pr.Reviewers) > 0 {
+		if err := p.client.do(http.MethodPost, fmt.Sprintf("/repos/%v/pulls/%v/requested_reviewers", p.repositoryPath, pr.Number), map[string]interface{}{
+			"reviewers": pr.Reviewers,
+		}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
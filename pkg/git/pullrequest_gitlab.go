@@ -0,0 +1,123 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type gitLabProvider struct {
+	client *restClient
+	// the url encoded project path
+	projectId string
+}
+
+const gitLabPageSize = 100
+
+type gitLabMergeRequest struct {
+	Iid          int    `json:"iid,omitempty"`
+	WebUrl       string `json:"web_url,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	SourceBranch string `json:"source_branch,omitempty"`
+	TargetBranch string `json:"target_branch,omitempty"`
+	// different for merge requests from forks
+	SourceProjectId int `json:"source_project_id,omitempty"`
+	TargetProjectId int `json:"target_project_id,omitempty"`
+}
+
+func (p *gitLabProvider) FindOpenPullRequest(marker, headPrefix string) (*PullRequest, error) {
+	for page := 1; ; page++ {
+		var mergeRequests []*gitLabMergeRequest
+		if err := p.client.do(http.MethodGet, fmt.Sprintf("/projects/%v/merge_requests?state=opened&per_page=%v&page=%v", p.projectId, gitLabPageSize, page), nil, &mergeRequests); err != nil {
+			return nil, err
+		}
+		for _, mergeRequest := range mergeRequests {
+			headInRepository := mergeRequest.SourceProjectId != 0 && mergeRequest.SourceProjectId == mergeRequest.TargetProjectId
+			if isOwnPullRequest(mergeRequest.Description, marker, mergeRequest.SourceBranch, headPrefix, headInRepository) {
+				return &PullRequest{
+					Number: mergeRequest.Iid,
+					URL:    mergeRequest.WebUrl,
+					Head:   mergeRequest.SourceBranch,
+					Base:   mergeRequest.TargetBranch,
+					Title:  mergeRequest.Title,
+					Body:   mergeRequest.Description,
+				}, nil
+			}
+		}
+		if len(mergeRequests) < gitLabPageSize {
+			return nil, nil
+		}
+	}
+}
+
+func (p *gitLabProvider) CreatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	request, err := p.getMergeRequest(pr)
+	if err != nil {
+		return nil, err
+	}
+	request["source_branch"] = pr.Head
+	var created gitLabMergeRequest
+	if err := p.client.do(http.MethodPost, fmt.Sprintf("/projects/%v/merge_requests", p.projectId), request, &created); err != nil {
+		return nil, err
+	}
+	result := *pr
+	result.Number, result.URL = created.Iid, created.WebUrl
+	return &result, nil
+}
+
+func (p *gitLabProvider) UpdatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	request, err := p.getMergeRequest(pr)
+	if err != nil {
+		return nil, err
+	}
+	// labels are added to the existing ones instead of replacing them
+	delete(request, "labels")
+	if len(pr.Labels) > 0 {
+		request["add_labels"] = strings.Join(pr.Labels, ",")
+	}
+	var updated gitLabMergeRequest
+	if err := p.client.do(http.MethodPut, fmt.Sprintf("/projects/%v/merge_requests/%v", p.projectId, pr.Number), request, &updated); err != nil {
+		return nil, err
+	}
+	result := *pr
+	result.URL = updated.WebUrl
+	return &result, nil
+}
+
+func (p *gitLabProvider) getMergeRequest(pr *PullRequest) (map[string]interface{}, error) {
+	request := map[string]interface{}{
+		"title":         pr.Title,
+		"description":   pr.Body,
+		"target_branch": pr.Base,
+	}
+	if len(pr.Labels) > 0 {
+		request["labels"] = strings.Join(pr.Labels, ",")
+	}
+	if len(pr.Reviewers) > 0 {
+		reviewerIds, err := p.getUserIds(pr.Reviewers)
+		if err != nil {
+			return nil, err
+		}
+		request["reviewer_ids"] = reviewerIds
+	}
+	return request, nil
+}
+
+// getUserIds looks up the ids gitlab expects for user names
+func (p *gitLabProvider) getUserIds(userNames []string) ([]int, error) {
+	var userIds []int
+	for _, userName := range userNames {
+		var users []*struct {
+			Id int `json:"id"`
+		}
+		if err := p.client.do(http.MethodGet, fmt.Sprintf("/users?username=%v", url.QueryEscape(userName)), nil, &users); err != nil {
+			return nil, err
+		} else if len(users) == 0 {
+			return nil, fmt.Errorf("gitlab user: %v not found", userName)
+		}
+		userIds = append(userIds, users[0].Id)
+	}
+	return userIds, nil
+}
@@ -0,0 +1,250 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type recordedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   map[string]interface{}
+}
+
+// newFakeApiServer replies with responses by "METHOD path?query", or by "METHOD path", ex. "GET /repos/owner/name/pulls",
+// and records the requests
+func newFakeApiServer(t *testing.T, responses map[string]string) (*httptest.Server, *[]*recordedRequest) {
+	var requests []*recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &recordedRequest{method: r.Method, path: r.URL.EscapedPath(), header: r.Header}
+		if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+			if err := json.Unmarshal(body, &request.body); err != nil {
+				t.Errorf("unexpected request body: %v", string(body))
+			}
+		}
+		requests = append(requests, request)
+		if response, ok := responses[r.Method+" "+request.path+"?"+r.URL.RawQuery]; ok {
+			w.Write([]byte(response))
+		} else if response, ok := responses[r.Method+" "+request.path]; ok {
+			w.Write([]byte(response))
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	return server, &requests
+}
+
+func findRequest(requests []*recordedRequest, method, path string) *recordedRequest {
+	for _, request := range requests {
+		if request.method == method && request.path == path {
+			return request
+		}
+	}
+	return nil
+}
+
+func TestGetRepositoryPath(t *testing.T) {
+	for repository, expected := range map[string]string{
+		"https://github.com/qlik-oss/qliksense-k8s":           "qlik-oss/qliksense-k8s",
+		"https://gitlab.example.com/group/sub/project.git":    "group/sub/project",
+		"git@github.com:qlik-oss/qliksense-k8s.git":           "qlik-oss/qliksense-k8s",
+		"ssh://git@gitea.example.com:2222/qlik/manifests.git": "qlik/manifests",
+	} {
+		if repositoryPath, err := getRepositoryPath(repository); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if repositoryPath != expected {
+			t.Fatalf("expected: %v for %v, got: %v", expected, repository, repositoryPath)
+		}
+	}
+	if _, err := getRepositoryPath("https://github.com/qliksense-k8s"); err == nil {
+		t.Fatal("expected an error for a repository without an owner")
+	}
+}
+
+func TestGitHubPullRequestProvider(t *testing.T) {
+	server, requests := newFakeApiServer(t, map[string]string{
+		"GET /repos/qlik/manifests/pulls":                        `[{"number":1,"body":"other"},{"number":7,"html_url":"https://github.com/qlik/manifests/pull/7","body":"x <!-- k-apis: ns/cr -->","head":{"ref":"pr-branch-1","repo":{"full_name":"qlik/manifests"}},"base":{"ref":"main"}}]`,
+		"POST /repos/qlik/manifests/pulls":                       `{"number":8,"html_url":"https://github.com/qlik/manifests/pull/8"}`,
+		"PATCH /repos/qlik/manifests/pulls/7":                    `{"number":7,"html_url":"https://github.com/qlik/manifests/pull/7"}`,
+		"POST /repos/qlik/manifests/issues/8/labels":             `[]`,
+		"POST /repos/qlik/manifests/pulls/8/requested_reviewers": `{}`,
+	})
+	defer server.Close()
+
+	provider, err := NewPullRequestProvider(PullRequestProviderGitHub, server.URL, "https://github.com/qlik/manifests.git", "secret", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if openPullRequest, err := provider.FindOpenPullRequest("<!-- k-apis: ns/cr -->", "pr-branch-"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if openPullRequest == nil || openPullRequest.Number != 7 || openPullRequest.Head != "pr-branch-1" || openPullRequest.Base != "main" {
+		t.Fatalf("unexpected open pull request: %+v", openPullRequest)
+	}
+	if openPullRequest, err := provider.FindOpenPullRequest("<!-- k-apis: ns/other -->", "pr-branch-"); err != nil || openPullRequest != nil {
+		t.Fatalf("expected no open pull request, got: %+v, error: %v", openPullRequest, err)
+	}
+
+	created, err := provider.CreatePullRequest(&PullRequest{Head: "pr-branch-2", Base: "main", Title: "title", Body: "body", Labels: []string{"k-apis"}, Reviewers: []string{"reviewer"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if created.Number != 8 || created.URL != "https://github.com/qlik/manifests/pull/8" {
+		t.Fatalf("unexpected pull request: %+v", created)
+	}
+	if request := findRequest(*requests, "POST", "/repos/qlik/manifests/pulls"); request == nil {
+		t.Fatal("expected the pull request to be created")
+	} else if request.header.Get("Authorization") != "token secret" {
+		t.Fatalf("unexpected authorization: %v", request.header.Get("Authorization"))
+	} else if request.body["head"] != "pr-branch-2" || request.body["base"] != "main" || request.body["title"] != "title" {
+		t.Fatalf("unexpected request: %v", request.body)
+	}
+	if request := findRequest(*requests, "POST", "/repos/qlik/manifests/issues/8/labels"); request == nil || !reflect.DeepEqual(request.body["labels"], []interface{}{"k-apis"}) {
+		t.Fatalf("expected the labels to be added, got: %+v", request)
+	}
+	if request := findRequest(*requests, "POST", "/repos/qlik/manifests/pulls/8/requested_reviewers"); request == nil || !reflect.DeepEqual(request.body["reviewers"], []interface{}{"reviewer"}) {
+		t.Fatalf("expected the reviewers to be requested, got: %+v", request)
+	}
+
+	if updated, err := provider.UpdatePullRequest(&PullRequest{Number: 7, Head: "pr-branch-1", Base: "main", Title: "new title", Body: "new body"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if updated.URL != "https://github.com/qlik/manifests/pull/7" {
+		t.Fatalf("unexpected pull request: %+v", updated)
+	}
+	if request := findRequest(*requests, "PATCH", "/repos/qlik/manifests/pulls/7"); request == nil || request.body["title"] != "new title" || request.body["body"] != "new body" {
+		t.Fatalf("expected the pull request to be updated, got: %+v", request)
+	}
+}
+
+func TestGitLabPullRequestProvider(t *testing.T) {
+	server, requests := newFakeApiServer(t, map[string]string{
+		"GET /projects/group%2Fmanifests/merge_requests":   `[{"iid":3,"web_url":"https://gitlab.com/group/manifests/-/merge_requests/3","description":"<!-- k-apis: ns/cr -->","source_branch":"pr-branch-1","target_branch":"master","source_project_id":1,"target_project_id":1}]`,
+		"POST /projects/group%2Fmanifests/merge_requests":  `{"iid":4,"web_url":"https://gitlab.com/group/manifests/-/merge_requests/4"}`,
+		"PUT /projects/group%2Fmanifests/merge_requests/3": `{"iid":3,"web_url":"https://gitlab.com/group/manifests/-/merge_requests/3"}`,
+		"GET /users": `[{"id":42}]`,
+	})
+	defer server.Close()
+
+	provider, err := NewPullRequestProvider(PullRequestProviderGitLab, server.URL, "git@gitlab.com:group/manifests.git", "secret", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if openPullRequest, err := provider.FindOpenPullRequest("<!-- k-apis: ns/cr -->", "pr-branch-"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if openPullRequest == nil || openPullRequest.Number != 3 || openPullRequest.Head != "pr-branch-1" {
+		t.Fatalf("unexpected open merge request: %+v", openPullRequest)
+	}
+
+	if created, err := provider.CreatePullRequest(&PullRequest{Head: "pr-branch-2", Base: "master", Title: "title", Body: "body", Labels: []string{"a", "b"}, Reviewers: []string{"reviewer"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if created.Number != 4 {
+		t.Fatalf("unexpected merge request: %+v", created)
+	}
+	if request := findRequest(*requests, "POST", "/projects/group%2Fmanifests/merge_requests"); request == nil {
+		t.Fatal("expected the merge request to be created")
+	} else if request.header.Get("PRIVATE-TOKEN") != "secret" {
+		t.Fatalf("unexpected token: %v", request.header.Get("PRIVATE-TOKEN"))
+	} else if request.body["source_branch"] != "pr-branch-2" || request.body["target_branch"] != "master" || request.body["description"] != "body" ||
+		request.body["labels"] != "a,b" || !reflect.DeepEqual(request.body["reviewer_ids"], []interface{}{float64(42)}) {
+		t.Fatalf("unexpected request: %v", request.body)
+	}
+
+	if _, err := provider.UpdatePullRequest(&PullRequest{Number: 3, Base: "master", Title: "new title", Body: "new body", Labels: []string{"a"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if request := findRequest(*requests, "PUT", "/projects/group%2Fmanifests/merge_requests/3"); request == nil || request.body["title"] != "new title" || request.body["add_labels"] != "a" {
+		t.Fatalf("expected the merge request to be updated, got: %+v", request)
+	}
+}
+
+func TestGiteaPullRequestProvider(t *testing.T) {
+	if _, err := NewPullRequestProvider(PullRequestProviderGitea, "", "https://gitea.example.com/qlik/manifests", "secret", nil); err == nil {
+		t.Fatal("expected an error without an API URL")
+	}
+
+	server, requests := newFakeApiServer(t, map[string]string{
+		"GET /api/v1/repos/qlik/manifests/pulls":                        `[]`,
+		"GET /api/v1/repos/qlik/manifests/labels":                       `[{"id":5,"name":"k-apis"}]`,
+		"POST /api/v1/repos/qlik/manifests/pulls":                       `{"number":2,"html_url":"https://gitea.example.com/qlik/manifests/pulls/2"}`,
+		"POST /api/v1/repos/qlik/manifests/pulls/2/requested_reviewers": `[]`,
+	})
+	defer server.Close()
+
+	provider, err := NewPullRequestProvider(PullRequestProviderGitea, server.URL+"/api/v1", "https://gitea.example.com/qlik/manifests", "secret", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if openPullRequest, err := provider.FindOpenPullRequest("<!-- k-apis: ns/cr -->", "pr-branch-"); err != nil || openPullRequest != nil {
+		t.Fatalf("expected no open pull request, got: %+v, error: %v", openPullRequest, err)
+	}
+	if created, err := provider.CreatePullRequest(&PullRequest{Head: "pr-branch-1", Base: "master", Title: "title", Body: "body", Labels: []string{"k-apis"}, Reviewers: []string{"reviewer"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if created.Number != 2 || !strings.HasSuffix(created.URL, "/pulls/2") {
+		t.Fatalf("unexpected pull request: %+v", created)
+	}
+	if request := findRequest(*requests, "POST", "/api/v1/repos/qlik/manifests/pulls"); request == nil || !reflect.DeepEqual(request.body["labels"], []interface{}{float64(5)}) {
+		t.Fatalf("expected the pull request to be created with the label ids, got: %+v", request)
+	}
+	if request := findRequest(*requests, "POST", "/api/v1/repos/qlik/manifests/pulls/2/requested_reviewers"); request == nil {
+		t.Fatal("expected the reviewers to be requested")
+	}
+
+	if _, err := provider.CreatePullRequest(&PullRequest{Head: "pr-branch-1", Base: "master", Labels: []string{"unknown"}}); err == nil {
+		t.Fatal("expected an error for an unknown label")
+	}
+}
+
+func TestFindOpenPullRequest_ownPullRequestsOnly(t *testing.T) {
+	var firstPage []string
+	for i := 0; i < 100; i++ {
+		firstPage = append(firstPage, fmt.Sprintf(`{"number":%v,"body":"other","head":{"ref":"pr-branch-%v","repo":{"full_name":"qlik/manifests"}}}`, i+10, i))
+	}
+	server, _ := newFakeApiServer(t, map[string]string{
+		"GET /repos/qlik/manifests/pulls?state=open&per_page=100&page=1": "[" + strings.Join(firstPage, ",") + "]",
+		"GET /repos/qlik/manifests/pulls?state=open&per_page=100&page=2": `[
+			{"number":1,"body":"<!-- k-apis: ns/cr -->","head":{"ref":"pr-branch-1","repo":{"full_name":"attacker/manifests"}}},
+			{"number":2,"body":"<!-- k-apis: ns/cr -->","head":{"ref":"pr-branch-2","repo":null}},
+			{"number":3,"body":"<!-- k-apis: ns/cr -->","head":{"ref":"master","repo":{"full_name":"qlik/manifests"}}},
+			{"number":4,"body":"<!-- k-apis: ns/cr -->","head":{"ref":"pr-branch-4","repo":{"full_name":"Qlik/Manifests"}}}]`,
+	})
+	defer server.Close()
+	provider, err := NewPullRequestProvider(PullRequestProviderGitHub, server.URL, "https://github.com/qlik/manifests", "secret", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the pull requests from forks, and of branches not created by k-apis, are never force pushed to
+	if openPullRequest, err := provider.FindOpenPullRequest("<!-- k-apis: ns/cr -->", "pr-branch-"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if openPullRequest == nil || openPullRequest.Number != 4 {
+		t.Fatalf("expected the pull request of the second page from a branch of the repository, got: %+v", openPullRequest)
+	}
+
+	server, _ = newFakeApiServer(t, map[string]string{
+		"GET /projects/group%2Fmanifests/merge_requests": `[
+			{"iid":1,"description":"<!-- k-apis: ns/cr -->","source_branch":"pr-branch-1","source_project_id":2,"target_project_id":1},
+			{"iid":2,"description":"<!-- k-apis: ns/cr -->","source_branch":"main","source_project_id":1,"target_project_id":1}]`,
+	})
+	defer server.Close()
+	if provider, err = NewPullRequestProvider(PullRequestProviderGitLab, server.URL, "https://gitlab.com/group/manifests", "secret", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if openPullRequest, err := provider.FindOpenPullRequest("<!-- k-apis: ns/cr -->", "pr-branch-"); err != nil || openPullRequest != nil {
+		t.Fatalf("expected no open merge request, got: %+v, error: %v", openPullRequest, err)
+	}
+
+	server, _ = newFakeApiServer(t, map[string]string{
+		"GET /repos/qlik/manifests/pulls?state=open&limit=50&page=1": `[
+			{"number":1,"body":"<!-- k-apis: ns/cr -->","head":{"ref":"pr-branch-1","repo":{"full_name":"attacker/manifests"}}},
+			{"number":2,"body":"<!-- k-apis: ns/cr -->","head":{"ref":"pr-branch-2","repo":{"full_name":"qlik/manifests"}}}]`,
+	})
+	defer server.Close()
+	if provider, err = NewPullRequestProvider(PullRequestProviderGitea, server.URL, "https://gitea.example.com/qlik/manifests", "secret", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if openPullRequest, err := provider.FindOpenPullRequest("<!-- k-apis: ns/cr -->", "pr-branch-"); err != nil || openPullRequest == nil || openPullRequest.Number != 2 {
+		t.Fatalf("expected the pull request from a branch of the repository, got: %+v, error: %v", openPullRequest, err)
+	}
+}
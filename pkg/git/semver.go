@@ -0,0 +1,245 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tags like v1.2.3, 1.2.3-rc.1+build.5, minor and patch may be omitted: v1.2
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)(?:\.(0|[1-9]\d*))?(?:\.(0|[1-9]\d*))?(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+// constraint comparisons, ex. >=1.2, ~1.2.3, ^1, =1.4 or 1.4
+var semverComparisonRegexp = regexp.MustCompile(`^(=|!=|>=|<=|>|<|~|\^)?\s*(.+)$`)
+
+type semanticVersion struct {
+	major      uint64
+	minor      uint64
+	patch      uint64
+	prerelease []string
+	// the number of major, minor and patch parts present, a partial version in a constraint is a range
+	parts int
+}
+
+func parseSemanticVersion(version string) (*semanticVersion, error) {
+	matches := semverRegexp.FindStringSubmatch(strings.TrimSpace(version))
+	if matches == nil {
+		return nil, fmt.Errorf("invalid semantic version: %v", version)
+	}
+	v := &semanticVersion{}
+	for i, part := range []*uint64{&v.major, &v.minor, &v.patch} {
+		if matches[i+1] == "" {
+			break
+		}
+		number, err := strconv.ParseUint(matches[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version: %v, %w", version, err)
+		}
+		*part = number
+		v.parts++
+	}
+	if matches[4] != "" {
+		v.prerelease = strings.Split(matches[4], ".")
+	}
+	return v, nil
+}
+
+func (v *semanticVersion) isPrerelease() bool {
+	return len(v.prerelease) > 0
+}
+
+// compare returns -1, 0 or 1 if v is lower, equal or greater than other, build metadata is ignored
+func (v *semanticVersion) compare(other *semanticVersion) int {
+	for _, parts := range [][2]uint64{{v.major, other.major}, {v.minor, other.minor}, {v.patch, other.patch}} {
+		if parts[0] < parts[1] {
+			return -1
+		} else if parts[0] > parts[1] {
+			return 1
+		}
+	}
+	// a prerelease is lower than its release
+	if !v.isPrerelease() && !other.isPrerelease() {
+		return 0
+	} else if !v.isPrerelease() {
+		return 1
+	} else if !other.isPrerelease() {
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		if result := comparePrereleaseIdentifier(v.prerelease[i], other.prerelease[i]); result != 0 {
+			return result
+		}
+	}
+	if len(v.prerelease) < len(other.prerelease) {
+		return -1
+	} else if len(v.prerelease) > len(other.prerelease) {
+		return 1
+	}
+	return 0
+}
+
+// comparePrereleaseIdentifier compares numeric identifiers numerically and lower than alphanumeric ones
+func comparePrereleaseIdentifier(identifier, other string) int {
+	number, err := strconv.ParseUint(identifier, 10, 64)
+	otherNumber, otherErr := strconv.ParseUint(other, 10, 64)
+	if err == nil && otherErr == nil {
+		if number < otherNumber {
+			return -1
+		} else if number > otherNumber {
+			return 1
+		}
+		return 0
+	} else if err == nil {
+		return -1
+	} else if otherErr == nil {
+		return 1
+	}
+	return strings.Compare(identifier, other)
+}
+
+// next returns the lowest release above the range of a partial version, ex. 1.3.0 for 1.2 and 2.0.0 for 1
+func (v *semanticVersion) next(parts int) *semanticVersion {
+	switch parts {
+	case 1:
+		return &semanticVersion{major: v.major + 1, parts: 3}
+	case 2:
+		return &semanticVersion{major: v.major, minor: v.minor + 1, parts: 3}
+	default:
+		return &semanticVersion{major: v.major, minor: v.minor, patch: v.patch + 1, parts: 3}
+	}
+}
+
+// below returns true if v is lower than bound, prereleases of a release bound aren't: <2 leaves out 2.0.0-rc.1
+func (v *semanticVersion) below(bound *semanticVersion) bool {
+	if v.isPrerelease() && !bound.isPrerelease() && v.major == bound.major && v.minor == bound.minor && v.patch == bound.patch {
+		return false
+	}
+	return v.compare(bound) < 0
+}
+
+type semverComparison struct {
+	operator string
+	version  *semanticVersion
+	// the exclusive upper bound of the range of a partial version, only set for !=
+	upper *semanticVersion
+}
+
+func (c *semverComparison) matches(v *semanticVersion) bool {
+	result := v.compare(c.version)
+	switch c.operator {
+	case "=":
+		return result == 0
+	case "!=":
+		if c.upper != nil {
+			// !=1.2 is <1.2.0 || >=1.3.0
+			return result < 0 || !v.below(c.upper)
+		}
+		return result != 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	case "<":
+		return v.below(c.version)
+	default:
+		return result <= 0
+	}
+}
+
+// semverConstraint matches a version matching all the comparisons of any of its ranges
+type semverConstraint [][]*semverComparison
+
+// parseSemverConstraint parses ranges separated by ||, of comparisons separated by spaces or commas, ex. ">=1.2 <2 || ^3",
+// partial versions are ranges: =1.2 matches 1.2.x, !=1.2 doesn't, ~1.2.3 matches >=1.2.3 <1.3.0 and ^1.2.3 matches >=1.2.3 <2.0.0
+func parseSemverConstraint(constraint string) (semverConstraint, error) {
+	var result semverConstraint
+	for _, rangeText := range strings.Split(constraint, "||") {
+		var comparisons []*semverComparison
+		// operators separated from their version by spaces are joined with it
+		fields := strings.FieldsFunc(rangeText, func(r rune) bool { return r == ' ' || r == ',' })
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if strings.Trim(field, "=!<>~^") == "" && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			rangeComparisons, err := parseSemverComparison(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid semantic version constraint: %v, %w", constraint, err)
+			}
+			comparisons = append(comparisons, rangeComparisons...)
+		}
+		if len(comparisons) == 0 {
+			return nil, fmt.Errorf("invalid semantic version constraint: %v", constraint)
+		}
+		result = append(result, comparisons)
+	}
+	return result, nil
+}
+
+func parseSemverComparison(text string) ([]*semverComparison, error) {
+	matches := semverComparisonRegexp.FindStringSubmatch(text)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid comparison: %v", text)
+	}
+	operator := matches[1]
+	version, err := parseSemanticVersion(matches[2])
+	if err != nil {
+		return nil, err
+	}
+	switch operator {
+	case "", "=":
+		if version.parts < 3 {
+			return []*semverComparison{{operator: ">=", version: version}, {operator: "<", version: version.next(version.parts)}}, nil
+		}
+		return []*semverComparison{{operator: "=", version: version}}, nil
+	case "!=":
+		if version.parts < 3 {
+			return []*semverComparison{{operator: "!=", version: version, upper: version.next(version.parts)}}, nil
+		}
+	case "~":
+		parts := version.parts
+		if parts == 3 {
+			parts = 2
+		}
+		return []*semverComparison{{operator: ">=", version: version}, {operator: "<", version: version.next(parts)}}, nil
+	case "^":
+		// the first non-zero part is fixed
+		parts := 1
+		if version.major == 0 && version.parts > 1 {
+			parts = 2
+			if version.minor == 0 && version.parts > 2 {
+				parts = 3
+			}
+		}
+		return []*semverComparison{{operator: ">=", version: version}, {operator: "<", version: version.next(parts)}}, nil
+	case ">":
+		if version.parts < 3 {
+			// >1.2 is above 1.2.x
+			return []*semverComparison{{operator: ">=", version: version.next(version.parts)}}, nil
+		}
+	case "<=":
+		if version.parts < 3 {
+			// <=1.2 includes 1.2.x
+			return []*semverComparison{{operator: "<", version: version.next(version.parts)}}, nil
+		}
+	}
+	return []*semverComparison{{operator: operator, version: version}}, nil
+}
+
+func (c semverConstraint) matches(v *semanticVersion) bool {
+	for _, comparisons := range c {
+		matches := true
+		for _, comparison := range comparisons {
+			if !comparison.matches(v) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
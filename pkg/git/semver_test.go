@@ -0,0 +1,178 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestSemanticVersionCompare(t *testing.T) {
+	ordered := []string{"v0.9.0", "v1.0.0-alpha", "v1.0.0-alpha.1", "v1.0.0-alpha.beta", "v1.0.0-beta.2", "v1.0.0-beta.11", "v1.0.0-rc.1", "v1.0.0", "v1.9.0", "v1.10.0", "v2"}
+	for i := 0; i < len(ordered)-1; i++ {
+		v, err := parseSemanticVersion(ordered[i])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		next, err := parseSemanticVersion(ordered[i+1])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v.compare(next) != -1 || next.compare(v) != 1 {
+			t.Fatalf("expected %v to be lower than %v", ordered[i], ordered[i+1])
+		}
+	}
+	if v, _ := parseSemanticVersion("1.2.3+build.1"); v.compare(&semanticVersion{major: 1, minor: 2, patch: 3}) != 0 {
+		t.Fatal("expected the build metadata to be ignored")
+	}
+	for _, invalid := range []string{"master", "v1.2.3.4", "v01.2.3", "release-1.2"} {
+		if _, err := parseSemanticVersion(invalid); err == nil {
+			t.Fatalf("expected an error for: %v", invalid)
+		}
+	}
+}
+
+func TestSemverConstraint(t *testing.T) {
+	testCases := []struct {
+		constraint string
+		matches    []string
+		mismatches []string
+	}{
+		{">=1.2 <2", []string{"1.2.0", "v1.10.3"}, []string{"1.1.9", "2.0.0"}},
+		{">= 1.2, < 2", []string{"1.2.0"}, []string{"2.0.0"}},
+		{"1.4", []string{"1.4.0", "1.4.9"}, []string{"1.5.0", "1.3.9"}},
+		{"=1.4.2", []string{"1.4.2"}, []string{"1.4.3"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0", "1.2.2"}},
+		{"^0.2.3", []string{"0.2.5"}, []string{"0.3.0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"<1 || >=3", []string{"0.9.0", "3.1.0"}, []string{"1.0.0", "2.9.9"}},
+		{"!=1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{"!=1.2", []string{"1.1.9", "1.3.0"}, []string{"1.2.0", "1.2.9"}},
+		{"!=1", []string{"0.9.0", "2.0.0"}, []string{"1.0.0", "1.9.9"}},
+		{"<2", []string{"1.9.9", "1.9.9-rc.1"}, []string{"2.0.0-rc.1", "2.0.0"}},
+		{"^1.2.3", []string{"1.9.9"}, []string{"2.0.0-rc.1"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0-rc.1"}},
+		{"<2.0.0-rc.2", []string{"2.0.0-rc.1", "1.9.9"}, []string{"2.0.0-rc.2", "2.0.0"}},
+	}
+	for _, testCase := range testCases {
+		constraint, err := parseSemverConstraint(testCase.constraint)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", testCase.constraint, err)
+		}
+		for _, version := range testCase.matches {
+			if v, _ := parseSemanticVersion(version); !constraint.matches(v) {
+				t.Fatalf("expected %v to match %v", version, testCase.constraint)
+			}
+		}
+		for _, version := range testCase.mismatches {
+			if v, _ := parseSemanticVersion(version); constraint.matches(v) {
+				t.Fatalf("expected %v not to match %v", version, testCase.constraint)
+			}
+		}
+	}
+	for _, invalid := range []string{"", ">=foo", "||"} {
+		if _, err := parseSemverConstraint(invalid); err == nil {
+			t.Fatalf("expected an error for: %v", invalid)
+		}
+	}
+}
+
+func TestGetRemoteRefs_semver(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	originPath := filepath.Join(tmpDir, "origin")
+	origin, err := git.PlainInit(originPath, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	workTree, _ := origin.Worktree()
+	if err := ioutil.WriteFile(filepath.Join(originPath, "README.md"), []byte("qliksense"), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := workTree.Add("README.md"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hash, err := workTree.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "k-apis"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tag := range []string{"v1.9.0", "v1.10.0", "v1.2.0", "v2.0.0-rc.1", "v0.9.1", "latest"} {
+		if _, err := origin.CreateTag(tag, hash, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	r, err := CloneRepository(filepath.Join(tmpDir, "clone"), originPath, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	remoteRefsList, err := GetRemoteRefs(r, nil, &RemoteRefConstraints{
+		Include:   true,
+		Sort:      true,
+		SortOrder: RefSortOrderDescending,
+		SortMode:  RefSortModeSemver,
+	}, &RemoteRefConstraints{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if expected := []string{"v1.10.0", "v1.9.0", "v1.2.0", "v0.9.1"}; !reflect.DeepEqual(remoteRefsList[0].Tags, expected) {
+		t.Fatalf("expected tags: %v, got: %v", expected, remoteRefsList[0].Tags)
+	}
+
+	remoteRefsList, err = GetRemoteRefs(r, nil, &RemoteRefConstraints{
+		Include:           true,
+		Sort:              true,
+		SortOrder:         RefSortOrderAscending,
+		SemverConstraint:  ">=1.2",
+		IncludePrerelease: true,
+	}, &RemoteRefConstraints{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if expected := []string{"v1.2.0", "v1.9.0", "v1.10.0", "v2.0.0-rc.1"}; !reflect.DeepEqual(remoteRefsList[0].Tags, expected) {
+		t.Fatalf("expected tags: %v, got: %v", expected, remoteRefsList[0].Tags)
+	}
+
+	// prereleases of an exclusive upper bound are left out
+	remoteRefsList, err = GetRemoteRefs(r, nil, &RemoteRefConstraints{
+		Include:           true,
+		Sort:              true,
+		SortOrder:         RefSortOrderAscending,
+		SemverConstraint:  "<2",
+		IncludePrerelease: true,
+	}, &RemoteRefConstraints{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if expected := []string{"v0.9.1", "v1.2.0", "v1.9.0", "v1.10.0"}; !reflect.DeepEqual(remoteRefsList[0].Tags, expected) {
+		t.Fatalf("expected tags: %v, got: %v", expected, remoteRefsList[0].Tags)
+	}
+
+	// lexical sort is unchanged
+	remoteRefsList, err = GetRemoteRefs(r, nil, &RemoteRefConstraints{Include: true, Sort: true}, &RemoteRefConstraints{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if expected := []string{"latest", "v0.9.1", "v1.10.0", "v1.2.0", "v1.9.0", "v2.0.0-rc.1"}; !reflect.DeepEqual(remoteRefsList[0].Tags, expected) {
+		t.Fatalf("expected tags: %v, got: %v", expected, remoteRefsList[0].Tags)
+	}
+
+	if latestTags, err := GetLatestRemoteTags(r, nil, "<2", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(latestTags, map[string]string{"origin": "v1.10.0"}) {
+		t.Fatalf("unexpected latest tags: %v", latestTags)
+	}
+	if latestTags, err := GetLatestRemoteTags(r, nil, ">v1.10.0", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(latestTags, map[string]string{"origin": "v2.0.0-rc.1"}) {
+		t.Fatalf("unexpected latest tags: %v", latestTags)
+	}
+	if _, err := GetLatestRemoteTags(r, nil, ">=foo", false); err == nil {
+		t.Fatal("expected an error for an invalid constraint")
+	}
+}
@@ -0,0 +1,178 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const defaultCACommonName = "qliksense-internal-ca"
+
+// CertificateAuthority holds PEM encoded CA certificate and private key used to sign service certificates
+type CertificateAuthority struct {
+	Certificate []byte
+	Key         []byte
+}
+
+// GenerateCertificateAuthority creates a new self-signed CA
+func GenerateCertificateAuthority(commonName, organization string, validity time.Duration) (*CertificateAuthority, error) {
+	if commonName == "" {
+		commonName = defaultCACommonName
+	}
+	if organization == "" {
+		organization = defaultCertOrganization
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := generateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{organization},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+	key, err := getPrivateKeyPKCS8Pem(priv)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}),
+		Key:         key,
+	}, nil
+}
+
+// LoadCertificateAuthority validates an existing PEM encoded CA certificate and private key pair
+func LoadCertificateAuthority(certificate, key []byte) (*CertificateAuthority, error) {
+	ca := &CertificateAuthority{Certificate: certificate, Key: key}
+	if caCert, caKey, err := ca.parse(); err != nil {
+		return nil, err
+	} else if !caCert.IsCA {
+		return nil, errors.New("certificate is not a CA certificate")
+	} else if !publicKeysEqual(caCert.PublicKey, caKey.Public()) {
+		return nil, errors.New("CA private key does not match the CA certificate")
+	}
+	return ca, nil
+}
+
+// GetSignedCertAndKey issues a server certificate signed by the CA for the given DNS names
+// if no DNS names are provided the certificate is issued for the common name and its subdomains
+func (ca *CertificateAuthority) GetSignedCertAndKey(commonName, organization string, dnsNames []string, validity time.Duration) (certificate, key []byte, err error) {
+	return GenerateCertAndKey(CertificateOptions{
+		CommonName:   commonName,
+		Organization: organization,
+		DNSNames:     dnsNames,
+		Validity:     validity,
+		KeyType:      KeyTypeRSA2048,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, ca)
+}
+
+// IsIssuerOf returns true if the first certificate of a PEM encoded certificate (chain) is signed by the CA
+func (ca *CertificateAuthority) IsIssuerOf(certificate []byte) (bool, error) {
+	caCert, _, err := ca.parse()
+	if err != nil {
+		return false, err
+	}
+	block, _ := pem.Decode(certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return false, errors.New("failed to decode the certificate pem")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false, err
+	}
+	return cert.CheckSignatureFrom(caCert) == nil, nil
+}
+
+// GetServiceDNSNames returns the in-cluster DNS names of a kubernetes service
+func GetServiceDNSNames(serviceName, namespace string) []string {
+	return []string{
+		serviceName,
+		fmt.Sprintf("%v.%v", serviceName, namespace),
+		fmt.Sprintf("%v.%v.svc", serviceName, namespace),
+		fmt.Sprintf("%v.%v.svc.cluster.local", serviceName, namespace),
+	}
+}
+
+func (ca *CertificateAuthority) parse() (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode(ca.Certificate)
+	if certBlock == nil {
+		return nil, nil, errors.New("failed to decode the CA certificate pem")
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(ca.Key)
+	if keyBlock == nil {
+		return nil, nil, errors.New("failed to decode the CA private key pem")
+	}
+	caKey, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return caCert, caKey, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New("unsupported private key type")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to parse the private key")
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	if k, ok := a.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return k.Equal(b)
+	}
+	return false
+}
+
+func getPrivateKeyPKCS8Pem(priv crypto.Signer) ([]byte, error) {
+	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal private key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), nil
+}
+
+func generateSerialNumber() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serialNumber, nil
+}
@@ -0,0 +1,69 @@
+package keys
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_GetSignedCertAndKey(t *testing.T) {
+	ca, err := GenerateCertificateAuthority("test-ca", "test-org", time.Hour*24)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := LoadCertificateAuthority(ca.Certificate, ca.Key); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dnsNames := GetServiceDNSNames("qliksense-elastic-infra", "test-ns")
+	certPem, _, err := ca.GetSignedCertAndKey("qliksense-elastic-infra", "", dnsNames, time.Hour*24*365)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	caBlock, _ := pem.Decode(ca.Certificate)
+	caCert, err := x509.ParseCertificate(caBlock.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		t.Fatal("expected a pem encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if cert.Issuer.CommonName != "test-ca" {
+		t.Fatalf("expected issuer to be: test-ca, got: %v", cert.Issuer.CommonName)
+	} else if !reflect.DeepEqual(cert.DNSNames, dnsNames) {
+		t.Fatalf("expected DNS names: %v, got: %v", dnsNames, cert.DNSNames)
+	} else if cert.NotAfter.After(caCert.NotAfter) {
+		t.Fatal("expected certificate to expire no later than the CA")
+	}
+
+	if isIssuer, err := ca.IsIssuerOf(certPem); err != nil || !isIssuer {
+		t.Fatalf("expected the CA to be the issuer, got: %v, error: %v", isIssuer, err)
+	}
+	selfSignedPem, _, err := GetSelfSignedCertAndKey("qliksense-elastic-infra", "", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if isIssuer, err := ca.IsIssuerOf(selfSignedPem); err != nil || isIssuer {
+		t.Fatalf("expected the CA not to be the issuer of a self-signed certificate, got: %v, error: %v", isIssuer, err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "qliksense-elastic-infra.test-ns.svc", Roots: roots}); err != nil {
+		t.Fatalf("unexpected error verifying the certificate: %v", err)
+	}
+}
+
+func Test_LoadCertificateAuthority_rejectsNonCA(t *testing.T) {
+	certPem, keyPem, err := GetSelfSignedCertAndKey("", "", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := LoadCertificateAuthority(certPem, keyPem); err == nil {
+		t.Fatal("expected an error, but didn't get it")
+	}
+}
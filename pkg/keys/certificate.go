@@ -0,0 +1,211 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type KeyType string
+
+const (
+	KeyTypeRSA2048   KeyType = "rsa2048"
+	KeyTypeRSA4096   KeyType = "rsa4096"
+	KeyTypeECDSAP256 KeyType = "ecdsa256"
+	KeyTypeECDSAP384 KeyType = "ecdsa384"
+)
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"certsign":          x509.KeyUsageCertSign,
+	"crlsign":           x509.KeyUsageCRLSign,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// CertificateOptions describes a TLS certificate to generate
+// empty values fall back to the defaults used by GetSelfSignedCertAndKey
+type CertificateOptions struct {
+	CommonName   string
+	Organization string
+	DNSNames     []string
+	IPAddresses  []net.IP
+	Validity     time.Duration
+	KeyType      KeyType
+	KeyUsage     x509.KeyUsage
+	ExtKeyUsage  []x509.ExtKeyUsage
+}
+
+// CertificateInfo describes an existing certificate
+type CertificateInfo struct {
+	CommonName  string
+	Subject     string
+	Issuer      string
+	DNSNames    []string
+	IPAddresses []string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	IsCA        bool
+}
+
+// GetCertificateInfo parses the first certificate of a PEM encoded certificate (chain)
+func GetCertificateInfo(certificate []byte) (*CertificateInfo, error) {
+	block, _ := pem.Decode(certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to decode the certificate pem")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	info := &CertificateInfo{
+		CommonName: cert.Subject.CommonName,
+		Subject:    cert.Subject.String(),
+		Issuer:     cert.Issuer.String(),
+		DNSNames:   cert.DNSNames,
+		NotBefore:  cert.NotBefore,
+		NotAfter:   cert.NotAfter,
+		IsCA:       cert.IsCA,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info, nil
+}
+
+// ExpiresWithin returns true if the certificate expires before now + window
+func (info *CertificateInfo) ExpiresWithin(window time.Duration) bool {
+	return time.Now().Add(window).After(info.NotAfter)
+}
+
+// GenerateCertAndKey generates a certificate signed by ca, or a self-signed one if ca is nil
+func GenerateCertAndKey(options CertificateOptions, ca *CertificateAuthority) (certificate, key []byte, err error) {
+	if options.CommonName == "" {
+		options.CommonName = defaultCertCommonName
+	}
+	if options.Organization == "" {
+		options.Organization = defaultCertOrganization
+	}
+	if len(options.DNSNames) == 0 {
+		options.DNSNames = []string{options.CommonName, fmt.Sprintf("*.%v", options.CommonName)}
+	}
+	if options.Validity == 0 {
+		options.Validity = defaultCertValidity
+	}
+	if options.KeyUsage == 0 {
+		options.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	if len(options.ExtKeyUsage) == 0 {
+		options.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	priv, err := generatePrivateKey(options.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := generateSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   options.CommonName,
+			Organization: []string{options.Organization},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(options.Validity),
+		KeyUsage:              options.KeyUsage,
+		ExtKeyUsage:           options.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		DNSNames:              options.DNSNames,
+		IPAddresses:           options.IPAddresses,
+	}
+
+	parent, signer := &template, priv
+	if ca != nil {
+		caCert, caKey, err := ca.parse()
+		if err != nil {
+			return nil, nil, err
+		}
+		if template.NotAfter.After(caCert.NotAfter) {
+			template.NotAfter = caCert.NotAfter
+		}
+		parent, signer = caCert, caKey
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, parent, priv.Public(), signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %s", err)
+	}
+	certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+
+	if key, err = getPrivateKeyPKCS8Pem(priv); err != nil {
+		return nil, nil, err
+	}
+	return certificate, key, nil
+}
+
+// ParseKeyUsages converts key usage names (ex. digitalSignature, keyEncipherment, serverAuth) into x509 key usages
+func ParseKeyUsages(usages []string) (keyUsage x509.KeyUsage, extKeyUsage []x509.ExtKeyUsage, err error) {
+	for _, usage := range usages {
+		name := strings.ToLower(strings.ReplaceAll(usage, "-", ""))
+		if ku, ok := keyUsages[name]; ok {
+			keyUsage |= ku
+		} else if eku, ok := extKeyUsages[name]; ok {
+			extKeyUsage = append(extKeyUsage, eku)
+		} else {
+			return 0, nil, fmt.Errorf("unsupported key usage: %v", usage)
+		}
+	}
+	return keyUsage, extKeyUsage, nil
+}
+
+// ParseIPAddresses converts IP address strings into net.IP
+func ParseIPAddresses(addresses []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %v", address)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func generatePrivateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case "", KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %v", keyType)
+	}
+}
@@ -0,0 +1,65 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_GenerateCertAndKey(t *testing.T) {
+	keyUsage, extKeyUsage, err := ParseKeyUsages([]string{"digitalSignature", "serverAuth", "client-auth"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ipAddresses, err := ParseIPAddresses([]string{"10.0.0.1", "::1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certPem, _, err := GenerateCertAndKey(CertificateOptions{
+		CommonName:  "qliksense.example",
+		DNSNames:    []string{"qliksense.example", "elastic.qliksense.example"},
+		IPAddresses: ipAddresses,
+		Validity:    time.Hour * 24,
+		KeyType:     KeyTypeECDSAP256,
+		KeyUsage:    keyUsage,
+		ExtKeyUsage: extKeyUsage,
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		t.Fatal("expected a pem encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Fatalf("expected an ecdsa public key, got: %T", cert.PublicKey)
+	} else if !reflect.DeepEqual(cert.DNSNames, []string{"qliksense.example", "elastic.qliksense.example"}) {
+		t.Fatalf("unexpected DNS names: %v", cert.DNSNames)
+	} else if len(cert.IPAddresses) != 2 || !cert.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("unexpected IP addresses: %v", cert.IPAddresses)
+	} else if cert.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Fatalf("unexpected key usage: %v", cert.KeyUsage)
+	} else if !reflect.DeepEqual(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}) {
+		t.Fatalf("unexpected extended key usage: %v", cert.ExtKeyUsage)
+	} else if cert.NotAfter.After(time.Now().Add(time.Hour * 25)) {
+		t.Fatalf("unexpected expiry: %v", cert.NotAfter)
+	}
+}
+
+func Test_GenerateCertAndKey_invalidOptions(t *testing.T) {
+	if _, _, err := ParseKeyUsages([]string{"foo"}); err == nil {
+		t.Fatal("expected an error for an unsupported key usage")
+	} else if _, err := ParseIPAddresses([]string{"not-an-ip"}); err == nil {
+		t.Fatal("expected an error for an invalid IP address")
+	} else if _, _, err := GenerateCertAndKey(CertificateOptions{KeyType: "dsa"}, nil); err == nil {
+		t.Fatal("expected an error for an unsupported key type")
+	}
+}
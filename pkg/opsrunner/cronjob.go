@@ -0,0 +1,114 @@
+package opsrunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"gopkg.in/yaml.v2"
+	batchV1 "k8s.io/api/batch/v1"
+	batchV1beta1 "k8s.io/api/batch/v1beta1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	opsRunnerSuffix  = "-ops-runner"
+	opsRunnerCommand = "ops-runner"
+	crFileName       = "cr.yaml"
+	crMountPath      = "/etc/ops-runner"
+)
+
+// GetCronJobManifest returns the manifests running ops-runner -once on the schedule of spec.opsRunner:
+// a secret with the CR and a batch/v1 CronJob (kubernetes 1.21+) mounting it, both named <CR name>-ops-runner.
+// The image of spec.opsRunner must have ops-runner in its PATH and the service account of the CronJob,
+// spec.opsRunner.serviceAccountName or the default one, must be allowed to read and write the secrets of the CR namespace.
+func GetCronJobManifest(kApiCr *config.KApiCr) ([]byte, error) {
+	opsRunner := kApiCr.Spec.OpsRunner
+	if opsRunner == nil || opsRunner.Image == "" {
+		return nil, fmt.Errorf("the ops runner CronJob needs spec.opsRunner.image")
+	} else if _, err := ParseSchedule(getSchedule(opsRunner)); err != nil {
+		return nil, err
+	}
+	name := kApiCr.GetObjectMeta().GetName() + opsRunnerSuffix
+	objectMeta := metaV1.ObjectMeta{
+		Name:      name,
+		Namespace: kApiCr.GetObjectMeta().GetNamespace(),
+		Labels:    map[string]string{"app": opsRunnerCommand, "release": kApiCr.GetObjectMeta().GetName()},
+	}
+
+	crYaml, err := toYaml(kApiCr)
+	if err != nil {
+		return nil, err
+	}
+	secret := &coreV1.Secret{
+		TypeMeta:   metaV1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: objectMeta,
+		Type:       coreV1.SecretTypeOpaque,
+		StringData: map[string]string{crFileName: string(crYaml)},
+	}
+
+	suspend := !opsRunner.IsEnabled()
+	var backoffLimit int32 = 0
+	// the batch/v1 CronJob has the fields of batch/v1beta1
+	cronJob := &batchV1beta1.CronJob{
+		TypeMeta:   metaV1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+		ObjectMeta: objectMeta,
+		Spec: batchV1beta1.CronJobSpec{
+			Schedule:          getSchedule(opsRunner),
+			ConcurrencyPolicy: batchV1beta1.ForbidConcurrent,
+			Suspend:           &suspend,
+			JobTemplate: batchV1beta1.JobTemplateSpec{
+				Spec: batchV1.JobSpec{
+					// the next tick retries
+					BackoffLimit: &backoffLimit,
+					Template: coreV1.PodTemplateSpec{
+						ObjectMeta: metaV1.ObjectMeta{Labels: objectMeta.Labels},
+						Spec: coreV1.PodSpec{
+							RestartPolicy:      coreV1.RestartPolicyNever,
+							ServiceAccountName: opsRunner.ServiceAccountName,
+							Containers: []coreV1.Container{{
+								Name:            opsRunnerCommand,
+								Image:           opsRunner.Image,
+								ImagePullPolicy: coreV1.PullPolicy(opsRunner.ImagePullPolicy),
+								Command:         []string{opsRunnerCommand, "-once", "-cr", crMountPath + "/" + crFileName},
+								VolumeMounts:    []coreV1.VolumeMount{{Name: "cr", MountPath: crMountPath, ReadOnly: true}},
+							}},
+							Volumes: []coreV1.Volume{{
+								Name:         "cr",
+								VolumeSource: coreV1.VolumeSource{Secret: &coreV1.SecretVolumeSource{SecretName: name}},
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var manifest bytes.Buffer
+	for i, object := range []interface{}{secret, cronJob} {
+		objectYaml, err := toYaml(object)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			manifest.WriteString("---\n")
+		}
+		manifest.Write(objectYaml)
+	}
+	return manifest.Bytes(), nil
+}
+
+// toYaml converts the json of object to yaml, the kubernetes types only have json tags
+func toYaml(object interface{}) ([]byte, error) {
+	jsonBytes, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var mapSlice yaml.MapSlice
+	if err := yaml.Unmarshal(jsonBytes, &mapSlice); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(mapSlice)
+}
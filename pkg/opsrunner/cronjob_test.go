@@ -0,0 +1,110 @@
+package opsrunner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"gopkg.in/yaml.v2"
+)
+
+func TestGetCronJobManifest(t *testing.T) {
+	kApiCr := &config.KApiCr{Spec: &config.CRSpec{
+		ManifestsRoot: "/tmp/manifests",
+		Git:           &config.Repo{Repository: "https://github.com/qlik/manifests"},
+		OpsRunner: &config.OpsRunner{
+			Enabled:            "yes",
+			Schedule:           "*/10 * * * *",
+			WatchBranch:        "release",
+			Image:              "qlik/k-apis:latest",
+			ImagePullPolicy:    "IfNotPresent",
+			ServiceAccountName: "ops-runner",
+		},
+	}}
+	kApiCr.SetName("qliksense")
+	kApiCr.SetNamespace("qlik")
+
+	manifest, err := GetCronJobManifest(kApiCr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	documents := strings.Split(string(manifest), "---\n")
+	if len(documents) != 2 {
+		t.Fatalf("expected a secret and a CronJob, got: %v", string(manifest))
+	}
+
+	var secret struct {
+		Kind       string
+		Metadata   struct{ Name, Namespace string }
+		StringData map[string]string `yaml:"stringData"`
+	}
+	if err := yaml.Unmarshal([]byte(documents[0]), &secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if secret.Kind != "Secret" || secret.Metadata.Name != "qliksense-ops-runner" || secret.Metadata.Namespace != "qlik" {
+		t.Fatalf("unexpected secret: %+v", secret)
+	}
+	// ops-runner reads the CR of the secret
+	if secretCr, err := config.ReadCRSpecFromFile(bytes.NewReader([]byte(secret.StringData["cr.yaml"]))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if secretCr.GetName() != "qliksense" || secretCr.Spec.OpsRunner.WatchBranch != "release" || secretCr.Spec.Git.Repository != kApiCr.Spec.Git.Repository {
+		t.Fatalf("unexpected CR: %+v", secretCr)
+	}
+
+	var cronJob struct {
+		ApiVersion string `yaml:"apiVersion"`
+		Kind       string
+		Spec       struct {
+			Schedule          string
+			ConcurrencyPolicy string `yaml:"concurrencyPolicy"`
+			Suspend           bool
+			JobTemplate       struct {
+				Spec struct {
+					Template struct {
+						Spec struct {
+							ServiceAccountName string `yaml:"serviceAccountName"`
+							Containers         []struct {
+								Image           string
+								ImagePullPolicy string `yaml:"imagePullPolicy"`
+								Command         []string
+							}
+							Volumes []struct {
+								Secret struct {
+									SecretName string `yaml:"secretName"`
+								}
+							}
+						}
+					}
+				}
+			} `yaml:"jobTemplate"`
+		}
+	}
+	if err := yaml.Unmarshal([]byte(documents[1]), &cronJob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	podSpec := cronJob.Spec.JobTemplate.Spec.Template.Spec
+	if cronJob.ApiVersion != "batch/v1" || cronJob.Kind != "CronJob" || cronJob.Spec.Schedule != "*/10 * * * *" ||
+		cronJob.Spec.ConcurrencyPolicy != "Forbid" || cronJob.Spec.Suspend {
+		t.Fatalf("unexpected CronJob: %+v", cronJob)
+	} else if len(podSpec.Containers) != 1 || podSpec.Containers[0].Image != "qlik/k-apis:latest" || podSpec.Containers[0].ImagePullPolicy != "IfNotPresent" ||
+		strings.Join(podSpec.Containers[0].Command, " ") != "ops-runner -once -cr /etc/ops-runner/cr.yaml" {
+		t.Fatalf("unexpected containers: %+v", podSpec.Containers)
+	} else if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].Secret.SecretName != "qliksense-ops-runner" {
+		t.Fatalf("unexpected volumes: %+v", podSpec.Volumes)
+	} else if podSpec.ServiceAccountName != "ops-runner" {
+		t.Fatalf("expected the service account: ops-runner, got: %v", podSpec.ServiceAccountName)
+	}
+
+	// a disabled ops runner is suspended
+	kApiCr.Spec.OpsRunner.Enabled = "no"
+	if manifest, err = GetCronJobManifest(kApiCr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !strings.Contains(string(manifest), "suspend: true") {
+		t.Fatalf("expected the CronJob to be suspended: %v", string(manifest))
+	}
+
+	kApiCr.Spec.OpsRunner.Image = ""
+	if _, err := GetCronJobManifest(kApiCr); err == nil {
+		t.Fatal("expected an error without an image")
+	}
+}
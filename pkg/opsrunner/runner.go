@@ -0,0 +1,191 @@
+// Package opsrunner regenerates the patches of a CR when the watch branch of its spec.git repository gets new commits,
+// either in process (Runner.Run) or in cluster from the CronJob of GetCronJobManifest running ops-runner
+package opsrunner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/cr"
+	crGit "github.com/qlik-oss/k-apis/pkg/git"
+)
+
+const (
+	defaultSchedule    = "*/5 * * * *"
+	defaultWatchBranch = "master"
+)
+
+// Runner checks the watch branch of a CR on every tick of its schedule
+type Runner struct {
+	Cr             *config.KApiCr
+	KubeConfigPath string
+	// a SecretStateStore if nil
+	State StateStore
+	// cr.GeneratePatchesWithOptions with GeneratePatchesOptions based on the watch branch if nil
+	GeneratePatches func(cr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string) (*cr.GitOpsResult, error)
+	// NewRunner verifies the keys as spec.opsRunner.verifyKeys asks
+	GeneratePatchesOptions *cr.GeneratePatchesOptions
+}
+
+// TickResult is the watch branch commit of a tick and whether the patches were generated for it,
+// if the branch moved since it was fetched it is the commit the patches are based on
+type TickResult struct {
+	Commit  string
+	Applied bool
+	// set if applied
+	GitOps *cr.GitOpsResult
+}
+
+// NewRunner returns the runner of a CR with spec.git and spec.opsRunner
+func NewRunner(kApiCr *config.KApiCr, kubeConfigPath string) (*Runner, error) {
+	if kApiCr.Spec.Git == nil || kApiCr.Spec.Git.Repository == "" {
+		return nil, fmt.Errorf("the ops runner needs spec.git.repository")
+	} else if kApiCr.Spec.OpsRunner == nil {
+		return nil, fmt.Errorf("the ops runner needs spec.opsRunner")
+	}
+	verifyKeys, err := cr.ParseVerifyKeysMode(kApiCr.Spec.OpsRunner.VerifyKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec.opsRunner.verifyKeys: %w", err)
+	}
+	return &Runner{Cr: kApiCr, KubeConfigPath: kubeConfigPath, GeneratePatchesOptions: &cr.GeneratePatchesOptions{VerifyKeys: verifyKeys}}, nil
+}
+
+func getSchedule(opsRunner *config.OpsRunner) string {
+	if opsRunner.Schedule == "" {
+		return defaultSchedule
+	}
+	return opsRunner.Schedule
+}
+
+func getWatchBranch(opsRunner *config.OpsRunner) string {
+	if opsRunner.WatchBranch == "" {
+		return defaultWatchBranch
+	}
+	return opsRunner.WatchBranch
+}
+
+// Run ticks on the schedule of spec.opsRunner until ctx is done, a failed tick is logged and retried on the next one
+func (r *Runner) Run(ctx context.Context) error {
+	schedule, err := ParseSchedule(getSchedule(r.Cr.Spec.OpsRunner))
+	if err != nil {
+		return err
+	}
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return fmt.Errorf("the schedule: %v never runs", getSchedule(r.Cr.Spec.OpsRunner))
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if !r.Cr.Spec.OpsRunner.IsEnabled() {
+			continue
+		}
+		if _, err := r.Tick(); err != nil {
+			log.Printf("ops runner tick failed: %v\n", err)
+		}
+	}
+}
+
+// Tick fetches the watch branch and generates the patches for its latest commit unless they were already generated for it
+func (r *Runner) Tick() (*TickResult, error) {
+	state, err := r.getState()
+	if err != nil {
+		return nil, err
+	}
+	watchBranch := getWatchBranch(r.Cr.Spec.OpsRunner)
+	auth, err := cr.GetGitAuth(r.Cr, r.KubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the git credentials: %w", err)
+	}
+
+	manifestsRoot := r.Cr.Spec.GetManifestsRoot()
+	var repo *git.Repository
+	if _, statErr := os.Stat(manifestsRoot); os.IsNotExist(statErr) {
+		repository := cr.GetGitRepository(r.Cr)
+		if repo, err = crGit.CloneRepositoryWithOptions(manifestsRoot, repository, auth, cr.GetGitCloneOptions(r.Cr, watchBranch)); err != nil {
+			return nil, fmt.Errorf("error cloning repository %v: %w", repository, err)
+		}
+	} else if repo, err = crGit.OpenRepository(manifestsRoot); err != nil {
+		return nil, fmt.Errorf("error opening repository %v: %w", manifestsRoot, err)
+	} else if err := crGit.RemoveRemoteCredentials(repo); err != nil {
+		return nil, fmt.Errorf("error removing the credentials of the remotes of %v: %w", manifestsRoot, err)
+	} else if err := crGit.DiscardAllUnstagedChanges(repo); err != nil {
+		return nil, fmt.Errorf("error discarding the unstaged changes of %v: %w", manifestsRoot, err)
+	}
+
+	hash, err := crGit.FetchRef(repo, "refs/heads/"+watchBranch, auth)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %v: %w", watchBranch, err)
+	}
+	result := &TickResult{Commit: hash.String()}
+	if lastApplied, err := state.GetLastAppliedCommit(); err != nil {
+		return nil, fmt.Errorf("error reading the last applied commit: %w", err)
+	} else if lastApplied != nil && lastApplied.Commit == result.Commit && lastApplied.Branch == watchBranch {
+		return result, nil
+	}
+
+	log.Printf("new commit: %v on %v, generating the patches\n", result.Commit, watchBranch)
+	generatePatches := r.GeneratePatches
+	if generatePatches == nil {
+		// the gitops flow checks out the watch branch and creates the branch of the patches from it
+		options := cr.GeneratePatchesOptions{}
+		if r.GeneratePatchesOptions != nil {
+			options = *r.GeneratePatchesOptions
+		}
+		options.BaseRef = watchBranch
+		generatePatches = func(kApiCr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string) (*cr.GitOpsResult, error) {
+			return cr.GeneratePatchesWithOptions(kApiCr, keysAction, kubeConfigPath, &options)
+		}
+	}
+	// the pipeline adds to the CR, every tick starts from a copy of the original one
+	kApiCr, err := copyCr(r.Cr)
+	if err != nil {
+		return nil, err
+	}
+	if result.GitOps, err = generatePatches(kApiCr, config.KeysActionRestoreOrRotate, r.KubeConfigPath); err != nil {
+		return nil, err
+	} else if result.GitOps != nil && result.GitOps.BaseCommit != "" {
+		result.Commit = result.GitOps.BaseCommit
+	}
+	if err := state.SetLastAppliedCommit(&AppliedCommit{Commit: result.Commit, Branch: watchBranch, AppliedAt: time.Now()}); err != nil {
+		return nil, fmt.Errorf("error recording the last applied commit: %w", err)
+	}
+	result.Applied = true
+	return result, nil
+}
+
+func (r *Runner) getState() (StateStore, error) {
+	if r.State != nil {
+		return r.State, nil
+	}
+	state, err := NewSecretStateStore(r.KubeConfigPath, r.Cr.GetObjectMeta().GetNamespace(), r.Cr.GetObjectMeta().GetName())
+	if err != nil {
+		return nil, err
+	}
+	r.State = state
+	return state, nil
+}
+
+// copyCr deep copies the CR, KApiCr.DeepCopy shares the configs and secrets maps
+func copyCr(kApiCr *config.KApiCr) (*config.KApiCr, error) {
+	data, err := json.Marshal(kApiCr)
+	if err != nil {
+		return nil, err
+	}
+	copied := &config.KApiCr{}
+	if err := json.Unmarshal(data, copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
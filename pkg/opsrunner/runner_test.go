@@ -0,0 +1,199 @@
+package opsrunner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/cr"
+)
+
+type memoryStateStore struct {
+	appliedCommit *AppliedCommit
+}
+
+func (s *memoryStateStore) GetLastAppliedCommit() (*AppliedCommit, error) {
+	return s.appliedCommit, nil
+}
+
+func (s *memoryStateStore) SetLastAppliedCommit(appliedCommit *AppliedCommit) error {
+	s.appliedCommit = appliedCommit
+	return nil
+}
+
+func commitFile(t *testing.T, r *git.Repository, dir, content string) string {
+	t.Helper()
+	workTree, _ := r.Worktree()
+	if err := ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte(content), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := workTree.Add("README.md"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hash, err := workTree.Commit(content, &git.CommitOptions{Author: &object.Signature{Name: "k-apis"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return hash.String()
+}
+
+func TestRunnerTick(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	originPath := filepath.Join(tmpDir, "origin")
+	origin, err := git.PlainInit(originPath, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	firstCommit := commitFile(t, origin, originPath, "1")
+
+	kApiCr := &config.KApiCr{Spec: &config.CRSpec{
+		ManifestsRoot: filepath.Join(tmpDir, "manifests"),
+		Git:           &config.Repo{Repository: originPath},
+		OpsRunner:     &config.OpsRunner{Enabled: "yes"},
+	}}
+	kApiCr.SetName("qliksense")
+	runner, err := NewRunner(kApiCr, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state := &memoryStateStore{}
+	runner.State = state
+	generated := 0
+	runner.GeneratePatches = func(kApiCr *config.KApiCr, keysAction config.KeysAction, kubeConfigPath string) (*cr.GitOpsResult, error) {
+		generated++
+		kApiCr.Spec.AddToConfigs("qliksense", "generated", "true")
+		return &cr.GitOpsResult{Branch: "pr-branch-test"}, nil
+	}
+
+	if result, err := runner.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !result.Applied || result.Commit != firstCommit || result.GitOps.Branch != "pr-branch-test" {
+		t.Fatalf("expected %v to be applied, got: %+v", firstCommit, result)
+	} else if state.appliedCommit.Commit != firstCommit || state.appliedCommit.Branch != "master" {
+		t.Fatalf("unexpected last applied commit: %+v", state.appliedCommit)
+	}
+
+	// nothing new on the watch branch
+	if result, err := runner.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if result.Applied {
+		t.Fatalf("expected %v not to be applied again", result.Commit)
+	}
+
+	secondCommit := commitFile(t, origin, originPath, "2")
+	if result, err := runner.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !result.Applied || result.Commit != secondCommit {
+		t.Fatalf("expected %v to be applied, got: %+v", secondCommit, result)
+	}
+
+	if generated != 2 {
+		t.Fatalf("expected the patches to be generated for %v and %v, got: %v", firstCommit, secondCommit, generated)
+	} else if len(kApiCr.Spec.Configs) != 0 {
+		t.Fatalf("expected the CR not to be changed by the pipeline, got: %v", kApiCr.Spec.Configs)
+	}
+}
+
+func TestRunnerTick_watchBranch(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	originPath := filepath.Join(tmpDir, "origin")
+	origin, err := git.PlainInit(originPath, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the .operator folder of the manifests the patches are generated into
+	workTree, _ := origin.Worktree()
+	for filePath, content := range map[string]string{
+		".operator/kustomization.yaml":                      "resources:\n- configs\n- transformers\n",
+		".operator/configs/kustomization.yaml":              "resources: []\n",
+		".operator/transformers/kustomization.yaml":         "resources: []\n",
+		".operator/transformers/release-name-template.yaml": "metadata:\n  name: release-template\nrelease: qliksense\n",
+		".operator/keys/kustomization.yaml":                 "resources: []\n",
+		".operator/keys/secrets/kustomization.yaml":         "resources: []\n",
+		".operator/keys/configs/keys/selectivepatch.yaml": "apiVersion: qlik.com/v1\nkind: SelectivePatch\nmetadata:\n  name: keys-component-configs\n" +
+			"patches:\n- target:\n    kind: SuperConfigMap\n  patch: |-\n    apiVersion: qlik.com/v1\n    kind: SuperConfigMap\n    metadata:\n      name: keys-configs\n",
+		".operator/secrets/kustomization.yaml": "resources: []\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(originPath, filePath)), os.ModePerm); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := ioutil.WriteFile(filepath.Join(originPath, filePath), []byte(content), os.ModePerm); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := workTree.Add(filePath); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	commitFile(t, origin, originPath, "1")
+	// release diverges from master
+	if err := workTree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("release"), Create: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	releaseCommit := commitFile(t, origin, originPath, "release")
+	if err := workTree.Checkout(&git.CheckoutOptions{Branch: plumbing.Master}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	commitFile(t, origin, originPath, "2")
+
+	kApiCr := &config.KApiCr{Spec: &config.CRSpec{
+		ManifestsRoot: filepath.Join(tmpDir, "manifests"),
+		Git:           &config.Repo{Repository: originPath},
+		OpsRunner:     &config.OpsRunner{Enabled: "yes", WatchBranch: "release"},
+		Configs:       map[string]config.NameValues{"qliksense": {{Name: "acceptEULA", Value: "yes"}}},
+		BackupStore:   &config.BackupStore{Type: "directory", Directory: filepath.Join(tmpDir, "backups")},
+	}}
+	kApiCr.SetName("qliksense")
+	kApiCr.SetNamespace("qlik")
+	defer os.Setenv("EJSON_KEYDIR", os.Getenv("EJSON_KEYDIR"))
+	os.Setenv("EJSON_KEYDIR", filepath.Join(tmpDir, "ejson-keys"))
+	runner, err := NewRunner(kApiCr, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state := &memoryStateStore{}
+	runner.State = state
+
+	// the real pipeline, the patches are committed on top of release, not of master
+	result, err := runner.Tick()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !result.Applied || result.Commit != releaseCommit || result.GitOps.BaseCommit != releaseCommit {
+		t.Fatalf("expected %v to be applied, got: %+v, %+v", releaseCommit, result, result.GitOps)
+	} else if state.appliedCommit.Commit != releaseCommit || state.appliedCommit.Branch != "release" {
+		t.Fatalf("unexpected last applied commit: %+v", state.appliedCommit)
+	}
+	pushed, err := origin.Reference(plumbing.NewBranchReferenceName(result.GitOps.Branch), true)
+	if err != nil {
+		t.Fatalf("expected %v to be pushed: %v", result.GitOps.Branch, err)
+	}
+	if commit, err := origin.CommitObject(pushed.Hash()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(commit.ParentHashes) != 1 || commit.ParentHashes[0].String() != releaseCommit {
+		t.Fatalf("expected the patches to be committed on top of %v, got the parents: %v", releaseCommit, commit.ParentHashes)
+	}
+}
+
+func TestNewRunner_invalid(t *testing.T) {
+	if _, err := NewRunner(&config.KApiCr{Spec: &config.CRSpec{OpsRunner: &config.OpsRunner{}}}, ""); err == nil {
+		t.Fatal("expected an error without spec.git")
+	}
+	if _, err := NewRunner(&config.KApiCr{Spec: &config.CRSpec{Git: &config.Repo{Repository: "https://github.com/qlik/manifests"}}}, ""); err == nil {
+		t.Fatal("expected an error without spec.opsRunner")
+	}
+	if _, err := NewRunner(&config.KApiCr{Spec: &config.CRSpec{
+		Git:       &config.Repo{Repository: "https://github.com/qlik/manifests"},
+		OpsRunner: &config.OpsRunner{VerifyKeys: "ignore"},
+	}}, ""); err == nil {
+		t.Fatal("expected an error for an invalid spec.opsRunner.verifyKeys")
+	}
+}
@@ -0,0 +1,137 @@
+package opsrunner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the schedules of CronJob in addition to the 5 fields ones
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedule is a cron schedule: minute, hour, day of month, month and day of week
+type Schedule struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+	// a day matches either field if both are restricted, like cron
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// ParseSchedule parses the schedules a CronJob accepts: 5 fields of *, values, ranges, steps and lists,
+// ex. */5 * * * * or 0 2 * * 1-5, or a descriptor like @hourly
+func ParseSchedule(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if descriptor, ok := scheduleDescriptors[spec]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule: %v, expected 5 fields", spec)
+	}
+	schedule := &Schedule{
+		anyDayOfMonth: fields[2] == "*" || fields[2] == "?",
+		anyDayOfWeek:  fields[4] == "*" || fields[4] == "?",
+	}
+	var err error
+	for _, field := range []struct {
+		values   *map[int]bool
+		text     string
+		min, max int
+	}{
+		{&schedule.minutes, fields[0], 0, 59},
+		{&schedule.hours, fields[1], 0, 23},
+		{&schedule.daysOfMonth, fields[2], 1, 31},
+		{&schedule.months, fields[3], 1, 12},
+		{&schedule.daysOfWeek, fields[4], 0, 7},
+	} {
+		if *field.values, err = parseScheduleField(field.text, field.min, field.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule: %v, %w", spec, err)
+		}
+	}
+	// 7 is sunday too
+	if schedule.daysOfWeek[7] {
+		schedule.daysOfWeek[0] = true
+	}
+	return schedule, nil
+}
+
+func parseScheduleField(text string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(text, ",") {
+		rangeText, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step: %v", part)
+			}
+			rangeText = part[:i]
+		}
+
+		start, end := min, max
+		if rangeText != "*" && rangeText != "?" {
+			bounds := strings.SplitN(rangeText, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value: %v", part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range: %v", part)
+				}
+			} else if step > 1 {
+				// 5/10 is 5-max/10
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%v is out of range %v-%v", part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayOfMonth, dayOfWeek := s.daysOfMonth[t.Day()], s.daysOfWeek[int(t.Weekday())]
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first time after t matching the schedule, in the location of t
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every schedule matches within 5 years, ex. 0 0 29 2 * in a leap year
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		} else if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		} else if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		} else if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
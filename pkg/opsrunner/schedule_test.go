@@ -0,0 +1,46 @@
+package opsrunner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a wednesday
+	from := time.Date(2021, 3, 3, 10, 7, 30, 0, time.UTC)
+	testCases := []struct {
+		schedule string
+		next     time.Time
+	}{
+		{"*/5 * * * *", time.Date(2021, 3, 3, 10, 10, 0, 0, time.UTC)},
+		{"* * * * *", time.Date(2021, 3, 3, 10, 8, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2021, 3, 4, 2, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2021, 3, 4, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"15,45 10 * * *", time.Date(2021, 3, 3, 10, 15, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either day matches if both are restricted
+		{"0 0 15 * 5", time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 3, 3, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, testCase := range testCases {
+		schedule, err := ParseSchedule(testCase.schedule)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", testCase.schedule, err)
+		}
+		if next := schedule.Next(from); !next.Equal(testCase.next) {
+			t.Fatalf("expected the next time of %v to be %v, got: %v", testCase.schedule, testCase.next, next)
+		}
+	}
+}
+
+func TestParseSchedule_invalid(t *testing.T) {
+	for _, invalid := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every 5m"} {
+		if _, err := ParseSchedule(invalid); err == nil {
+			t.Fatalf("expected an error for: %v", invalid)
+		}
+	}
+}
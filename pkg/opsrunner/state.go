@@ -0,0 +1,88 @@
+package opsrunner
+
+import (
+	"context"
+	"time"
+
+	"github.com/qlik-oss/k-apis/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	stateSecretSuffix       = "-ops-runner-state"
+	lastAppliedCommitKey    = "lastAppliedCommit"
+	lastAppliedBranchKey    = "watchBranch"
+	lastAppliedTimestampKey = "appliedAt"
+)
+
+// AppliedCommit is the last commit of the watch branch the patches were generated for
+type AppliedCommit struct {
+	Commit    string
+	Branch    string
+	AppliedAt time.Time
+}
+
+// StateStore records the last applied commit
+type StateStore interface {
+	// GetLastAppliedCommit returns nil if no commit was applied yet
+	GetLastAppliedCommit() (*AppliedCommit, error)
+	SetLastAppliedCommit(appliedCommit *AppliedCommit) error
+}
+
+// SecretStateStore keeps the last applied commit in the <CR name>-ops-runner-state secret of the CR namespace
+type SecretStateStore struct {
+	secretsClient clientV1.SecretInterface
+	name          string
+	crName        string
+}
+
+func NewSecretStateStore(kubeConfigPath, namespace, crName string) (*SecretStateStore, error) {
+	secretsClient, err := utils.GetSecretsClient(kubeConfigPath, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretStateStore{secretsClient: secretsClient, name: crName + stateSecretSuffix, crName: crName}, nil
+}
+
+func (s *SecretStateStore) GetLastAppliedCommit() (*AppliedCommit, error) {
+	secret, err := s.secretsClient.Get(context.TODO(), s.name, metaV1.GetOptions{})
+	if kubeApiErrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	appliedCommit := &AppliedCommit{
+		Commit: string(secret.Data[lastAppliedCommitKey]),
+		Branch: string(secret.Data[lastAppliedBranchKey]),
+	}
+	if appliedCommit.Commit == "" {
+		return nil, nil
+	}
+	appliedCommit.AppliedAt, _ = time.Parse(time.RFC3339, string(secret.Data[lastAppliedTimestampKey]))
+	return appliedCommit, nil
+}
+
+func (s *SecretStateStore) SetLastAppliedCommit(appliedCommit *AppliedCommit) error {
+	data := map[string][]byte{
+		lastAppliedCommitKey:    []byte(appliedCommit.Commit),
+		lastAppliedBranchKey:    []byte(appliedCommit.Branch),
+		lastAppliedTimestampKey: []byte(appliedCommit.AppliedAt.UTC().Format(time.RFC3339)),
+	}
+	secret, err := s.secretsClient.Get(context.TODO(), s.name, metaV1.GetOptions{})
+	if kubeApiErrors.IsNotFound(err) {
+		_, err = s.secretsClient.Create(context.TODO(), &v1.Secret{
+			ObjectMeta: metaV1.ObjectMeta{Name: s.name, Labels: map[string]string{"release": s.crName}},
+			Type:       v1.SecretTypeOpaque,
+			Data:       data,
+		}, metaV1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	secret.Data = data
+	_, err = s.secretsClient.Update(context.TODO(), secret, metaV1.UpdateOptions{})
+	return err
+}
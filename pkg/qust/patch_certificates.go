@@ -0,0 +1,221 @@
+package qust
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/keys"
+	"gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+const (
+	operatorCertificatesBaseFolder = "certificates"
+	caCertificateFolder            = "ca"
+	caBundleConfigMapName          = "internal-ca-bundle"
+	defaultReleaseName             = "qliksense"
+	defaultCertificateNamespace    = "default"
+	serviceCertificateValidity     = time.Hour * 24 * 365 * 2
+)
+
+const certificatesKustomizationFileYaml = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+`
+
+const serviceCertificateKustomizationFileYaml = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - selectivepatch.yaml
+transformers:
+  - ../gomplate.yaml
+`
+
+const caCertificateKustomizationFileYaml = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - configmap.yaml
+`
+
+const patchedCertificatesGomplateFileYaml = `apiVersion: qlik.com/v1
+kind: Gomplate
+metadata:
+  name: patched-certificates-gomplate
+  labels:
+    key: gomplate
+dataSource:
+  ejson:
+    filePath: edata.json
+`
+
+type caBundleConfigMapT struct {
+	ApiVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   map[string]string `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+}
+
+// GenerateCertificates writes the internal CA and a CA signed certificate for every service in cr.Spec.InternalCA.Services
+//
+// manifestsRoot
+// |--.operator
+// |  |--keys
+// |  |  |--certificates
+// |  |  |  |--kustomization.yaml
+// |  |  |  |--gomplate.yaml
+// |  |  |  |--ca
+// |  |  |  |  |--kustomization.yaml
+// |  |  |  |  |--configmap.yaml  (the CA bundle clients should trust)
+// |  |  |  |  |--eca.json        (ejson encrypted CA certificate and key)
+// |  |  |  |--<service>
+// |  |  |  |  |--kustomization.yaml
+// |  |  |  |  |--selectivepatch.yaml
+// |  |  |  |  |--edata.json      (ejson encrypted tls.crt, tls.key and ca.crt)
+//
+// Everything lives under .operator/keys so it is backed up and restored together with the other application keys.
+func GenerateCertificates(cr *config.KApiCr, ejsonPublicKey string, ca *keys.CertificateAuthority) error {
+	if cr.Spec.InternalCA == nil {
+		return nil
+	}
+	baseCertificatesDir := getCertificatesDir(cr.Spec)
+	if err := os.MkdirAll(filepath.Join(baseCertificatesDir, caCertificateFolder), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "error creating directory: %v", baseCertificatesDir)
+	} else if err := writeCAFiles(filepath.Join(baseCertificatesDir, caCertificateFolder), ca, ejsonPublicKey); err != nil {
+		return errors.Wrap(err, "error writing out the internal CA")
+	}
+
+	services := append([]string{}, cr.Spec.InternalCA.Services...)
+	sort.Strings(services)
+	for _, svc := range services {
+		dir := filepath.Join(baseCertificatesDir, svc)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "error creating directory: %v", dir)
+		} else if err := writeServiceCertificateFiles(dir, svc, GetServiceDNSNames(cr, svc), ca, ejsonPublicKey); err != nil {
+			return errors.Wrapf(err, "error writing out the certificate for service: %v", svc)
+		}
+	}
+
+	return writeCertificatesKustomization(cr.Spec)
+}
+
+func writeCertificatesKustomization(cr *config.CRSpec) error {
+	baseCertificatesDir := getCertificatesDir(cr)
+	services := append([]string{}, cr.InternalCA.Services...)
+	sort.Strings(services)
+	if err := ioutil.WriteFile(filepath.Join(baseCertificatesDir, "gomplate.yaml"), []byte(patchedCertificatesGomplateFileYaml), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "error writing out the certificates' gomplate.yaml file: %v", filepath.Join(baseCertificatesDir, "gomplate.yaml"))
+	}
+	kustFile := filepath.Join(baseCertificatesDir, "kustomization.yaml")
+	if err := ioutil.WriteFile(kustFile, []byte(certificatesKustomizationFileYaml), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "error writing out the certificates kustomization.yaml file: %v", kustFile)
+	}
+	for _, resource := range append([]string{caCertificateFolder}, services...) {
+		if err := addResourceToKustomization(resource, kustFile); err != nil {
+			return errors.Wrapf(err, "error adding resource: %v to kustomization file: %v", resource, kustFile)
+		}
+	}
+	return addCertificatesToOperatorKustomization(cr)
+}
+
+// CertificatesExist returns true if the internal CA has already been written to the manifests root
+func CertificatesExist(cr *config.CRSpec) bool {
+	_, err := os.Stat(filepath.Join(getCertificatesDir(cr), caCertificateFolder, "eca.json"))
+	return err == nil
+}
+
+func getCertificatesDir(cr *config.CRSpec) string {
+	return filepath.Join(cr.GetManifestsRoot(), operatorPatchBaseFolder, operatorKeysBaseFolder, operatorCertificatesBaseFolder)
+}
+
+// GetServiceDNSNames returns the DNS names of a service, they are prefixed with the release name and live in the CR namespace
+func GetServiceDNSNames(cr *config.KApiCr, svc string) []string {
+	releaseName := cr.GetObjectMeta().GetName()
+	if releaseName == "" {
+		releaseName = defaultReleaseName
+	}
+	namespace := cr.GetObjectMeta().GetNamespace()
+	if namespace == "" {
+		namespace = defaultCertificateNamespace
+	}
+	return keys.GetServiceDNSNames(fmt.Sprintf("%v-%v", releaseName, svc), namespace)
+}
+
+func writeCAFiles(dir string, ca *keys.CertificateAuthority, ejsonPublicKey string) error {
+	eCAMap := map[string]string{
+		"_public_key": ejsonPublicKey,
+		"ca_cert":     base64.StdEncoding.EncodeToString(ca.Certificate),
+		"ca_key":      base64.StdEncoding.EncodeToString(ca.Key),
+	}
+	caBundle := &caBundleConfigMapT{
+		ApiVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata: map[string]string{
+			"name": caBundleConfigMapName,
+		},
+		Data: map[string]string{
+			"ca.crt": string(ca.Certificate),
+		},
+	}
+	if err := writeToEjsonFile(eCAMap, filepath.Join(dir, "eca.json")); err != nil {
+		return err
+	} else if caBundleBytes, err := yaml.Marshal(caBundle); err != nil {
+		return err
+	} else if err := ioutil.WriteFile(filepath.Join(dir, "configmap.yaml"), caBundleBytes, os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte(caCertificateKustomizationFileYaml), os.ModePerm)
+}
+
+func writeServiceCertificateFiles(dir, svc string, dnsNames []string, ca *keys.CertificateAuthority, ejsonPublicKey string) error {
+	certPem, keyPem, err := ca.GetSignedCertAndKey(dnsNames[0], "", dnsNames, serviceCertificateValidity)
+	if err != nil {
+		return err
+	}
+	eDataMap := map[string]string{
+		"_public_key": ejsonPublicKey,
+		"tls.crt":     base64.StdEncoding.EncodeToString(certPem),
+		"tls.key":     base64.StdEncoding.EncodeToString(keyPem),
+		"ca.crt":      base64.StdEncoding.EncodeToString(ca.Certificate),
+	}
+	if err := writeToEjsonFile(eDataMap, filepath.Join(dir, "edata.json")); err != nil {
+		return err
+	} else if err := ioutil.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte(serviceCertificateKustomizationFileYaml), os.ModePerm); err != nil {
+		return err
+	}
+	return writeSelectivePatchFile(dir, getCertificateSelectivePatch(svc))
+}
+
+func getCertificateSelectivePatch(svc string) *config.SelectivePatch {
+	sp := getSelectivePatchTemplate(svc + "-generated-operator-certificates")
+	ph := getSuperSecretTemplate(svc)
+	ph.Data = map[string]string{}
+	for _, dataKey := range []string{"tls.crt", "tls.key", "ca.crt"} {
+		ph.Data[dataKey] = fmt.Sprintf(`(( index (ds "data") "%s" ))`, dataKey)
+	}
+	phb, _ := yaml.Marshal(ph)
+	sp.Patches = []types.Patch{
+		{
+			Patch:  strings.Replace(string(phb), ": |", ": |-", -1),
+			Target: getSelector("SuperSecret", svc),
+		},
+	}
+	return sp
+}
+
+// add certificates to .operator/keys/kustomization.yaml, or to .operator/kustomization.yaml if keys has no kustomization
+func addCertificatesToOperatorKustomization(cr *config.CRSpec) error {
+	keysKustFile := filepath.Join(cr.GetManifestsRoot(), operatorPatchBaseFolder, operatorKeysBaseFolder, "kustomization.yaml")
+	if _, err := os.Stat(keysKustFile); err == nil {
+		return addResourceToKustomization(operatorCertificatesBaseFolder, keysKustFile)
+	}
+	operatorKustFile := filepath.Join(cr.GetManifestsRoot(), operatorPatchBaseFolder, "kustomization.yaml")
+	return addResourceToKustomization(filepath.Join(operatorKeysBaseFolder, operatorCertificatesBaseFolder), operatorKustFile)
+}
@@ -0,0 +1,300 @@
+package qust
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/ejson"
+	"github.com/pkg/errors"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/keys"
+)
+
+const internalCAValidity = time.Hour * 24 * 365 * 10
+
+// ejson data keys holding base64 encoded PEM certificates
+var certificateDataKeys = []string{"tls_cert", "tls.crt", "ca_cert"}
+
+// ScannedCertificate is a certificate found in the ejson encrypted key material
+type ScannedCertificate struct {
+	// relative to the manifests root
+	File string
+	Key  string
+	*keys.CertificateInfo
+}
+
+// ScanCertificates decrypts every ejson file under .operator/keys and reports the certificates it holds,
+// other json files are skipped
+func ScanCertificates(cr *config.CRSpec, ejsonPrivateKey string) ([]*ScannedCertificate, error) {
+	keysDir := filepath.Join(cr.GetManifestsRoot(), operatorPatchBaseFolder, operatorKeysBaseFolder)
+	ejsonFiles, err := findEjsonFiles(keysDir)
+	if err != nil {
+		return nil, err
+	}
+	var filePaths []string
+	for filePath := range ejsonFiles {
+		filePaths = append(filePaths, filePath)
+	}
+	sort.Strings(filePaths)
+
+	var scannedCertificates []*ScannedCertificate
+	for _, filePath := range filePaths {
+		ejsonDataMap, err := readEjsonFile(filePath, ejsonPrivateKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decrypting ejson file: %v", filePath)
+		}
+		relativePath, err := filepath.Rel(cr.GetManifestsRoot(), filePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, dataKey := range certificateDataKeys {
+			if value, ok := ejsonDataMap[dataKey]; !ok {
+				continue
+			} else if certificateInfo, err := getBase64CertificateInfo(value); err != nil {
+				return nil, errors.Wrapf(err, "error parsing certificate: %v in ejson file: %v", dataKey, filePath)
+			} else {
+				scannedCertificates = append(scannedCertificates, &ScannedCertificate{
+					File:            relativePath,
+					Key:             dataKey,
+					CertificateInfo: certificateInfo,
+				})
+			}
+		}
+	}
+	return scannedCertificates, nil
+}
+
+// RenewCertificates regenerates the certificates expiring within renewBefore and returns what was renewed
+// the application signing keys are left untouched. When the internal CA itself is renewed, or replaced by the one
+// in options, every certificate it signed is re-issued as well.
+func RenewCertificates(cr *config.KApiCr, ejsonPublicKey, ejsonPrivateKey string, renewBefore time.Duration, options *KeysOptions) (renewed []string, err error) {
+	ca := options.CA
+	caRenewed := false
+	if cr.Spec.InternalCA != nil {
+		if ca, caRenewed, err = renewInternalCA(cr, ejsonPublicKey, ejsonPrivateKey, renewBefore, options.CA); err != nil {
+			return nil, err
+		} else if caRenewed {
+			renewed = append(renewed, caCertificateFolder)
+		}
+
+		services := append([]string{}, cr.Spec.InternalCA.Services...)
+		sort.Strings(services)
+		for _, svc := range services {
+			dir := filepath.Join(getCertificatesDir(cr.Spec), svc)
+			if renew, err := isCertificateDueForRenewal(filepath.Join(dir, "edata.json"), "tls.crt", ejsonPrivateKey, renewBefore); err != nil {
+				return nil, err
+			} else if !renew && !caRenewed {
+				continue
+			} else if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return nil, errors.Wrapf(err, "error creating directory: %v", dir)
+			} else if err := writeServiceCertificateFiles(dir, svc, GetServiceDNSNames(cr, svc), ca, ejsonPublicKey); err != nil {
+				return nil, errors.Wrapf(err, "error renewing the certificate for service: %v", svc)
+			}
+			renewed = append(renewed, svc)
+		}
+		if caRenewed || len(renewed) > 0 {
+			if err := writeCertificatesKustomization(cr.Spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	elasticInfraFile := filepath.Join(cr.Spec.GetManifestsRoot(), operatorPatchBaseFolder, operatorKeysBaseFolder, "secrets", "elastic-infra", "eprivate_key.json")
+	if _, err := os.Stat(elasticInfraFile); err == nil {
+		ePriviteKeyMap, err := readEjsonFile(elasticInfraFile, ejsonPrivateKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decrypting ejson file: %v", elasticInfraFile)
+		}
+		renew := caRenewed || (len(options.TlsCertificate) > 0 && ePriviteKeyMap["tls_cert"] != base64.StdEncoding.EncodeToString(options.TlsCertificate))
+		if !renew {
+			if renew, err = isBase64CertificateDueForRenewal(ePriviteKeyMap["tls_cert"], renewBefore); err != nil {
+				return nil, errors.Wrapf(err, "error parsing certificate in ejson file: %v", elasticInfraFile)
+			}
+		}
+		if !renew && ca != nil && len(options.TlsCertificate) == 0 {
+			if renew, err = isNotIssuedBy(ca, ePriviteKeyMap["tls_cert"]); err != nil {
+				return nil, errors.Wrapf(err, "error parsing certificate in ejson file: %v", elasticInfraFile)
+			}
+		}
+		if renew {
+			if err := writeElasticInfraCertificate(cr, elasticInfraFile, ePriviteKeyMap, &KeysOptions{CA: ca, TlsCertificate: options.TlsCertificate, TlsKey: options.TlsKey}); err != nil {
+				return nil, err
+			}
+			renewed = append(renewed, "elastic-infra")
+		}
+	}
+	return renewed, nil
+}
+
+// ReissueElasticInfraCertificate re-issues the elastic-infra certificate if it is not signed by the internal CA, ex. it was
+// restored from a backup taken before the internal CA was enabled or replaced. options.CA is the imported CA, the CA
+// written by GenerateCertificates is used otherwise. A user supplied certificate is left alone. Returns true if re-issued.
+func ReissueElasticInfraCertificate(cr *config.KApiCr, ejsonPrivateKey string, options *KeysOptions) (bool, error) {
+	elasticInfraFile := filepath.Join(cr.Spec.GetManifestsRoot(), operatorPatchBaseFolder, operatorKeysBaseFolder, "secrets", "elastic-infra", "eprivate_key.json")
+	if cr.Spec.InternalCA == nil || len(options.TlsCertificate) > 0 {
+		return false, nil
+	} else if _, err := os.Stat(elasticInfraFile); os.IsNotExist(err) {
+		return false, nil
+	}
+	ca := options.CA
+	if ca == nil {
+		if !CertificatesExist(cr.Spec) {
+			return false, nil
+		}
+		var err error
+		if ca, err = readInternalCA(filepath.Join(getCertificatesDir(cr.Spec), caCertificateFolder, "eca.json"), ejsonPrivateKey); err != nil {
+			return false, err
+		}
+	}
+
+	ePriviteKeyMap, err := readEjsonFile(elasticInfraFile, ejsonPrivateKey)
+	if err != nil {
+		return false, errors.Wrapf(err, "error decrypting ejson file: %v", elasticInfraFile)
+	}
+	if reissue, err := isNotIssuedBy(ca, ePriviteKeyMap["tls_cert"]); err != nil {
+		return false, errors.Wrapf(err, "error parsing certificate in ejson file: %v", elasticInfraFile)
+	} else if !reissue {
+		return false, nil
+	}
+	if err := writeElasticInfraCertificate(cr, elasticInfraFile, ePriviteKeyMap, &KeysOptions{CA: ca}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeElasticInfraCertificate issues the elastic-infra certificate for its service DNS names and rewrites its ejson file
+func writeElasticInfraCertificate(cr *config.KApiCr, elasticInfraFile string, ePriviteKeyMap map[string]string, options *KeysOptions) error {
+	options.ServiceDNSNames = GetServiceDNSNames(cr, "elastic-infra")
+	if certPem, keyPem, err := getElasticInfraCertAndKey(cr.Spec, options); err != nil {
+		return err
+	} else {
+		ePriviteKeyMap["tls_cert"] = base64.StdEncoding.EncodeToString(certPem)
+		ePriviteKeyMap["tls_key"] = base64.StdEncoding.EncodeToString(keyPem)
+	}
+	return writeToEjsonFile(ePriviteKeyMap, elasticInfraFile)
+}
+
+// isNotIssuedBy returns true if the base64 encoded PEM certificate is missing or not signed by ca
+func isNotIssuedBy(ca *keys.CertificateAuthority, value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	certificate, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return false, err
+	}
+	isIssuer, err := ca.IsIssuerOf(certificate)
+	return !isIssuer, err
+}
+
+// renewInternalCA loads the CA written by GenerateCertificates, replaces it with importedCA if that one differs,
+// or regenerates it if it is about to expire
+func renewInternalCA(cr *config.KApiCr, ejsonPublicKey, ejsonPrivateKey string, renewBefore time.Duration, importedCA *keys.CertificateAuthority) (ca *keys.CertificateAuthority, renewed bool, err error) {
+	caDir := filepath.Join(getCertificatesDir(cr.Spec), caCertificateFolder)
+	var existingCA *keys.CertificateAuthority
+	if CertificatesExist(cr.Spec) {
+		if existingCA, err = readInternalCA(filepath.Join(caDir, "eca.json"), ejsonPrivateKey); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if importedCA != nil {
+		if existingCA != nil && bytes.Equal(existingCA.Certificate, importedCA.Certificate) {
+			return importedCA, false, nil
+		}
+		ca = importedCA
+	} else if existingCA == nil {
+		if ca, err = keys.GenerateCertificateAuthority(cr.GetName()+"-internal-ca", cr.Spec.GetTls().Organization, internalCAValidity); err != nil {
+			return nil, false, err
+		}
+	} else if caInfo, err := keys.GetCertificateInfo(existingCA.Certificate); err != nil {
+		return nil, false, err
+	} else if !caInfo.ExpiresWithin(renewBefore) {
+		return existingCA, false, nil
+	} else if ca, err = keys.GenerateCertificateAuthority(caInfo.CommonName, cr.Spec.GetTls().Organization, internalCAValidity); err != nil {
+		return nil, false, err
+	}
+
+	if err := os.MkdirAll(caDir, os.ModePerm); err != nil {
+		return nil, false, errors.Wrapf(err, "error creating directory: %v", caDir)
+	} else if err := writeCAFiles(caDir, ca, ejsonPublicKey); err != nil {
+		return nil, false, errors.Wrap(err, "error writing out the internal CA")
+	}
+	return ca, true, nil
+}
+
+func readInternalCA(filePath, ejsonPrivateKey string) (*keys.CertificateAuthority, error) {
+	eCAMap, err := readEjsonFile(filePath, ejsonPrivateKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decrypting ejson file: %v", filePath)
+	}
+	certificate, err := base64.StdEncoding.DecodeString(eCAMap["ca_cert"])
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(eCAMap["ca_key"])
+	if err != nil {
+		return nil, err
+	}
+	return keys.LoadCertificateAuthority(certificate, key)
+}
+
+// a missing file or certificate is due for renewal
+func isCertificateDueForRenewal(filePath, dataKey, ejsonPrivateKey string, renewBefore time.Duration) (bool, error) {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return true, nil
+	}
+	ejsonDataMap, err := readEjsonFile(filePath, ejsonPrivateKey)
+	if err != nil {
+		return false, errors.Wrapf(err, "error decrypting ejson file: %v", filePath)
+	}
+	renew, err := isBase64CertificateDueForRenewal(ejsonDataMap[dataKey], renewBefore)
+	if err != nil {
+		return false, errors.Wrapf(err, "error parsing certificate in ejson file: %v", filePath)
+	}
+	return renew, nil
+}
+
+func isBase64CertificateDueForRenewal(value string, renewBefore time.Duration) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	certificateInfo, err := getBase64CertificateInfo(value)
+	if err != nil {
+		return false, err
+	}
+	return certificateInfo.ExpiresWithin(renewBefore), nil
+}
+
+func getBase64CertificateInfo(value string) (*keys.CertificateInfo, error) {
+	certificate, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return keys.GetCertificateInfo(certificate)
+}
+
+// readEjsonFile decrypts an ejson file, values that are not strings are ignored
+func readEjsonFile(filePath, ejsonPrivateKey string) (map[string]string, error) {
+	decrypted, err := ejson.DecryptFile(filePath, "", strings.TrimSpace(ejsonPrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	var ejsonData map[string]interface{}
+	if err := json.Unmarshal(decrypted, &ejsonData); err != nil {
+		return nil, err
+	}
+	ejsonDataMap := make(map[string]string)
+	for key, value := range ejsonData {
+		if stringValue, ok := value.(string); ok {
+			ejsonDataMap[key] = stringValue
+		}
+	}
+	return ejsonDataMap, nil
+}
@@ -0,0 +1,193 @@
+package qust
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/ejson"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/keys"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRenewCertificates(t *testing.T) {
+	td, dir := createManifestsStructure(t)
+	defer td()
+
+	elasticInfraDir := filepath.Join(dir, ".operator", "keys", "secrets", "elastic-infra")
+	if err := os.MkdirAll(elasticInfraDir, os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, ".operator", "keys", "kustomization.yaml"), []byte("resources:\n- secrets\n"), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, ".operator", "keys", "secrets", "settings.json"), []byte(`{"tls_cert": "not ejson"}`), os.ModePerm); err != nil {
+		// not an ejson file, skipped by the scan
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cr := &config.KApiCr{}
+	if err := yaml.Unmarshal([]byte(fmt.Sprintf(`
+apiVersion: qlik.com/v1
+kind: Qliksense
+metadata:
+  name: test-cr
+  namespace: test-ns
+spec:
+  manifestsRoot: %s
+  internalCA:
+    services:
+    - qix-sessions
+`, dir)), cr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ejsonPublicKey, ejsonPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ca, err := keys.GenerateCertificateAuthority("test-ca", "", time.Hour*24*365)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	elasticInfraFile := filepath.Join(elasticInfraDir, "eprivate_key.json")
+	if certPem, keyPem, err := ca.GetSignedCertAndKey("elastic-infra", "", nil, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := writeToEjsonFile(map[string]string{
+		"_public_key": ejsonPublicKey,
+		"tls_cert":    base64.StdEncoding.EncodeToString(certPem),
+		"tls_key":     base64.StdEncoding.EncodeToString(keyPem),
+		"foo":         "bar",
+	}, elasticInfraFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := GenerateCertificates(cr, ejsonPublicKey, ca); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	scanned, err := ScanCertificates(cr.Spec, ejsonPrivateKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(scanned) != 3 {
+		t.Fatalf("expected 3 certificates (CA, service, elastic-infra), got: %v", len(scanned))
+	}
+	for _, certificate := range scanned {
+		if certificate.IsCA != (certificate.Key == "ca_cert") {
+			t.Fatalf("unexpected IsCA: %v for %v %v", certificate.IsCA, certificate.File, certificate.Key)
+		}
+	}
+
+	// nothing expires within a minute
+	if renewed, err := RenewCertificates(cr, ejsonPublicKey, ejsonPrivateKey, time.Minute, &KeysOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(renewed) != 0 {
+		t.Fatalf("expected nothing to be renewed, got: %v", renewed)
+	}
+
+	// only the elastic-infra certificate expires within a day
+	if renewed, err := RenewCertificates(cr, ejsonPublicKey, ejsonPrivateKey, time.Hour*24, &KeysOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(renewed) != 1 || renewed[0] != "elastic-infra" {
+		t.Fatalf("expected only elastic-infra to be renewed, got: %v", renewed)
+	}
+	if ePriviteKeyMap, err := readEjsonFile(elasticInfraFile, ejsonPrivateKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ePriviteKeyMap["foo"] != "bar" {
+		t.Fatal("expected the other elastic-infra keys to be kept")
+	}
+
+	// the CA expires within two years, so it is renewed together with everything it signed
+	if renewed, err := RenewCertificates(cr, ejsonPublicKey, ejsonPrivateKey, time.Hour*24*365*2, &KeysOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(renewed) != 3 {
+		t.Fatalf("expected the CA, qix-sessions and elastic-infra to be renewed, got: %v", renewed)
+	}
+	if scanned, err = ScanCertificates(cr.Spec, ejsonPrivateKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, certificate := range scanned {
+		if certificate.ExpiresWithin(time.Hour * 24 * 365) {
+			t.Fatalf("expected %v %v to be renewed, it expires: %v", certificate.File, certificate.Key, certificate.NotAfter)
+		}
+	}
+}
+
+func TestReissueElasticInfraCertificate(t *testing.T) {
+	td, dir := createManifestsStructure(t)
+	defer td()
+
+	elasticInfraDir := filepath.Join(dir, ".operator", "keys", "secrets", "elastic-infra")
+	if err := os.MkdirAll(elasticInfraDir, os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, ".operator", "keys", "kustomization.yaml"), []byte("resources:\n- secrets\n"), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cr := &config.KApiCr{Spec: &config.CRSpec{ManifestsRoot: dir, InternalCA: &config.InternalCA{}}}
+	cr.SetName("test-cr")
+	cr.SetNamespace("test-ns")
+
+	ejsonPublicKey, ejsonPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ca, err := keys.GenerateCertificateAuthority("test-ca", "", time.Hour*24*365)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := GenerateCertificates(cr, ejsonPublicKey, ca); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// restored from a backup taken before the internal CA was enabled
+	elasticInfraFile := filepath.Join(elasticInfraDir, "eprivate_key.json")
+	if certPem, keyPem, err := keys.GetSelfSignedCertAndKey("elastic-infra", "", time.Hour*24*365); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := writeToEjsonFile(map[string]string{
+		"_public_key": ejsonPublicKey,
+		"tls_cert":    base64.StdEncoding.EncodeToString(certPem),
+		"tls_key":     base64.StdEncoding.EncodeToString(keyPem),
+	}, elasticInfraFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the CA written by GenerateCertificates is used without an imported one
+	if reissued, err := ReissueElasticInfraCertificate(cr, ejsonPrivateKey, &KeysOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reissued {
+		t.Fatal("expected the self-signed certificate to be re-issued")
+	}
+	ePriviteKeyMap, err := readEjsonFile(elasticInfraFile, ejsonPrivateKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certPem, _ := base64.StdEncoding.DecodeString(ePriviteKeyMap["tls_cert"])
+	if isIssuer, err := ca.IsIssuerOf(certPem); err != nil || !isIssuer {
+		t.Fatalf("expected the certificate to be signed by the internal CA, got: %v, error: %v", isIssuer, err)
+	}
+	certificateInfo, err := keys.GetCertificateInfo(certPem)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedDNSNames := keys.GetServiceDNSNames("test-cr-elastic-infra", "test-ns")
+	for _, dnsName := range expectedDNSNames {
+		if !contains(certificateInfo.DNSNames, dnsName) {
+			t.Fatalf("expected the DNS names: %v, got: %v", expectedDNSNames, certificateInfo.DNSNames)
+		}
+	}
+
+	if reissued, err := ReissueElasticInfraCertificate(cr, ejsonPrivateKey, &KeysOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if reissued {
+		t.Fatal("expected the certificate signed by the internal CA to be kept")
+	}
+	// and a replaced CA re-issues it again
+	otherCA, err := keys.GenerateCertificateAuthority("other-ca", "", time.Hour*24*365)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if reissued, err := ReissueElasticInfraCertificate(cr, ejsonPrivateKey, &KeysOptions{CA: otherCA}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reissued {
+		t.Fatal("expected the certificate to be re-issued by the replaced CA")
+	}
+}
@@ -0,0 +1,115 @@
+package qust
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/ejson"
+	"github.com/qlik-oss/k-apis/pkg/config"
+	"github.com/qlik-oss/k-apis/pkg/keys"
+	"gopkg.in/yaml.v2"
+)
+
+func TestGenerateCertificates(t *testing.T) {
+	td, dir := createManifestsStructure(t)
+	defer td()
+
+	keysDir := filepath.Join(dir, ".operator", "keys")
+	if err := os.MkdirAll(keysDir, os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := ioutil.WriteFile(filepath.Join(keysDir, "kustomization.yaml"), []byte("resources:\n- configs\n- secrets\n"), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cr := &config.KApiCr{}
+	if err := yaml.Unmarshal([]byte(fmt.Sprintf(`
+apiVersion: qlik.com/v1
+kind: Qliksense
+metadata:
+  name: test-cr
+  namespace: test-ns
+spec:
+  manifestsRoot: %s
+  internalCA:
+    services:
+    - elastic-infra
+`, dir)), cr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ejsonPublicKey, ejsonPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ca, err := keys.GenerateCertificateAuthority("test-ca", "", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if CertificatesExist(cr.Spec) {
+		t.Fatal("expected certificates not to exist yet")
+	} else if err := GenerateCertificates(cr, ejsonPublicKey, ca); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !CertificatesExist(cr.Spec) {
+		t.Fatal("expected certificates to exist")
+	}
+
+	certificatesDir := filepath.Join(keysDir, "certificates")
+	if resources, err := getResourcesList(filepath.Join(certificatesDir, "kustomization.yaml")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !contains(resources, "ca") || !contains(resources, "elastic-infra") {
+		t.Fatalf("unexpected certificates resources: %v", resources)
+	} else if resources, err := getResourcesList(filepath.Join(keysDir, "kustomization.yaml")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !contains(resources, "certificates") {
+		t.Fatalf("expected certificates in the keys resources: %v", resources)
+	}
+
+	decrypted, err := ejson.DecryptFile(filepath.Join(certificatesDir, "elastic-infra", "edata.json"), "", ejsonPrivateKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eData := make(map[string]string)
+	if err := json.Unmarshal(decrypted, &eData); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certPem, err := base64.StdEncoding.DecodeString(eData["tls.crt"])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		t.Fatal("expected a pem encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	caBlock, _ := pem.Decode(ca.Certificate)
+	caCert, err := x509.ParseCertificate(caBlock.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "test-cr-elastic-infra.test-ns.svc", Roots: roots}); err != nil {
+		t.Fatalf("unexpected error verifying the service certificate: %v", err)
+	}
+
+	caBundle := &caBundleConfigMapT{}
+	if caBundleBytes, err := ioutil.ReadFile(filepath.Join(certificatesDir, "ca", "configmap.yaml")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := yaml.Unmarshal(caBundleBytes, caBundle); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if caBundle.Data["ca.crt"] != string(ca.Certificate) {
+		t.Fatal("expected the CA bundle config to contain the CA certificate")
+	}
+}
@@ -0,0 +1,113 @@
+package qust
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/ejson"
+	"github.com/pkg/errors"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+)
+
+const redactedEjsonValue = "<redacted>"
+
+// EjsonFileContent is the decrypted content of an ejson file
+type EjsonFileContent struct {
+	Path      string
+	PublicKey string
+	// redacted unless revealed, non string values are json encoded
+	Values map[string]string
+}
+
+// Keys returns the keys of Values in order
+func (c *EjsonFileContent) Keys() []string {
+	keys := make([]string, 0, len(c.Values))
+	for key := range c.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InspectEjsonFile decrypts an ejson file with ejsonPrivateKey, or with the private key named after the public key of
+// the file in ejsonKeyDir if ejsonPrivateKey is empty. Values are redacted unless reveal is set.
+func InspectEjsonFile(filePath, ejsonKeyDir, ejsonPrivateKey string, reveal bool) (*EjsonFileContent, error) {
+	decrypted, err := ejson.DecryptFile(filePath, ejsonKeyDir, strings.TrimSpace(ejsonPrivateKey))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decrypting ejson file: %v", filePath)
+	}
+	var ejsonData map[string]interface{}
+	if err := json.Unmarshal(decrypted, &ejsonData); err != nil {
+		return nil, errors.Wrapf(err, "error parsing decrypted ejson file: %v", filePath)
+	}
+
+	content := &EjsonFileContent{Path: filePath, Values: make(map[string]string)}
+	for key, value := range ejsonData {
+		if key == ejsonPublicKeyField {
+			content.PublicKey, _ = value.(string)
+			continue
+		}
+		// ejson leaves the keys starting with an underscore unencrypted, nothing to hide
+		if !reveal && !strings.HasPrefix(key, "_") {
+			content.Values[key] = redactedEjsonValue
+		} else if stringValue, ok := value.(string); ok {
+			content.Values[key] = stringValue
+		} else if jsonBytes, err := json.Marshal(value); err != nil {
+			return nil, err
+		} else {
+			content.Values[key] = string(jsonBytes)
+		}
+	}
+	return content, nil
+}
+
+// DecryptEjsonData decrypts the content of an ejson file, ex. read from the git history, with ejsonPrivateKey
+// or the private key named after its public key in ejsonKeyDir. Non string values are json encoded.
+func DecryptEjsonData(encrypted []byte, ejsonKeyDir, ejsonPrivateKey string) (map[string]string, error) {
+	var decrypted bytes.Buffer
+	if err := ejson.Decrypt(bytes.NewReader(encrypted), &decrypted, ejsonKeyDir, strings.TrimSpace(ejsonPrivateKey)); err != nil {
+		return nil, errors.Wrap(err, "error decrypting ejson data")
+	}
+	var ejsonData map[string]interface{}
+	if err := json.Unmarshal(decrypted.Bytes(), &ejsonData); err != nil {
+		return nil, errors.Wrap(err, "error parsing decrypted ejson data")
+	}
+	values := make(map[string]string)
+	for key, value := range ejsonData {
+		if stringValue, ok := value.(string); ok {
+			values[key] = stringValue
+		} else if jsonBytes, err := json.Marshal(value); err != nil {
+			return nil, err
+		} else {
+			values[key] = string(jsonBytes)
+		}
+	}
+	return values, nil
+}
+
+// InspectEjsonFiles decrypts every ejson file under .operator, ordered by path, see InspectEjsonFile
+func InspectEjsonFiles(cr *config.CRSpec, ejsonKeyDir, ejsonPrivateKey string, reveal bool) ([]*EjsonFileContent, error) {
+	ejsonFiles, err := findEjsonFiles(filepath.Join(cr.GetManifestsRoot(), operatorPatchBaseFolder))
+	if err != nil {
+		return nil, err
+	}
+	filePaths := make([]string, 0, len(ejsonFiles))
+	for filePath := range ejsonFiles {
+		filePaths = append(filePaths, filePath)
+	}
+	sort.Strings(filePaths)
+
+	var contents []*EjsonFileContent
+	for _, filePath := range filePaths {
+		if content, err := InspectEjsonFile(filePath, ejsonKeyDir, ejsonPrivateKey, reveal); err != nil {
+			return nil, err
+		} else {
+			contents = append(contents, content)
+		}
+	}
+	return contents, nil
+}
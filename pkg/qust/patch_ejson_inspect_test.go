@@ -0,0 +1,64 @@
+package qust
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/ejson"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+)
+
+func TestInspectEjsonFiles(t *testing.T) {
+	td, dir := createManifestsStructure(t)
+	defer td()
+	cr := &config.CRSpec{ManifestsRoot: dir}
+
+	ejsonPublicKey, ejsonPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secretsFile := filepath.Join(dir, ".operator", "secrets", "edata.json")
+	if err := writeToEjsonFile(map[string]string{"_public_key": ejsonPublicKey, "mongodbUri": "bW9uZ28=", "_comment": "plain"}, secretsFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	contents, err := InspectEjsonFiles(cr, "", ejsonPrivateKey, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(contents) != 1 || contents[0].Path != secretsFile || contents[0].PublicKey != ejsonPublicKey {
+		t.Fatalf("unexpected contents: %v", contents)
+	} else if expected := map[string]string{"mongodbUri": redactedEjsonValue, "_comment": "plain"}; !reflect.DeepEqual(contents[0].Values, expected) {
+		t.Fatalf("expected redacted values: %v, got: %v", expected, contents[0].Values)
+	} else if keys := contents[0].Keys(); !reflect.DeepEqual(keys, []string{"_comment", "mongodbUri"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	// the private key is looked up by the public key of the file in the key dir
+	keyDir, _ := ioutil.TempDir(dir, "keys")
+	if err := ioutil.WriteFile(filepath.Join(keyDir, ejsonPublicKey), []byte(ejsonPrivateKey), tempPermissionCode); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := InspectEjsonFile(secretsFile, keyDir, "", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if content.Values["mongodbUri"] != "bW9uZ28=" {
+		t.Fatalf("expected the revealed value, got: %v", content.Values)
+	}
+
+	encrypted, err := ioutil.ReadFile(secretsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if values, err := DecryptEjsonData(encrypted, "", ejsonPrivateKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if values["mongodbUri"] != "bW9uZ28=" || values["_public_key"] != ejsonPublicKey {
+		t.Fatalf("unexpected decrypted values: %v", values)
+	}
+
+	_, wrongEjsonPrivateKey, _ := ejson.GenerateKeypair()
+	if _, err := InspectEjsonFile(secretsFile, "", wrongEjsonPrivateKey, true); err == nil {
+		t.Fatal("expected an error decrypting with the wrong private key")
+	}
+}
@@ -0,0 +1,136 @@
+package qust
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/ejson"
+	"github.com/pkg/errors"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+)
+
+const ejsonPublicKeyField = "_public_key"
+
+// ReencryptEjsonFiles re-encrypts every ejson file under .operator with newEjsonPublicKey, so the files a run does not
+// regenerate can still be decrypted after the ejson key pair is rotated. Every file has to be encrypted with
+// oldEjsonPublicKey, or already with newEjsonPublicKey, and is decrypted with oldEjsonPrivateKey before any is rewritten.
+// On error no file is changed. Returns the re-encrypted files.
+func ReencryptEjsonFiles(cr *config.CRSpec, oldEjsonPublicKey, oldEjsonPrivateKey, newEjsonPublicKey string) ([]string, error) {
+	ejsonFiles, err := findEjsonFiles(filepath.Join(cr.GetManifestsRoot(), operatorPatchBaseFolder))
+	if err != nil {
+		return nil, err
+	}
+	var filePaths []string
+	for filePath, publicKey := range ejsonFiles {
+		if publicKey == newEjsonPublicKey {
+			continue
+		} else if publicKey != oldEjsonPublicKey || oldEjsonPrivateKey == "" {
+			// rotating would leave the file encrypted with a key pair nobody has anymore
+			return nil, errors.Errorf("no ejson private key for public key: %v of ejson file: %v", publicKey, filePath)
+		}
+		filePaths = append(filePaths, filePath)
+	}
+	sort.Strings(filePaths)
+
+	originals := make(map[string][]byte)
+	reencrypted := make(map[string][]byte)
+	for _, filePath := range filePaths {
+		if originals[filePath], err = ioutil.ReadFile(filePath); err != nil {
+			return nil, err
+		}
+		var decrypted bytes.Buffer
+		if err := ejson.Decrypt(bytes.NewReader(originals[filePath]), &decrypted, "", strings.TrimSpace(oldEjsonPrivateKey)); err != nil {
+			return nil, errors.Wrapf(err, "error decrypting ejson file: %v with the old private key", filePath)
+		}
+		var ejsonData map[string]interface{}
+		if err := json.Unmarshal(decrypted.Bytes(), &ejsonData); err != nil {
+			return nil, errors.Wrapf(err, "error parsing decrypted ejson file: %v", filePath)
+		}
+		ejsonData[ejsonPublicKeyField] = newEjsonPublicKey
+		var encryptedBuffer bytes.Buffer
+		if jsonBytes, err := json.Marshal(ejsonData); err != nil {
+			return nil, err
+		} else if _, err := ejson.Encrypt(bytes.NewBuffer(jsonBytes), &encryptedBuffer); err != nil {
+			return nil, errors.Wrapf(err, "error encrypting ejson file: %v with the new public key", filePath)
+		}
+		reencrypted[filePath] = encryptedBuffer.Bytes()
+	}
+
+	// written next to the targets first, so a failed write leaves the files encrypted with the old key
+	if err := writeTmpFiles(filePaths, reencrypted); err != nil {
+		return nil, err
+	}
+	for i, filePath := range filePaths {
+		if err := os.Rename(filePath+".tmp", filePath); err != nil {
+			removeTmpFiles(filePaths[i:])
+			// the files renamed so far are restored to the old key
+			if rollbackErr := rollbackEjsonFiles(filePaths[:i], originals); rollbackErr != nil {
+				return nil, errors.Wrapf(err, "error renaming the re-encrypted ejson files, the rollback failed: %v", rollbackErr)
+			}
+			return nil, err
+		}
+	}
+	return filePaths, nil
+}
+
+func writeTmpFiles(filePaths []string, contents map[string][]byte) error {
+	for _, filePath := range filePaths {
+		if err := ioutil.WriteFile(filePath+".tmp", contents[filePath], os.ModePerm); err != nil {
+			removeTmpFiles(filePaths)
+			return err
+		}
+	}
+	return nil
+}
+
+func rollbackEjsonFiles(filePaths []string, originals map[string][]byte) error {
+	if err := writeTmpFiles(filePaths, originals); err != nil {
+		return err
+	}
+	for _, filePath := range filePaths {
+		if err := os.Rename(filePath+".tmp", filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findEjsonFiles returns the public key of every json file under dir with a top level _public_key
+func findEjsonFiles(dir string) (map[string]string, error) {
+	ejsonFiles := make(map[string]string)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return ejsonFiles, nil
+	}
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if info.IsDir() || filepath.Ext(filePath) != ".json" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		var jsonData map[string]interface{}
+		if err := json.Unmarshal(content, &jsonData); err != nil {
+			// not an ejson file
+			return nil
+		} else if publicKey, ok := jsonData[ejsonPublicKeyField].(string); ok {
+			ejsonFiles[filePath] = publicKey
+		}
+		return nil
+	})
+	return ejsonFiles, err
+}
+
+func removeTmpFiles(filePaths []string) {
+	for _, filePath := range filePaths {
+		os.Remove(filePath + ".tmp")
+	}
+}
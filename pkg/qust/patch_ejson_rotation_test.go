@@ -0,0 +1,95 @@
+package qust
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ejson"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+)
+
+func TestReencryptEjsonFiles(t *testing.T) {
+	td, dir := createManifestsStructure(t)
+	defer td()
+	cr := &config.CRSpec{ManifestsRoot: dir}
+
+	oldEjsonPublicKey, oldEjsonPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newEjsonPublicKey, newEjsonPrivateKey, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secretsFile := filepath.Join(dir, ".operator", "secrets", "edata.json")
+	configsFile := filepath.Join(dir, ".operator", "configs", "edata.json")
+	for _, filePath := range []string{secretsFile, configsFile} {
+		if err := writeToEjsonFile(map[string]string{"_public_key": oldEjsonPublicKey, "mongodbUri": "bW9uZ28="}, filePath); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// not an ejson file, left alone
+	plainFile := filepath.Join(dir, ".operator", "configs", "plain.json")
+	if err := ioutil.WriteFile(plainFile, []byte(`{"foo":"bar"}`), tempPermissionCode); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a wrong old private key leaves every file encrypted with the old key
+	_, wrongEjsonPrivateKey, _ := ejson.GenerateKeypair()
+	if _, err := ReencryptEjsonFiles(cr, oldEjsonPublicKey, wrongEjsonPrivateKey, newEjsonPublicKey); err == nil {
+		t.Fatal("expected an error re-encrypting with the wrong old private key")
+	}
+	// as does a file encrypted with another key pair than the old one
+	otherEjsonPublicKey, _, _ := ejson.GenerateKeypair()
+	otherFile := filepath.Join(dir, ".operator", "secrets", "other", "edata.json")
+	if err := os.MkdirAll(filepath.Dir(otherFile), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := writeToEjsonFile(map[string]string{"_public_key": otherEjsonPublicKey, "mongodbUri": "bW9uZ28="}, otherFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ReencryptEjsonFiles(cr, oldEjsonPublicKey, oldEjsonPrivateKey, newEjsonPublicKey); err == nil || !strings.Contains(err.Error(), otherEjsonPublicKey) {
+		t.Fatalf("expected an error for the file encrypted with another key pair, got: %v", err)
+	} else if err := os.Remove(otherFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, filePath := range []string{secretsFile, configsFile} {
+		if _, err := ejson.DecryptFile(filePath, "", oldEjsonPrivateKey); err != nil {
+			t.Fatalf("expected %v to still be encrypted with the old key: %v", filePath, err)
+		}
+	}
+
+	reencrypted, err := ReencryptEjsonFiles(cr, oldEjsonPublicKey, oldEjsonPrivateKey, newEjsonPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(reencrypted) != 2 {
+		t.Fatalf("expected 2 re-encrypted files, got: %v", reencrypted)
+	}
+	for _, filePath := range []string{secretsFile, configsFile} {
+		decrypted, err := ejson.DecryptFile(filePath, "", newEjsonPrivateKey)
+		if err != nil {
+			t.Fatalf("unexpected error decrypting %v with the new key: %v", filePath, err)
+		}
+		var data map[string]string
+		if err := json.Unmarshal(decrypted, &data); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if data["mongodbUri"] != "bW9uZ28=" || data["_public_key"] != newEjsonPublicKey {
+			t.Fatalf("unexpected decrypted content of %v: %v", filePath, data)
+		}
+	}
+	if content, _ := ioutil.ReadFile(plainFile); string(content) != `{"foo":"bar"}` {
+		t.Fatalf("unexpected change to %v: %v", plainFile, string(content))
+	}
+
+	// files already on the new key are skipped
+	if reencrypted, err := ReencryptEjsonFiles(cr, oldEjsonPublicKey, oldEjsonPrivateKey, newEjsonPublicKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(reencrypted) != 0 {
+		t.Fatalf("expected no re-encrypted files, got: %v", reencrypted)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/Shopify/ejson"
+	"github.com/pkg/errors"
 	"github.com/qlik-oss/k-apis/pkg/config"
 	"github.com/qlik-oss/k-apis/pkg/keys"
 	"gopkg.in/yaml.v2"
@@ -26,7 +27,23 @@ type serviceT struct {
 	JWKS       string
 }
 
+// KeysOptions holds key material resolved outside of the manifests root
+type KeysOptions struct {
+	// signs the elastic-infra certificate, it is self-signed if nil
+	CA *keys.CertificateAuthority
+	// PEM encoded certificate and key supplied by the user for elastic-infra, generated if empty
+	TlsCertificate []byte
+	TlsKey         []byte
+	// in-cluster DNS names of the elastic-infra service, added to the generated certificate
+	ServiceDNSNames []string
+}
+
 func GenerateKeys(cr *config.CRSpec, ejsonPublicKey string) error {
+	return GenerateKeysWithOptions(cr, ejsonPublicKey, &KeysOptions{})
+}
+
+// GenerateKeysWithOptions generates the application keys using the CA and TLS material in options
+func GenerateKeysWithOptions(cr *config.CRSpec, ejsonPublicKey string, options *KeysOptions) error {
 	serviceList, err := initServiceList(cr)
 	if err != nil {
 		return err
@@ -34,7 +51,7 @@ func GenerateKeys(cr *config.CRSpec, ejsonPublicKey string) error {
 	for _, service := range serviceList {
 		if service.PrivateKey, service.Kid, service.JWKS, err = keys.Generate(); err != nil {
 			return err
-		} else if err := overrideServiceEpriviteKeyJsonFile(cr, service, ejsonPublicKey); err != nil {
+		} else if err := overrideServiceEpriviteKeyJsonFile(cr, service, ejsonPublicKey, options); err != nil {
 			return err
 		}
 	}
@@ -64,12 +81,12 @@ func initServiceList(cr *config.CRSpec) ([]*serviceT, error) {
 	return serviceList, nil
 }
 
-func overrideServiceEpriviteKeyJsonFile(cr *config.CRSpec, service *serviceT, ejsonPublicKey string) error {
+func overrideServiceEpriviteKeyJsonFile(cr *config.CRSpec, service *serviceT, ejsonPublicKey string, options *KeysOptions) error {
 	ePriviteKeyMap := make(map[string]string)
 	ePriviteKeyMap["_public_key"] = ejsonPublicKey
 
 	if service.Name == "elastic-infra" {
-		if certPem, keyPem, err := keys.GetSelfSignedCertAndKey(cr.TlsCertHost, cr.TlsCertOrg, time.Hour*24*365*10); err != nil {
+		if certPem, keyPem, err := getElasticInfraCertAndKey(cr, options); err != nil {
 			return err
 		} else {
 			ePriviteKeyMap["tls_cert"] = base64.StdEncoding.EncodeToString(certPem)
@@ -114,6 +131,50 @@ func overrideServiceEpriviteKeyJsonFile(cr *config.CRSpec, service *serviceT, ej
 	return nil
 }
 
+func getElasticInfraCertAndKey(cr *config.CRSpec, options *KeysOptions) (certificate, key []byte, err error) {
+	if len(options.TlsCertificate) > 0 && len(options.TlsKey) > 0 {
+		return options.TlsCertificate, options.TlsKey, nil
+	}
+	if certificateOptions, err := getCertificateOptions(cr.GetTls()); err != nil {
+		return nil, nil, err
+	} else {
+		certificateOptions.DNSNames = append([]string{}, certificateOptions.DNSNames...)
+		for _, dnsName := range options.ServiceDNSNames {
+			if !contains(certificateOptions.DNSNames, dnsName) {
+				certificateOptions.DNSNames = append(certificateOptions.DNSNames, dnsName)
+			}
+		}
+		if certificateOptions.CommonName == "" && len(certificateOptions.DNSNames) > 0 {
+			certificateOptions.CommonName = certificateOptions.DNSNames[0]
+		}
+		return keys.GenerateCertAndKey(certificateOptions, options.CA)
+	}
+}
+
+func getCertificateOptions(tls *config.TlsSpec) (keys.CertificateOptions, error) {
+	options := keys.CertificateOptions{
+		CommonName:   tls.CommonName,
+		Organization: tls.Organization,
+		DNSNames:     tls.DNSNames,
+		KeyType:      keys.KeyType(tls.KeyType),
+	}
+	if len(options.DNSNames) > 0 && options.CommonName == "" {
+		options.CommonName = options.DNSNames[0]
+	}
+	var err error
+	if options.IPAddresses, err = keys.ParseIPAddresses(tls.IPAddresses); err != nil {
+		return keys.CertificateOptions{}, err
+	} else if options.KeyUsage, options.ExtKeyUsage, err = keys.ParseKeyUsages(tls.KeyUsages); err != nil {
+		return keys.CertificateOptions{}, err
+	}
+	if tls.Validity != "" {
+		if options.Validity, err = time.ParseDuration(tls.Validity); err != nil {
+			return keys.CertificateOptions{}, fmt.Errorf("invalid TLS validity: %v, error: %w", tls.Validity, err)
+		}
+	}
+	return options, nil
+}
+
 func overrideKeysEjwksJsonFile(cr *config.CRSpec, services []*serviceT, ejsonPublicKey string) error {
 	eJwksMap := make(map[string]string)
 	eJwksMap["_public_key"] = ejsonPublicKey
@@ -130,7 +191,13 @@ func overrideKeysEjwksJsonFile(cr *config.CRSpec, services []*serviceT, ejsonPub
 	return nil
 }
 
+// ErrEjsonPublicKeyRequired is returned instead of writing an ejson file without the data it could not encrypt
+var ErrEjsonPublicKeyRequired = errors.New("an ejson public key is required to write an ejson file")
+
 func writeToEjsonFile(ejsonDataMap map[string]string, filePath string) error {
+	if ejsonDataMap[ejsonPublicKeyField] == "" {
+		return errors.Wrapf(ErrEjsonPublicKeyRequired, "error writing ejson file: %v", filePath)
+	}
 	var encryptedBuffer bytes.Buffer
 	if jsonBytes, err := json.Marshal(ejsonDataMap); err != nil {
 		return err
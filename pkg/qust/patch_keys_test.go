@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/qlik-oss/k-apis/pkg/config"
 	"github.com/stretchr/testify/assert"
@@ -126,3 +127,20 @@ func TestInitServiceList(t *testing.T) {
 		assert.Equal(t, []*serviceT{{Name: "bar"}, {Name: "foo"}}, services)
 	}
 }
+
+func TestGetCertificateOptions(t *testing.T) {
+	options, err := getCertificateOptions(&config.TlsSpec{
+		DNSNames:    []string{"qliksense.example"},
+		IPAddresses: []string{"10.0.0.1"},
+		Validity:    "720h",
+		KeyType:     "ecdsa384",
+		KeyUsages:   []string{"keyEncipherment", "serverAuth"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "qliksense.example", options.CommonName)
+	assert.Equal(t, 720*time.Hour, options.Validity)
+	assert.Len(t, options.IPAddresses, 1)
+
+	_, err = getCertificateOptions(&config.TlsSpec{Validity: "ten years"})
+	assert.Error(t, err)
+}
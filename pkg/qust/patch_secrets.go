@@ -23,6 +23,7 @@ transformers:
   - ../gomplate.yaml
 `
 
+// the data source type and file name depend on the secrets encryption
 const patchedSecretsGomplateFileYaml = `apiVersion: qlik.com/v1
 kind: Gomplate
 metadata:
@@ -30,15 +31,25 @@ metadata:
   labels:
     key: gomplate
 dataSource:
-  ejson:
-    filePath: edata.json
+  %v:
+    filePath: %v
 `
 
+// ProcessSecrets encrypts the CR secrets with the encryption configured in the CR, ejson by default
 func ProcessSecrets(cr *config.CRSpec, ejsonPublicKey string) error {
+	encryption, err := NewSecretsEncryption(cr, ejsonPublicKey)
+	if err != nil {
+		return err
+	}
+	return ProcessSecretsWithEncryption(cr, encryption)
+}
+
+func ProcessSecretsWithEncryption(cr *config.CRSpec, encryption SecretsEncryption) error {
 	baseSecretDir := filepath.Join(cr.GetManifestsRoot(), operatorPatchBaseFolder, "secrets")
+	dataSourceType, dataFileName := encryption.DataSource()
 	if _, err := os.Stat(baseSecretDir); os.IsNotExist(err) {
 		return fmt.Errorf("%v does not exist", baseSecretDir)
-	} else if err := ioutil.WriteFile(filepath.Join(baseSecretDir, "gomplate.yaml"), []byte(patchedSecretsGomplateFileYaml), os.ModePerm); err != nil {
+	} else if err := ioutil.WriteFile(filepath.Join(baseSecretDir, "gomplate.yaml"), []byte(fmt.Sprintf(patchedSecretsGomplateFileYaml, dataSourceType, dataFileName)), os.ModePerm); err != nil {
 		return errors.Wrapf(err, "error writing out the secrets' gomplate.yaml file: %v", filepath.Join(baseSecretDir, "gomplate.yaml"))
 	} else if pm, err := createSupperSecretSelectivePatch(cr.Secrets); err != nil {
 		return errors.Wrap(err, "error creating the selective patches map")
@@ -53,24 +64,20 @@ func ProcessSecrets(cr *config.CRSpec, ejsonPublicKey string) error {
 				return errors.Wrapf(err, "error writing out service secret kustomization.yaml file: %v", filepath.Join(dir, "kustomization.yaml"))
 			} else if err := writeSelectivePatchFile(dir, sps); err != nil {
 				return errors.Wrap(err, "error writing out secret selective patch")
-			} else if err := writeEjsonFile(dir, cr.Secrets[svc], ejsonPublicKey); err != nil {
-				return errors.Wrap(err, "error writing out secret selective patch")
+			} else if err := writeSecretsDataFile(dir, cr.Secrets[svc], encryption); err != nil {
+				return errors.Wrap(err, "error writing out the encrypted secrets")
 			}
 		}
 	}
 	return nil
 }
 
-func writeEjsonFile(dir string, secrets config.NameValues, ejsonPublicKey string) error {
-	if ejsonPublicKey == "" {
-		return nil
-	}
-	ejsonDataMap := make(map[string]string)
-	ejsonDataMap["_public_key"] = ejsonPublicKey
+func writeSecretsDataFile(dir string, secrets config.NameValues, encryption SecretsEncryption) error {
+	dataMap := make(map[string]string)
 	for _, secret := range secrets {
-		ejsonDataMap[secret.Name] = base64.StdEncoding.EncodeToString([]byte(secret.GetSecretValue()))
+		dataMap[secret.Name] = base64.StdEncoding.EncodeToString([]byte(secret.GetSecretValue()))
 	}
-	return writeToEjsonFile(ejsonDataMap, filepath.Join(dir, "edata.json"))
+	return encryption.WriteFile(dataMap, dir)
 }
 
 func writeSelectivePatchFile(dir string, sps *config.SelectivePatch) error {
@@ -0,0 +1,114 @@
+package qust
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/qlik-oss/k-apis/pkg/config"
+)
+
+const (
+	SecretsEncryptionEjson   = "ejson"
+	SecretsEncryptionSopsAge = "sops-age"
+
+	defaultSopsBinary = "sops"
+)
+
+// ErrSopsAgeNotSupported is returned for the sops-age secrets encryption until the Gomplate transformer
+// of the pinned kustomize can decrypt a sops data source
+var ErrSopsAgeNotSupported = errors.New("sops-age secrets encryption is not supported by the Gomplate transformer yet")
+
+// SecretsEncryption encrypts the secrets data file of a service for the gomplate transformer to decrypt while rendering
+type SecretsEncryption interface {
+	// WriteFile encrypts data into the data file in dir, data values are base64 encoded
+	WriteFile(data map[string]string, dir string) error
+	// DataSource returns the gomplate data source type decrypting the data file and the name of the file, they are written
+	// to the Gomplate transformer config as dataSource: {<type>: {filePath: <file>}}, the transformer rendering the
+	// manifests must support the type, ejson or sops
+	DataSource() (dataSourceType, fileName string)
+}
+
+// EjsonEncryption encrypts the data file with an ejson public key, the default
+type EjsonEncryption struct {
+	PublicKey string
+}
+
+func (e *EjsonEncryption) WriteFile(data map[string]string, dir string) error {
+	ejsonDataMap := map[string]string{ejsonPublicKeyField: e.PublicKey}
+	for k, v := range data {
+		ejsonDataMap[k] = v
+	}
+	_, fileName := e.DataSource()
+	return writeToEjsonFile(ejsonDataMap, filepath.Join(dir, fileName))
+}
+
+func (e *EjsonEncryption) DataSource() (string, string) {
+	return "ejson", "edata.json"
+}
+
+// SopsAgeEncryption encrypts the data file with the sops binary to age recipients,
+// rendering needs one of the age private keys, ex. in SOPS_AGE_KEY_FILE
+type SopsAgeEncryption struct {
+	Recipients []string
+	// path of the sops binary, looked up in PATH if empty
+	Binary string
+}
+
+func (e *SopsAgeEncryption) WriteFile(data map[string]string, dir string) error {
+	if len(e.Recipients) == 0 {
+		return fmt.Errorf("sops-age secrets encryption requires at least one age recipient")
+	}
+	binary := e.Binary
+	if binary == "" {
+		binary = defaultSopsBinary
+	}
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	// sops detects the format from the extension, the plaintext only lives in a private temp file
+	plaintextFile, err := ioutil.TempFile("", "*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(plaintextFile.Name())
+	if _, err := plaintextFile.Write(jsonBytes); err != nil {
+		plaintextFile.Close()
+		return err
+	} else if err := plaintextFile.Close(); err != nil {
+		return err
+	}
+
+	_, fileName := e.DataSource()
+	cmd := exec.Command(binary, "--encrypt", "--age", strings.Join(e.Recipients, ","), "--output", filepath.Join(dir, fileName), plaintextFile.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "error encrypting secrets with sops, output: %v", string(output))
+	}
+	return nil
+}
+
+func (e *SopsAgeEncryption) DataSource() (string, string) {
+	return "sops", "edata.sops.json"
+}
+
+// NewSecretsEncryption returns the encryption configured in the CR, ejson with ejsonPublicKey by default
+func NewSecretsEncryption(cr *config.CRSpec, ejsonPublicKey string) (SecretsEncryption, error) {
+	if cr.SecretsEncryption == nil {
+		return &EjsonEncryption{PublicKey: ejsonPublicKey}, nil
+	}
+	switch cr.SecretsEncryption.Type {
+	case "", SecretsEncryptionEjson:
+		return &EjsonEncryption{PublicKey: ejsonPublicKey}, nil
+	case SecretsEncryptionSopsAge:
+		return nil, ErrSopsAgeNotSupported
+	default:
+		return nil, fmt.Errorf("unsupported secrets encryption type: %v", cr.SecretsEncryption.Type)
+	}
+}
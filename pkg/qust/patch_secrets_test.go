@@ -1,7 +1,10 @@
 package qust
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -14,6 +17,36 @@ import (
 	"sigs.k8s.io/kustomize/api/types"
 )
 
+const fakeSopsEnv = "QUST_TEST_FAKE_SOPS"
+
+// TestMain runs the test binary as a fake sops when fakeSopsEnv is set,
+// it writes the age recipients and the plaintext to the output file
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeSopsEnv) != "1" {
+		os.Exit(m.Run())
+	}
+	var recipients, output string
+	args := os.Args[1:]
+	for i := 0; i < len(args)-2; i++ {
+		switch args[i] {
+		case "--age":
+			recipients = args[i+1]
+		case "--output":
+			output = args[i+1]
+		}
+	}
+	plaintext, err := ioutil.ReadFile(args[len(args)-1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(output, append([]byte(recipients+"\n"), plaintext...), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func TestCreateSupperSecretSelectivePatch(t *testing.T) {
 	reader := setupCr(t)
 	cfg, err := config.ReadCRSpecFromFile(reader)
@@ -100,5 +133,70 @@ func TestProcessCrSecrets(t *testing.T) {
 		t.Fail()
 	}
 
+	gomplate, _ := ioutil.ReadFile(filepath.Join(dir, ".operator", "secrets", "gomplate.yaml"))
+	if !strings.Contains(string(gomplate), "  ejson:\n    filePath: edata.json\n") {
+		t.Fatalf("unexpected gomplate.yaml: %v", string(gomplate))
+	}
+
+	// no secrets are patched without the data
+	if err := ProcessSecrets(cfg.Spec, ""); !errors.Is(err, ErrEjsonPublicKeyRequired) {
+		t.Fatalf("expected ErrEjsonPublicKeyRequired, got: %v", err)
+	}
+
 	td()
 }
+
+func TestProcessCrSecrets_sopsAge(t *testing.T) {
+	reader := setupCr(t)
+	cfg, err := config.ReadCRSpecFromFile(reader)
+	if err != nil {
+		t.Fatalf("error reading config from file")
+	}
+
+	td, dir := createManifestsStructure(t)
+	defer td()
+	cfg.Spec.ManifestsRoot = dir
+
+	// the test binary stands in for sops, see TestMain
+	defer os.Setenv(fakeSopsEnv, os.Getenv(fakeSopsEnv))
+	os.Setenv(fakeSopsEnv, "1")
+	sopsBinary := os.Args[0]
+
+	// fails fast until the Gomplate transformer supports the sops data source
+	cfg.Spec.SecretsEncryption = &config.SecretsEncryption{Type: SecretsEncryptionSopsAge, AgeRecipients: []string{"age1foo", "age1bar"}, SopsBinary: sopsBinary}
+	if err := ProcessSecrets(cfg.Spec, ""); !errors.Is(err, ErrSopsAgeNotSupported) {
+		t.Fatalf("expected ErrSopsAgeNotSupported, got: %v", err)
+	} else if _, err := os.Stat(filepath.Join(dir, ".operator", "secrets", "gomplate.yaml")); !os.IsNotExist(err) {
+		t.Fatalf("expected no gomplate.yaml, got: %v", err)
+	}
+
+	if err := ProcessSecretsWithEncryption(cfg.Spec, &SopsAgeEncryption{Recipients: []string{"age1foo", "age1bar"}, Binary: sopsBinary}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the config of the Gomplate transformer
+	var gomplate struct {
+		Kind       string `yaml:"kind"`
+		DataSource map[string]struct {
+			FilePath string `yaml:"filePath"`
+		} `yaml:"dataSource"`
+	}
+	if gomplateBytes, err := ioutil.ReadFile(filepath.Join(dir, ".operator", "secrets", "gomplate.yaml")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := yaml.Unmarshal(gomplateBytes, &gomplate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if gomplate.Kind != "Gomplate" || len(gomplate.DataSource) != 1 || gomplate.DataSource["sops"].FilePath != "edata.sops.json" {
+		t.Fatalf("unexpected gomplate.yaml: %v", string(gomplateBytes))
+	}
+	encrypted, err := ioutil.ReadFile(filepath.Join(dir, ".operator", "secrets", "qliksense", "edata.sops.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !strings.HasPrefix(string(encrypted), "age1foo,age1bar\n") || !strings.Contains(string(encrypted), `"mongodbUri":`) {
+		t.Fatalf("unexpected sops input: %v", string(encrypted))
+	}
+
+	cfg.Spec.SecretsEncryption.Type = "unknown"
+	if _, err := NewSecretsEncryption(cfg.Spec, ""); err == nil {
+		t.Fatal("expected an error for an unsupported secrets encryption")
+	}
+}
@@ -1,20 +1,24 @@
 package state
 
 import (
-	"context"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
-
-	"github.com/qlik-oss/k-apis/pkg/utils"
+	"sort"
+	"strconv"
 
 	"github.com/mholt/archiver/v3"
-	v1 "k8s.io/api/core/v1"
 	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type BackupDir struct {
@@ -25,47 +29,253 @@ type BackupDir struct {
 const (
 	releaseLabelKey          = "release"
 	defaultReleaseLabelValue = "qliksense"
+	// set on the chunk secrets, the value is the name of the index secret
+	backupLabelKey = "qlik.com/backup"
+	// data key of the manifest in the index secret
+	manifestKey = "backup-manifest.json"
+	// data key of the archive part in a chunk secret
+	chunkDataKey = "chunk"
 )
 
+// archives larger than this are split across chunk secrets, well below the ~1MiB kubernetes object limit
+var maxChunkSize = 512 * 1024
+
+// total size of the archives kept in the index secret, the others are moved to chunk secrets
+// so the index with its manifest stays below the ~1MiB kubernetes object limit
+var maxInlineSize = 768 * 1024
+
+// backupManifest is stored in the index secret and describes how to reassemble every archive
+type backupManifest struct {
+	Entries map[string]*backupManifestEntry `json:"entries"`
+	// nil for backups taken before the history was kept
+	Generation *BackupGeneration `json:"generation,omitempty"`
+}
+
+type backupManifestEntry struct {
+	// hex encoded sha256 checksum of the whole archive
+	Sha256 string `json:"sha256"`
+	Size   int    `json:"size"`
+	// names of the chunk secrets in order, empty if the archive is stored in the index secret under its key
+	Chunks []string `json:"chunks,omitempty"`
+	// nil if the archive is not encrypted
+	Encryption *backupEncryption `json:"encryption,omitempty"`
+}
+
+type backupEncryption struct {
+	Provider string `json:"provider"`
+	// the AES-256-GCM data key of the archive, wrapped by the key provider
+	WrappedKey []byte `json:"wrappedKey"`
+}
+
+// Options configures how the archives are stored
+type Options struct {
+	// where the backup is kept, kubernetes secrets in the given namespace if nil
+	Store BackupStore
+	// encrypts the archives if set, otherwise they are stored in plain tar.gz
+	KeyProvider KeyProvider
+	// number of backup generations to keep, defaults to 5
+	Retention int
+	// recorded in the backup generation
+	CRGeneration int64
+	KeysAction   string
+	// the backup generation the keys were restored from, if any
+	RestoredGeneration *int
+	// added to the labels and annotations set on every backup object
+	Labels      map[string]string
+	Annotations map[string]string
+	// ex. the CR, so the backup is deleted with it
+	OwnerReferences []metaV1.OwnerReference
+}
+
+// Backup archives every backupDir and stores the archives in secretName
+// archives that are too large for a single secret are split into chunk secrets referenced by the manifest in secretName
 func Backup(kubeconfigPath, secretName, namespace, releaseLabelValue string, backupDirs []BackupDir) error {
-	if releaseLabelValue == "" {
-		releaseLabelValue = defaultReleaseLabelValue
+	return BackupWithOptions(kubeconfigPath, secretName, namespace, releaseLabelValue, backupDirs, &Options{})
+}
+
+// BackupWithOptions is Backup with the archives encrypted by options.KeyProvider and stored in options.Store
+func BackupWithOptions(kubeconfigPath, secretName, namespace, releaseLabelValue string, backupDirs []BackupDir, options *Options) error {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return err
 	}
-	secretsClient, err := utils.GetSecretsClient(kubeconfigPath, namespace)
+	return backup(store, secretName, releaseLabelValue, backupDirs, options)
+}
+
+// Restore reassembles the archives stored by Backup, verifies their checksums and unarchives them into the backupDirs
+func Restore(kubeconfigPath, secretName, namespace string, backupInfos []BackupDir) error {
+	return RestoreWithOptions(kubeconfigPath, secretName, namespace, backupInfos, &Options{})
+}
+
+// RestoreWithOptions is Restore with encrypted archives decrypted by options.KeyProvider, unencrypted archives are restored as is
+func RestoreWithOptions(kubeconfigPath, secretName, namespace string, backupInfos []BackupDir, options *Options) error {
+	store, err := getStore(kubeconfigPath, namespace, options)
 	if err != nil {
 		return err
 	}
+	return restore(store, secretName, backupInfos, options)
+}
+
+// Migrate re-stores the unencrypted archives of an existing backup encrypted with options.KeyProvider
+// returns false if there was nothing to migrate
+func Migrate(kubeconfigPath, secretName, namespace string, options *Options) (bool, error) {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return false, err
+	}
+	return migrate(store, secretName, options)
+}
+
+// Delete removes secretName and all of its chunk and generation secrets
+func Delete(kubeconfigPath, secretName, namespace string) error {
+	return DeleteWithOptions(kubeconfigPath, secretName, namespace, &Options{})
+}
+
+// DeleteWithOptions is Delete from options.Store
+func DeleteWithOptions(kubeconfigPath, secretName, namespace string, options *Options) error {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return err
+	}
+	return deleteBackup(store, secretName)
+}
+
+// DeleteBySelector deletes every backup object of namespace, or of options.Store, matching the label selector,
+// ex. "release=qliksense" for all the backups of a release. Only objects written by Backup are matched.
+// Returns the names of the deleted objects.
+func DeleteBySelector(kubeconfigPath, namespace, selector string, options *Options) ([]string, error) {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return nil, err
+	}
+	return deleteBySelector(store, selector)
+}
 
+// getStore returns options.Store, or the store keeping the backup in kubernetes secrets of namespace
+func getStore(kubeconfigPath, namespace string, options *Options) (BackupStore, error) {
+	if options.Store != nil {
+		return options.Store, nil
+	}
+	return NewSecretStore(kubeconfigPath, namespace)
+}
+
+func backup(store BackupStore, secretName, releaseLabelValue string, backupDirs []BackupDir, options *Options) error {
 	binaryData, err := getBinaryData(backupDirs)
 	if err != nil {
 		return err
 	}
+	return storeArchives(store, secretName, releaseLabelValue, binaryData, options)
+}
+
+// storeArchives writes the archives to the index secret, or to chunk secrets if they are too large
+// returns a ConflictError if another writer stored a newer generation meanwhile
+func storeArchives(store BackupStore, secretName, releaseLabelValue string, binaryData map[string][]byte, options *Options) error {
+	if releaseLabelValue == "" {
+		releaseLabelValue = defaultReleaseLabelValue
+	}
+
+	generation, indexGeneration, err := getNextGeneration(store, secretName, releaseLabelValue, options)
+	if err != nil {
+		return err
+	}
+	annotations := getBackupAnnotations(options, generation.Timestamp)
+	manifest := &backupManifest{Entries: make(map[string]*backupManifestEntry), Generation: generation}
+	indexData := make(map[string][]byte)
+	inlineSize := 0
+	var chunkObjects []*BackupObject
+	for _, key := range sortedKeys(binaryData) {
+		data := binaryData[key]
+		var encryption *backupEncryption
+		if options.KeyProvider != nil {
+			ciphertext, wrappedKey, err := encryptArchive(options.KeyProvider, data)
+			if err != nil {
+				return fmt.Errorf("error encrypting backup key: %v, error: %w", key, err)
+			}
+			data = ciphertext
+			encryption = &backupEncryption{Provider: options.KeyProvider.Name(), WrappedKey: wrappedKey}
+		}
+		// the checksum covers the stored bytes so corruption is detected before decrypting or unarchiving
+		checksum := sha256.Sum256(data)
+		entry := &backupManifestEntry{Sha256: hex.EncodeToString(checksum[:]), Size: len(data), Encryption: encryption}
+		if len(data) <= maxChunkSize && inlineSize+len(data) <= maxInlineSize {
+			indexData[key] = data
+			inlineSize += len(data)
+		} else {
+			// the checksum is part of the name so the chunks of the previous backup stay valid until the index is updated
+			for i := 0; i*maxChunkSize < len(data); i++ {
+				end := (i + 1) * maxChunkSize
+				if end > len(data) {
+					end = len(data)
+				}
+				chunkName := fmt.Sprintf("%v-%v-%v-%v", secretName, key, entry.Sha256[:8], i)
+				chunkObjects = append(chunkObjects, &BackupObject{
+					Name:            chunkName,
+					Labels:          getBackupLabels(releaseLabelValue, options, map[string]string{backupLabelKey: secretName}),
+					Annotations:     annotations,
+					Data:            map[string][]byte{chunkDataKey: data[i*maxChunkSize : end]},
+					OwnerReferences: options.OwnerReferences,
+				})
+				entry.Chunks = append(entry.Chunks, chunkName)
+			}
+		}
+		manifest.Entries[key] = entry
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	indexData[manifestKey] = manifestBytes
 
-	secret, err := secretsClient.Get(context.TODO(), secretName, metaV1.GetOptions{})
-	if err != nil && kubeApiErrors.IsNotFound(err) {
-		//doesn't exist, create:
-		_, err = secretsClient.Create(context.TODO(), &v1.Secret{
-			ObjectMeta: metaV1.ObjectMeta{
-				Name:   secretName,
-				Labels: map[string]string{releaseLabelKey: releaseLabelValue},
-			},
-			Data: binaryData,
-		}, metaV1.CreateOptions{})
-	} else if err == nil {
-		//exists, update:
-		secret.Data = binaryData
-		_, err = secretsClient.Update(context.TODO(), secret, metaV1.UpdateOptions{})
+	// the generation secret is created first to claim the generation number, it also keeps the chunks from being
+	// deleted by the retention of a concurrent writer. The index secret always holds a copy of the latest generation.
+	generationSecretName := getGenerationSecretName(secretName, generation.Number)
+	if err := store.Create(&BackupObject{
+		Name: generationSecretName,
+		Labels: getBackupLabels(releaseLabelValue, options, map[string]string{
+			backupLabelKey:     secretName,
+			generationLabelKey: strconv.Itoa(generation.Number),
+		}),
+		Annotations:     annotations,
+		Data:            indexData,
+		OwnerReferences: options.OwnerReferences,
+	}); kubeApiErrors.IsAlreadyExists(err) {
+		return &ConflictError{SecretName: secretName, Generation: generation.Number}
+	} else if err != nil {
+		return fmt.Errorf("error writing backup generation: %v, error: %w", generation.Number, err)
 	}
-	return err
+	for _, chunkObject := range chunkObjects {
+		if err := store.Put(chunkObject); err != nil {
+			return fmt.Errorf("error writing backup chunk: %v, error: %w", chunkObject.Name, err)
+		}
+	}
+	if err := commitIndex(store, &BackupObject{
+		Name:            secretName,
+		Labels:          getBackupLabels(releaseLabelValue, options, nil),
+		Annotations:     annotations,
+		Data:            indexData,
+		OwnerReferences: options.OwnerReferences,
+	}, indexGeneration, generation.Number); err != nil {
+		if IsConflict(err) {
+			// the generation lost, best effort since retention deletes it eventually
+			if err := store.Delete(generationSecretName); err != nil {
+				log.Printf("error deleting backup generation: %v, error: %v", generationSecretName, err)
+			}
+		}
+		return err
+	}
+	return applyRetention(store, secretName, options.Retention)
 }
 
-func Restore(kubeconfigPath, secretName, namespace string, backupInfos []BackupDir) error {
-	secretsClient, err := utils.GetSecretsClient(kubeconfigPath, namespace)
+func restore(store BackupStore, secretName string, backupInfos []BackupDir, options *Options) error {
+	object, err := store.Get(secretName)
 	if err != nil {
 		return err
 	}
+	return restoreFromObject(store, object, backupInfos, options)
+}
 
-	secret, err := secretsClient.Get(context.TODO(), secretName, metaV1.GetOptions{})
+func restoreFromObject(store BackupStore, object *BackupObject, backupInfos []BackupDir, options *Options) error {
+	manifest, err := getManifest(object)
 	if err != nil {
 		return err
 	}
@@ -79,16 +289,16 @@ func Restore(kubeconfigPath, secretName, namespace string, backupInfos []BackupD
 	tarGzArchiver := archiver.NewTarGz()
 	tarGzArchiver.OverwriteExisting = true
 
-	for _, backupInfo := range backupInfos {
+	// every archive is verified before any of them is unarchived so a corrupt backup doesn't leave a partial restore
+	archives := make([][]byte, len(backupInfos))
+	for i, backupInfo := range backupInfos {
+		if archives[i], err = getArchive(store, object, manifest, backupInfo.Key, options); err != nil {
+			return err
+		}
+	}
+	for i, backupInfo := range backupInfos {
 		archiveFilePath := path.Join(tmpDir, fmt.Sprintf("%v.tar.gz", backupInfo.Key))
-		if data, ok := secret.Data[backupInfo.Key]; !ok {
-			return &kubeApiErrors.StatusError{ErrStatus: metaV1.Status{
-				Status:  metaV1.StatusFailure,
-				Code:    http.StatusNotFound,
-				Reason:  metaV1.StatusReasonNotFound,
-				Message: fmt.Sprintf("key: %v not found in secret: %v", backupInfo.Key, secretName),
-			}}
-		} else if err := ioutil.WriteFile(archiveFilePath, data, os.ModePerm); err != nil {
+		if err := ioutil.WriteFile(archiveFilePath, archives[i], os.ModePerm); err != nil {
 			return err
 		} else if err := tarGzArchiver.Unarchive(archiveFilePath, backupInfo.Directory); err != nil {
 			return err
@@ -98,6 +308,186 @@ func Restore(kubeconfigPath, secretName, namespace string, backupInfos []BackupD
 	return nil
 }
 
+func migrate(store BackupStore, secretName string, options *Options) (bool, error) {
+	if options.KeyProvider == nil {
+		return false, nil
+	}
+	object, err := store.Get(secretName)
+	if err != nil {
+		return false, err
+	}
+	manifest, err := getManifest(object)
+	if err != nil {
+		return false, err
+	}
+
+	migrated := false
+	if keys, plaintext := getArchiveKeys(object, manifest); plaintext {
+		binaryData := make(map[string][]byte)
+		for _, key := range keys {
+			if binaryData[key], err = getArchive(store, object, manifest, key, options); err != nil {
+				return false, err
+			}
+		}
+		if err := storeArchives(store, secretName, object.Labels[releaseLabelKey], binaryData, options); err != nil {
+			return false, err
+		}
+		migrated = true
+	}
+	// the generations backed up before the migration still hold the plaintext archives
+	if deleted, err := deletePlaintextGenerations(store, secretName, options.Retention); err != nil {
+		return migrated, err
+	} else if deleted {
+		migrated = true
+	}
+	return migrated, nil
+}
+
+// getArchiveKeys returns the keys of the archives of object and whether any of them is stored unencrypted,
+// archives of secrets written before backups were chunked are stored under their key without a manifest entry
+func getArchiveKeys(object *BackupObject, manifest *backupManifest) ([]string, bool) {
+	keys := make(map[string][]byte)
+	for key := range object.Data {
+		if key != manifestKey {
+			keys[key] = nil
+		}
+	}
+	for key := range manifest.Entries {
+		keys[key] = nil
+	}
+	plaintext := false
+	for key := range keys {
+		if entry, ok := manifest.Entries[key]; !ok || entry.Encryption == nil {
+			plaintext = true
+		}
+	}
+	return sortedKeys(keys), plaintext
+}
+
+// deletePlaintextGenerations deletes the generations of secretName holding unencrypted archives, and their chunks
+func deletePlaintextGenerations(store BackupStore, secretName string, retention int) (bool, error) {
+	generationObjects, err := listGenerationObjects(store, secretName)
+	if err != nil {
+		return false, err
+	}
+	deleted := false
+	for _, generationObject := range generationObjects {
+		manifest, err := getManifest(generationObject)
+		if err != nil {
+			return deleted, err
+		} else if _, plaintext := getArchiveKeys(generationObject, manifest); !plaintext {
+			continue
+		} else if err := store.Delete(generationObject.Name); err != nil && !kubeApiErrors.IsNotFound(err) {
+			return deleted, fmt.Errorf("error deleting unencrypted backup generation: %v, error: %w", generationObject.Name, err)
+		}
+		deleted = true
+	}
+	if !deleted {
+		return false, nil
+	}
+	// the chunks of the deleted generations are no longer referenced
+	return true, applyRetention(store, secretName, retention)
+}
+
+func deleteBackup(store BackupStore, secretName string) error {
+	if err := store.Delete(secretName); err != nil {
+		return err
+	}
+	return deleteUnreferencedObjects(store, secretName, map[string]bool{})
+}
+
+// getManifest returns an empty manifest for secrets written before backups were chunked
+func getManifest(object *BackupObject) (*backupManifest, error) {
+	manifest := &backupManifest{Entries: make(map[string]*backupManifestEntry)}
+	if manifestBytes, ok := object.Data[manifestKey]; ok {
+		if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+			return nil, fmt.Errorf("error parsing the manifest of backup: %v, error: %w", object.Name, err)
+		}
+	}
+	return manifest, nil
+}
+
+// getArchive reassembles the archive stored under key, verifies its checksum and decrypts it
+func getArchive(store BackupStore, object *BackupObject, manifest *backupManifest, key string, options *Options) ([]byte, error) {
+	entry, ok := manifest.Entries[key]
+	if !ok || len(entry.Chunks) == 0 {
+		data, ok := object.Data[key]
+		if !ok {
+			return nil, newNotFoundError(fmt.Sprintf("key: %v not found in backup: %v", key, object.Name))
+		} else if entry == nil {
+			return data, nil
+		}
+		return decryptVerifiedArchive(data, entry, key, options)
+	}
+
+	var buffer bytes.Buffer
+	for _, chunkName := range entry.Chunks {
+		chunkObject, err := store.Get(chunkName)
+		if err != nil {
+			return nil, fmt.Errorf("error reading backup chunk: %v, error: %w", chunkName, err)
+		} else if chunk, ok := chunkObject.Data[chunkDataKey]; !ok {
+			return nil, newNotFoundError(fmt.Sprintf("key: %v not found in backup chunk: %v", chunkDataKey, chunkName))
+		} else {
+			buffer.Write(chunk)
+		}
+	}
+	return decryptVerifiedArchive(buffer.Bytes(), entry, key, options)
+}
+
+func decryptVerifiedArchive(data []byte, entry *backupManifestEntry, key string, options *Options) ([]byte, error) {
+	if err := verifyChecksum(data, entry, key); err != nil {
+		return nil, err
+	} else if entry.Encryption == nil {
+		return data, nil
+	} else if decrypted, err := decryptArchive(options.KeyProvider, entry.Encryption, data); err != nil {
+		return nil, fmt.Errorf("error decrypting backup key: %v, error: %w", key, err)
+	} else {
+		return decrypted, nil
+	}
+}
+
+func verifyChecksum(data []byte, entry *backupManifestEntry, key string) error {
+	checksum := sha256.Sum256(data)
+	if len(data) != entry.Size || hex.EncodeToString(checksum[:]) != entry.Sha256 {
+		return fmt.Errorf("checksum mismatch for backup key: %v, the backup is corrupt or incomplete", key)
+	}
+	return nil
+}
+
+// deleteUnreferencedObjects deletes the chunks and generations of secretName that are not in keep
+func deleteUnreferencedObjects(store BackupStore, secretName string, keep map[string]bool) error {
+	objects, err := store.List(labels.SelectorFromSet(labels.Set{backupLabelKey: secretName}))
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if keep[object.Name] {
+			continue
+		} else if err := store.Delete(object.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newNotFoundError(message string) error {
+	return &kubeApiErrors.StatusError{ErrStatus: metaV1.Status{
+		Status:  metaV1.StatusFailure,
+		Code:    http.StatusNotFound,
+		Reason:  metaV1.StatusReasonNotFound,
+		Message: message,
+	}}
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func getBinaryData(backupDirs []BackupDir) (map[string][]byte, error) {
 	tmpDir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"context"
+	"crypto/rand"
 	"io/ioutil"
 	"os"
 	"os/user"
@@ -10,6 +12,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestBackupRestore(t *testing.T) {
@@ -56,3 +62,162 @@ func TestBackupRestore(t *testing.T) {
 	})
 	assert.True(t, reflect.DeepEqual(sourceMap, targetMap))
 }
+
+func TestBackupRestore_chunked(t *testing.T) {
+	defer func(size int) { maxChunkSize = size }(maxChunkSize)
+	maxChunkSize = 1024
+
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	targetDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+
+	// random data doesn't compress, so the archive is split across several chunks
+	data := make([]byte, 10*1024)
+	_, err = rand.Read(data)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "random"), data, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "small"), []byte("foo"), os.ModePerm))
+
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	assert.NoError(t, backup(store, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{}))
+
+	secretList, err := secretsClient.List(context.TODO(), metaV1.ListOptions{LabelSelector: backupLabelKey + "=test,!" + generationLabelKey})
+	assert.NoError(t, err)
+	assert.True(t, len(secretList.Items) > 1)
+	for _, secret := range secretList.Items {
+		assert.True(t, len(secret.Data[chunkDataKey]) <= maxChunkSize)
+	}
+
+	assert.NoError(t, restore(store, "test", []BackupDir{{Key: "operator-keys", Directory: targetDir}}, &Options{}))
+	restored, err := ioutil.ReadFile(filepath.Join(targetDir, "random"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, restored)
+
+	// a smaller backup is stored in the index secret and the chunks of the dropped generation are deleted
+	assert.NoError(t, os.Remove(filepath.Join(sourceDir, "random")))
+	assert.NoError(t, backup(store, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{Retention: 1}))
+	secretList, err = secretsClient.List(context.TODO(), metaV1.ListOptions{LabelSelector: backupLabelKey + "=test"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(secretList.Items))
+	assert.Equal(t, "test-gen-2", secretList.Items[0].Name)
+
+	assert.NoError(t, deleteBackup(store, "test"))
+	_, err = secretsClient.Get(context.TODO(), "test", metaV1.GetOptions{})
+	assert.True(t, kubeApiErrors.IsNotFound(err))
+	secretList, err = secretsClient.List(context.TODO(), metaV1.ListOptions{LabelSelector: backupLabelKey + "=test"})
+	assert.NoError(t, err)
+	assert.Empty(t, secretList.Items)
+}
+
+func TestRestore_corruptChunk(t *testing.T) {
+	defer func(size int) { maxChunkSize = size }(maxChunkSize)
+	maxChunkSize = 1024
+
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	targetDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+
+	data := make([]byte, 4*1024)
+	_, err = rand.Read(data)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "random"), data, os.ModePerm))
+
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	assert.NoError(t, backup(store, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{}))
+
+	secretList, err := secretsClient.List(context.TODO(), metaV1.ListOptions{LabelSelector: backupLabelKey + "=test,!" + generationLabelKey})
+	assert.NoError(t, err)
+	chunk := secretList.Items[0]
+	chunk.Data[chunkDataKey][0] ^= 0xff
+	_, err = secretsClient.Update(context.TODO(), &chunk, metaV1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	err = restore(store, "test", []BackupDir{{Key: "operator-keys", Directory: targetDir}}, &Options{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	_, err = os.Stat(filepath.Join(targetDir, "random"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRestore_legacySecret(t *testing.T) {
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	targetDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "foo"), []byte("bar"), os.ModePerm))
+
+	// secrets written before backups were chunked have no manifest
+	binaryData, err := getBinaryData([]BackupDir{{Key: "operator-keys", Directory: sourceDir}})
+	assert.NoError(t, err)
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	_, err = secretsClient.Create(context.TODO(), &v1.Secret{ObjectMeta: metaV1.ObjectMeta{Name: "test"}, Data: binaryData}, metaV1.CreateOptions{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, restore(store, "test", []BackupDir{{Key: "operator-keys", Directory: targetDir}}, &Options{}))
+	restored, err := ioutil.ReadFile(filepath.Join(targetDir, "foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", string(restored))
+
+	err = restore(store, "test", []BackupDir{{Key: "ejson-keys", Directory: targetDir}}, &Options{})
+	assert.True(t, kubeApiErrors.IsNotFound(err))
+}
+
+func TestBackupRestore_inlineSize(t *testing.T) {
+	defer func(chunkSize, inlineSize int) { maxChunkSize, maxInlineSize = chunkSize, inlineSize }(maxChunkSize, maxInlineSize)
+	maxChunkSize, maxInlineSize = 4*1024, 4*1024
+
+	var backupInfos, restoreInfos []BackupDir
+	for _, key := range []string{"ejson-keys", "operator-keys"} {
+		sourceDir, err := ioutil.TempDir("", "")
+		assert.NoError(t, err)
+		defer os.RemoveAll(sourceDir)
+		targetDir, err := ioutil.TempDir("", "")
+		assert.NoError(t, err)
+		defer os.RemoveAll(targetDir)
+
+		// each archive fits in a chunk but not both in the index secret
+		data := make([]byte, 3*1024)
+		_, err = rand.Read(data)
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "random"), data, os.ModePerm))
+		backupInfos = append(backupInfos, BackupDir{Key: key, Directory: sourceDir})
+		restoreInfos = append(restoreInfos, BackupDir{Key: key, Directory: targetDir})
+	}
+
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	assert.NoError(t, backup(store, "test", "", backupInfos, &Options{}))
+
+	index, err := secretsClient.Get(context.TODO(), "test", metaV1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, index.Data, "ejson-keys")
+	assert.NotContains(t, index.Data, "operator-keys")
+	secretList, err := secretsClient.List(context.TODO(), metaV1.ListOptions{LabelSelector: backupLabelKey + "=test,!" + generationLabelKey})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(secretList.Items))
+
+	// the corrupt chunk of the second archive is detected before the first one is unarchived
+	chunk := secretList.Items[0]
+	chunk.Data[chunkDataKey][0] ^= 0xff
+	_, err = secretsClient.Update(context.TODO(), &chunk, metaV1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	err = restore(store, "test", restoreInfos, &Options{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	for _, restoreInfo := range restoreInfos {
+		_, err = os.Stat(filepath.Join(restoreInfo.Directory, "random"))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
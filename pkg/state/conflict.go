@@ -0,0 +1,78 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// attempts to update the index when the store reports a concurrent write that did not add a generation
+const maxCommitAttempts = 5
+
+// the resource reported in the api errors of the directory and S3 stores
+var backupGroupResource = schema.GroupResource{Resource: "backups"}
+
+// ConflictError is returned by Backup when another writer stored a newer backup generation while it was running.
+// The backup was not stored, the caller should restore the latest backup instead of overwriting it.
+type ConflictError struct {
+	SecretName string
+	// the generation that was not stored
+	Generation int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("backup: %v generation: %v was not stored, another writer backed up concurrently, restore the latest backup", e.SecretName, e.Generation)
+}
+
+// IsConflict returns true if err or any error it wraps is a ConflictError
+func IsConflict(err error) bool {
+	var conflictError *ConflictError
+	return errors.As(err, &conflictError)
+}
+
+// commitIndex makes index the latest backup if the stored index is still at expectedGeneration (-1 for no index)
+// writes that did not change the generation, ex. concurrent label updates, are retried
+func commitIndex(store BackupStore, index *BackupObject, expectedGeneration, generation int) error {
+	conflictError := &ConflictError{SecretName: index.Name, Generation: generation}
+	for attempt := 0; attempt < maxCommitAttempts; attempt++ {
+		current, err := store.Get(index.Name)
+		if err != nil && !kubeApiErrors.IsNotFound(err) {
+			return err
+		} else if err != nil {
+			// deleted since, nothing is overwritten
+			if err := store.Create(index); !kubeApiErrors.IsAlreadyExists(err) {
+				return err
+			}
+			continue
+		}
+
+		if currentGeneration, err := getIndexGeneration(current); err != nil {
+			return err
+		} else if currentGeneration != expectedGeneration {
+			return conflictError
+		}
+		indexCopy := *index
+		indexCopy.ResourceVersion = current.ResourceVersion
+		if err := store.Put(&indexCopy); !kubeApiErrors.IsConflict(err) {
+			return err
+		}
+	}
+	return conflictError
+}
+
+// getIndexGeneration returns the generation number of the index, 0 for an index written before the history was kept
+func getIndexGeneration(index *BackupObject) (int, error) {
+	manifest, err := getManifest(index)
+	if err != nil {
+		return 0, err
+	} else if manifest.Generation == nil {
+		return 0, nil
+	}
+	return manifest.Generation.Number, nil
+}
+
+func newConflictError(name, reason string) error {
+	return kubeApiErrors.NewConflict(backupGroupResource, name, errors.New(reason))
+}
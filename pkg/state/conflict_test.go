@@ -0,0 +1,127 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// hookStore calls hook once after the call number call of method for the object name, to interleave a concurrent writer
+type hookStore struct {
+	BackupStore
+	method string
+	name   string
+	call   int
+	hook   func()
+}
+
+func (s *hookStore) fire(method, name string) {
+	if s.hook == nil || s.method != method || s.name != name {
+		return
+	} else if s.call--; s.call <= 0 {
+		hook := s.hook
+		s.hook = nil
+		hook()
+	}
+}
+
+func (s *hookStore) Get(name string) (*BackupObject, error) {
+	defer s.fire("Get", name)
+	return s.BackupStore.Get(name)
+}
+
+func (s *hookStore) Create(object *BackupObject) error {
+	defer s.fire("Create", object.Name)
+	return s.BackupStore.Create(object)
+}
+
+func setupConflictTest(t *testing.T) (store *DirectoryStore, sourceDir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	store, err = NewDirectoryStore(filepath.Join(dir, "backups"), "")
+	assert.NoError(t, err)
+	sourceDir = filepath.Join(dir, "source")
+	assert.NoError(t, os.MkdirAll(sourceDir, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "key"), []byte("foo"), os.ModePerm))
+	assert.NoError(t, backup(store, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{}))
+	return store, sourceDir, func() { os.RemoveAll(dir) }
+}
+
+func backupConcurrently(t *testing.T, store BackupStore, content string) func() {
+	return func() {
+		sourceDir, err := ioutil.TempDir("", "")
+		assert.NoError(t, err)
+		defer os.RemoveAll(sourceDir)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "key"), []byte(content), os.ModePerm))
+		assert.NoError(t, backup(store, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{}))
+	}
+}
+
+func assertRestored(t *testing.T, store BackupStore, content string) {
+	targetDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+	assert.NoError(t, restore(store, "test", []BackupDir{{Key: "operator-keys", Directory: targetDir}}, &Options{}))
+	restored, err := ioutil.ReadFile(filepath.Join(targetDir, "key"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(restored))
+}
+
+func TestBackup_concurrentWriterWinsIndex(t *testing.T) {
+	store, sourceDir, cleanup := setupConflictTest(t)
+	defer cleanup()
+
+	// the other writer backs up after generation 2 was claimed, so it stores generation 3 first
+	hooked := &hookStore{BackupStore: store, method: "Create", name: "test-gen-2", call: 1, hook: backupConcurrently(t, store, "bar")}
+	err := backup(hooked, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{})
+	assert.True(t, IsConflict(err))
+	assert.Equal(t, 2, err.(*ConflictError).Generation)
+
+	_, err = store.Get("test-gen-2")
+	assert.True(t, kubeApiErrors.IsNotFound(err))
+	generations, err := listGenerations(store, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(generations))
+	assert.Equal(t, 3, generations[0].Number)
+	assertRestored(t, store, "bar")
+}
+
+func TestBackup_concurrentWriterWinsGeneration(t *testing.T) {
+	store, sourceDir, cleanup := setupConflictTest(t)
+	defer cleanup()
+
+	// the other writer stores generation 2 after this one numbered its backup
+	hooked := &hookStore{BackupStore: store, method: "Get", name: "test", call: 1, hook: backupConcurrently(t, store, "bar")}
+	err := backup(hooked, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{})
+	assert.True(t, IsConflict(err))
+
+	generations, err := listGenerations(store, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(generations))
+	assert.Equal(t, 2, generations[0].Number)
+	assertRestored(t, store, "bar")
+}
+
+func TestBackup_retriesIndexUpdate(t *testing.T) {
+	store, sourceDir, cleanup := setupConflictTest(t)
+	defer cleanup()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "key"), []byte("bar"), os.ModePerm))
+
+	// a write that does not add a generation changes the version of the index between the read and the update
+	hooked := &hookStore{BackupStore: store, method: "Get", name: "test", call: 2, hook: func() {
+		index, err := store.Get("test")
+		assert.NoError(t, err)
+		index.Labels["foo"] = "bar"
+		assert.NoError(t, store.Put(index))
+	}}
+	assert.NoError(t, backup(hooked, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{}))
+
+	generations, err := listGenerations(store, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(generations))
+	assertRestored(t, store, "bar")
+}
@@ -0,0 +1,150 @@
+package state
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/qlik-oss/k-apis/pkg/utils"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	dataKeySize          = 32
+	localKeyProviderName = "local"
+	// data key holding the key encryption key in the secret read by NewSecretKeyProvider
+	DefaultEncryptionKeySecretKey = "key"
+)
+
+// KeyProvider wraps and unwraps the per-archive data keys (envelope encryption)
+// implement it to delegate to a KMS, LocalKeyProvider keeps the key encryption key in memory
+type KeyProvider interface {
+	// Name is recorded in the backup manifest, a backup can only be restored with a provider of the same name
+	Name() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrappedKey []byte) ([]byte, error)
+}
+
+// LocalKeyProvider wraps data keys with AES-256-GCM using a local key encryption key
+type LocalKeyProvider struct {
+	key []byte
+}
+
+// NewLocalKeyProvider expects a 32 byte key, either raw or base64 encoded
+func NewLocalKeyProvider(key []byte) (*LocalKeyProvider, error) {
+	trimmedKey := strings.TrimSpace(string(key))
+	if decodedKey, err := base64.StdEncoding.DecodeString(trimmedKey); err == nil && len(decodedKey) == dataKeySize {
+		return &LocalKeyProvider{key: decodedKey}, nil
+	} else if len(key) == dataKeySize {
+		return &LocalKeyProvider{key: key}, nil
+	}
+	return nil, fmt.Errorf("the backup encryption key must be %v bytes, raw or base64 encoded", dataKeySize)
+}
+
+// NewFileKeyProvider reads the key encryption key from a file
+func NewFileKeyProvider(filePath string) (*LocalKeyProvider, error) {
+	key, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return NewLocalKeyProvider(key)
+}
+
+// NewSecretKeyProvider reads the key encryption key from dataKey of a kubernetes secret
+// the secret must not be the backup secret itself
+func NewSecretKeyProvider(kubeconfigPath, namespace, secretName, dataKey string) (*LocalKeyProvider, error) {
+	if dataKey == "" {
+		dataKey = DefaultEncryptionKeySecretKey
+	}
+	secretsClient, err := utils.GetSecretsClient(kubeconfigPath, namespace)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := secretsClient.Get(context.TODO(), secretName, metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	} else if key, ok := secret.Data[dataKey]; !ok {
+		return nil, fmt.Errorf("key: %v not found in secret: %v", dataKey, secretName)
+	} else {
+		return NewLocalKeyProvider(key)
+	}
+}
+
+func (p *LocalKeyProvider) Name() string {
+	return localKeyProviderName
+}
+
+func (p *LocalKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	return encrypt(p.key, dataKey)
+}
+
+func (p *LocalKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	return decrypt(p.key, wrappedKey)
+}
+
+// encryptArchive encrypts data with a new data key and returns the ciphertext and the wrapped data key
+func encryptArchive(provider KeyProvider, data []byte) (ciphertext, wrappedKey []byte, err error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	} else if ciphertext, err = encrypt(dataKey, data); err != nil {
+		return nil, nil, err
+	} else if wrappedKey, err = provider.WrapKey(dataKey); err != nil {
+		return nil, nil, fmt.Errorf("error wrapping the backup data key: %w", err)
+	}
+	return ciphertext, wrappedKey, nil
+}
+
+func decryptArchive(provider KeyProvider, encryption *backupEncryption, ciphertext []byte) ([]byte, error) {
+	if provider == nil {
+		return nil, errors.New("the backup is encrypted but no encryption key was provided")
+	} else if provider.Name() != encryption.Provider {
+		return nil, fmt.Errorf("the backup was encrypted with key provider: %v, not: %v", encryption.Provider, provider.Name())
+	} else if dataKey, err := provider.UnwrapKey(encryption.WrappedKey); err != nil {
+		return nil, fmt.Errorf("error unwrapping the backup data key: %w", err)
+	} else {
+		return decrypt(dataKey, ciphertext)
+	}
+}
+
+// encrypt returns nonce + AES-GCM ciphertext
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	} else if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,168 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestKeyProvider(t *testing.T) *LocalKeyProvider {
+	key := make([]byte, dataKeySize)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+	keyProvider, err := NewLocalKeyProvider([]byte(base64.StdEncoding.EncodeToString(key)))
+	assert.NoError(t, err)
+	return keyProvider
+}
+
+func TestNewLocalKeyProvider(t *testing.T) {
+	_, err := NewLocalKeyProvider(bytes.Repeat([]byte("a"), dataKeySize))
+	assert.NoError(t, err)
+	_, err = NewLocalKeyProvider([]byte("too-short"))
+	assert.Error(t, err)
+}
+
+func TestBackupRestore_encrypted(t *testing.T) {
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	targetDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "private-key"), []byte("foo"), os.ModePerm))
+
+	options := &Options{KeyProvider: newTestKeyProvider(t)}
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	assert.NoError(t, backup(store, "test", "", []BackupDir{{Key: "ejson-keys", Directory: sourceDir}}, options))
+
+	// not a gzip archive anymore
+	secret, err := secretsClient.Get(context.TODO(), "test", metaV1.GetOptions{})
+	assert.NoError(t, err)
+	assert.False(t, bytes.HasPrefix(secret.Data["ejson-keys"], []byte{0x1f, 0x8b}))
+
+	err = restore(store, "test", []BackupDir{{Key: "ejson-keys", Directory: targetDir}}, &Options{})
+	assert.Error(t, err)
+	err = restore(store, "test", []BackupDir{{Key: "ejson-keys", Directory: targetDir}}, &Options{KeyProvider: newTestKeyProvider(t)})
+	assert.Error(t, err)
+
+	assert.NoError(t, restore(store, "test", []BackupDir{{Key: "ejson-keys", Directory: targetDir}}, options))
+	restored, err := ioutil.ReadFile(filepath.Join(targetDir, "private-key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(restored))
+}
+
+func TestMigrate(t *testing.T) {
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	targetDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "private-key"), []byte("foo"), os.ModePerm))
+
+	// an unencrypted backup written before backups were chunked
+	binaryData, err := getBinaryData([]BackupDir{{Key: "ejson-keys", Directory: sourceDir}})
+	assert.NoError(t, err)
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	_, err = secretsClient.Create(context.TODO(), &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "test", Labels: map[string]string{releaseLabelKey: "foo"}},
+		Data:       binaryData,
+	}, metaV1.CreateOptions{})
+	assert.NoError(t, err)
+
+	options := &Options{KeyProvider: newTestKeyProvider(t)}
+	migrated, err := migrate(store, "test", options)
+	assert.NoError(t, err)
+	assert.True(t, migrated)
+	migrated, err = migrate(store, "test", options)
+	assert.NoError(t, err)
+	assert.False(t, migrated)
+
+	secret, err := secretsClient.Get(context.TODO(), "test", metaV1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", secret.Labels[releaseLabelKey])
+	manifest, err := getManifest(secretToBackupObject(secret))
+	assert.NoError(t, err)
+	assert.NotNil(t, manifest.Entries["ejson-keys"].Encryption)
+
+	assert.NoError(t, restore(store, "test", []BackupDir{{Key: "ejson-keys", Directory: targetDir}}, options))
+	restored, err := ioutil.ReadFile(filepath.Join(targetDir, "private-key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(restored))
+}
+
+func TestMigrate_history(t *testing.T) {
+	defer func(size int) { maxChunkSize = size }(maxChunkSize)
+	maxChunkSize = 256
+
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	// does not compress below the chunk size
+	key := make([]byte, 1000)
+	_, err = rand.Read(key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "private-key"), key, os.ModePerm))
+
+	// an unencrypted legacy backup, then unencrypted generations of it
+	binaryData, err := getBinaryData([]BackupDir{{Key: "ejson-keys", Directory: sourceDir}})
+	assert.NoError(t, err)
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	_, err = secretsClient.Create(context.TODO(), &v1.Secret{ObjectMeta: metaV1.ObjectMeta{Name: "test"}, Data: binaryData}, metaV1.CreateOptions{})
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, backup(store, "test", "", []BackupDir{{Key: "ejson-keys", Directory: sourceDir}}, &Options{}))
+	}
+
+	options := &Options{KeyProvider: newTestKeyProvider(t)}
+	migrated, err := migrate(store, "test", options)
+	assert.NoError(t, err)
+	assert.True(t, migrated)
+
+	// every index and generation is encrypted and every chunk belongs to an encrypted archive
+	secrets, err := secretsClient.List(context.TODO(), metaV1.ListOptions{})
+	assert.NoError(t, err)
+	encryptedChunks := make(map[string]bool)
+	var chunkNames []string
+	for _, secret := range secrets.Items {
+		object := secretToBackupObject(&secret)
+		if _, ok := object.Data[chunkDataKey]; ok {
+			chunkNames = append(chunkNames, object.Name)
+			continue
+		}
+		manifest, err := getManifest(object)
+		assert.NoError(t, err)
+		_, plaintext := getArchiveKeys(object, manifest)
+		assert.False(t, plaintext, "unencrypted backup: %v", object.Name)
+		for _, entry := range manifest.Entries {
+			for _, chunkName := range entry.Chunks {
+				encryptedChunks[chunkName] = true
+			}
+		}
+	}
+	assert.NotEmpty(t, chunkNames)
+	for _, chunkName := range chunkNames {
+		assert.True(t, encryptedChunks[chunkName], "unencrypted chunk: %v", chunkName)
+	}
+
+	targetDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+	assert.NoError(t, restore(store, "test", []BackupDir{{Key: "ejson-keys", Directory: targetDir}}, options))
+	restored, err := ioutil.ReadFile(filepath.Join(targetDir, "private-key"))
+	assert.NoError(t, err)
+	assert.Equal(t, key, restored)
+}
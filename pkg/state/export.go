@@ -0,0 +1,216 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	exportFormat   = "k-apis-state-export/v1"
+	exportSaltSize = 16
+	// scrypt parameters recommended for interactive use
+	exportScryptN = 32768
+	exportScryptR = 8
+	exportScryptP = 1
+)
+
+// exportFile is the serialized export, the bundle is encrypted and authenticated with AES-256-GCM
+// using a key derived from the password, so a tampered file or a wrong password fails the import
+type exportFile struct {
+	Format     string `json:"format"`
+	Salt       []byte `json:"salt"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type exportBundle struct {
+	SecretName string          `json:"secretName"`
+	ExportedAt time.Time       `json:"exportedAt"`
+	Objects    []*BackupObject `json:"objects"`
+	// exported next to the backup, ex. the ejson key pair and the CR
+	Files map[string][]byte `json:"files,omitempty"`
+}
+
+// ImportTarget renames the imported backup, the exported names are kept for empty fields
+type ImportTarget struct {
+	SecretName        string
+	ReleaseLabelValue string
+}
+
+// ImportResult describes the imported backup
+type ImportResult struct {
+	SecretName string
+	ExportedAt time.Time
+	// the files passed to Export
+	Files map[string][]byte
+}
+
+// Export bundles the backup secretName with all of its generations and chunks, together with files,
+// into a password protected file. Encrypted archives stay encrypted, the importing side needs the same KeyProvider.
+func Export(kubeconfigPath, secretName, namespace string, files map[string][]byte, password []byte, options *Options) ([]byte, error) {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return nil, err
+	}
+	return exportBackup(store, secretName, files, password)
+}
+
+// Import stores the backup of an Export file in options.Store, or in kubernetes secrets of namespace,
+// renamed as target says. An existing backup of the target name is never overwritten.
+func Import(kubeconfigPath, namespace string, export, password []byte, target ImportTarget, options *Options) (*ImportResult, error) {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return nil, err
+	}
+	return importBackup(store, export, password, target)
+}
+
+func exportBackup(store BackupStore, secretName string, files map[string][]byte, password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, errors.New("a password is required to export the backup")
+	}
+	index, err := store.Get(secretName)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := store.List(labels.SelectorFromSet(labels.Set{backupLabelKey: secretName}))
+	if err != nil {
+		return nil, err
+	}
+	bundleBytes, err := json.Marshal(&exportBundle{
+		SecretName: secretName,
+		ExportedAt: time.Now().UTC(),
+		Objects:    append(objects, index),
+		Files:      files,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, exportSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := getExportKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := encrypt(key, bundleBytes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&exportFile{Format: exportFormat, Salt: salt, Ciphertext: ciphertext})
+}
+
+// ReadExport returns what Import would import from an Export file, without storing anything
+func ReadExport(export, password []byte) (*ImportResult, error) {
+	bundle, err := readExportBundle(export, password)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportResult{SecretName: bundle.SecretName, ExportedAt: bundle.ExportedAt, Files: bundle.Files}, nil
+}
+
+func readExportBundle(export, password []byte) (*exportBundle, error) {
+	file := &exportFile{}
+	if err := json.Unmarshal(export, file); err != nil {
+		return nil, fmt.Errorf("error parsing the export file: %w", err)
+	} else if file.Format != exportFormat {
+		return nil, fmt.Errorf("unsupported export format: %v", file.Format)
+	}
+	key, err := getExportKey(password, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	bundleBytes, err := decrypt(key, file.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("wrong password or tampered export file: %w", err)
+	}
+	bundle := &exportBundle{}
+	if err := json.Unmarshal(bundleBytes, bundle); err != nil {
+		return nil, fmt.Errorf("error parsing the exported backup: %w", err)
+	}
+	return bundle, nil
+}
+
+func importBackup(store BackupStore, export, password []byte, target ImportTarget) (*ImportResult, error) {
+	bundle, err := readExportBundle(export, password)
+	if err != nil {
+		return nil, err
+	}
+
+	secretName := bundle.SecretName
+	if target.SecretName != "" {
+		secretName = target.SecretName
+	}
+	if _, err := store.Get(secretName); err == nil {
+		return nil, kubeApiErrors.NewAlreadyExists(backupGroupResource, secretName)
+	} else if !kubeApiErrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	// the index is last in the bundle, so it is only created once everything it references exists
+	for _, object := range bundle.Objects {
+		if err := renameBackupObject(object, bundle.SecretName, secretName, target.ReleaseLabelValue); err != nil {
+			return nil, err
+		} else if err := store.Create(object); err != nil {
+			return nil, fmt.Errorf("error importing backup object: %v, error: %w", object.Name, err)
+		}
+	}
+	return &ImportResult{SecretName: secretName, ExportedAt: bundle.ExportedAt, Files: bundle.Files}, nil
+}
+
+// renameBackupObject rewrites the name, the labels and the chunk names in the manifest of an object of the backup
+// fromName for the backup toName
+func renameBackupObject(object *BackupObject, fromName, toName, releaseLabelValue string) error {
+	rename := func(name string) string {
+		if name == fromName || strings.HasPrefix(name, fromName+"-") {
+			return toName + strings.TrimPrefix(name, fromName)
+		}
+		return name
+	}
+	object.Name = rename(object.Name)
+	object.ResourceVersion = ""
+	// the owners do not exist in the target cluster
+	object.OwnerReferences = nil
+	if object.Labels == nil {
+		object.Labels = make(map[string]string)
+	}
+	if _, ok := object.Labels[backupLabelKey]; ok {
+		object.Labels[backupLabelKey] = toName
+	}
+	if releaseLabelValue != "" {
+		object.Labels[releaseLabelKey] = releaseLabelValue
+		object.Labels[instanceLabelKey] = releaseLabelValue
+	}
+
+	if _, ok := object.Data[manifestKey]; !ok || fromName == toName {
+		return nil
+	}
+	manifest, err := getManifest(object)
+	if err != nil {
+		return err
+	}
+	for _, entry := range manifest.Entries {
+		for i, chunkName := range entry.Chunks {
+			entry.Chunks[i] = rename(chunkName)
+		}
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	object.Data[manifestKey] = manifestBytes
+	return nil
+}
+
+func getExportKey(password, salt []byte) ([]byte, error) {
+	return scrypt.Key(password, salt, exportScryptN, exportScryptR, exportScryptP, dataKeySize)
+}
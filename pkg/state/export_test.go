@@ -0,0 +1,65 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestExportImport(t *testing.T) {
+	defer func(size int) { maxChunkSize = size }(maxChunkSize)
+	maxChunkSize = 256
+
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sourceStore, err := NewDirectoryStore(filepath.Join(dir, "source"), "")
+	assert.NoError(t, err)
+	targetStore, err := NewDirectoryStore(filepath.Join(dir, "target"), "")
+	assert.NoError(t, err)
+
+	keysDir := filepath.Join(dir, "keys")
+	assert.NoError(t, os.MkdirAll(keysDir, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(keysDir, "key"), []byte(strings.Repeat("foo", 1000)), os.ModePerm))
+	options := &Options{KeyProvider: newTestKeyProvider(t)}
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, backup(sourceStore, "test", "foo", []BackupDir{{Key: "operator-keys", Directory: keysDir}}, options))
+	}
+
+	export, err := exportBackup(sourceStore, "test", map[string][]byte{"cr.yaml": []byte("kind: Qliksense")}, []byte("secret"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(export), "Qliksense")
+
+	_, err = importBackup(targetStore, export, []byte("wrong"), ImportTarget{})
+	assert.Error(t, err)
+
+	result, err := importBackup(targetStore, export, []byte("secret"), ImportTarget{SecretName: "renamed", ReleaseLabelValue: "bar"})
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", result.SecretName)
+	assert.Equal(t, "kind: Qliksense", string(result.Files["cr.yaml"]))
+
+	objects, err := targetStore.List(labels.Everything())
+	assert.NoError(t, err)
+	for _, object := range objects {
+		assert.True(t, strings.HasPrefix(object.Name, "renamed"))
+		assert.Equal(t, "bar", object.Labels[releaseLabelKey])
+	}
+	generations, err := listGenerations(targetStore, "renamed")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(generations))
+
+	restoreDir := filepath.Join(dir, "restored")
+	assert.NoError(t, restore(targetStore, "renamed", []BackupDir{{Key: "operator-keys", Directory: restoreDir}}, options))
+	restored, err := ioutil.ReadFile(filepath.Join(restoreDir, "key"))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("foo", 1000), string(restored))
+
+	_, err = importBackup(targetStore, export, []byte("secret"), ImportTarget{SecretName: "renamed"})
+	assert.True(t, kubeApiErrors.IsAlreadyExists(err))
+}
@@ -0,0 +1,197 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// set on the generation secrets, the value is the generation number
+	generationLabelKey = "qlik.com/backup-generation"
+	defaultRetention   = 5
+)
+
+// BackupGeneration describes one backup kept in the history
+type BackupGeneration struct {
+	Number       int       `json:"number"`
+	Timestamp    time.Time `json:"timestamp"`
+	CRGeneration int64     `json:"crGeneration,omitempty"`
+	KeysAction   string    `json:"keysAction,omitempty"`
+	// set if the keys were restored from this previous generation
+	RestoredGeneration *int `json:"restoredGeneration,omitempty"`
+}
+
+// ListGenerations returns the backup generations kept for secretName in options.Store, newest first
+func ListGenerations(kubeconfigPath, secretName, namespace string, options *Options) ([]*BackupGeneration, error) {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return nil, err
+	}
+	return listGenerations(store, secretName)
+}
+
+// RestoreGeneration is RestoreWithOptions from a specific backup generation instead of the latest one
+func RestoreGeneration(kubeconfigPath, secretName, namespace string, generation int, backupInfos []BackupDir, options *Options) error {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return err
+	}
+	return restoreGeneration(store, secretName, generation, backupInfos, options)
+}
+
+func listGenerations(store BackupStore, secretName string) ([]*BackupGeneration, error) {
+	generationObjects, err := listGenerationObjects(store, secretName)
+	if err != nil {
+		return nil, err
+	}
+	var generations []*BackupGeneration
+	for _, generationObject := range generationObjects {
+		if generation, err := getGeneration(generationObject); err != nil {
+			return nil, err
+		} else {
+			generations = append(generations, generation)
+		}
+	}
+	return generations, nil
+}
+
+func restoreGeneration(store BackupStore, secretName string, generation int, backupInfos []BackupDir, options *Options) error {
+	object, err := store.Get(getGenerationSecretName(secretName, generation))
+	if err != nil {
+		return err
+	}
+	return restoreFromObject(store, object, backupInfos, options)
+}
+
+// getNextGeneration numbers the next backup, a backup taken before the history was kept is preserved as generation 0
+// with the metadata of the other generations, also returns the generation of the current index, -1 if there is none
+func getNextGeneration(store BackupStore, secretName, releaseLabelValue string, options *Options) (*BackupGeneration, int, error) {
+	generationObjects, err := listGenerationObjects(store, secretName)
+	if err != nil {
+		return nil, 0, err
+	}
+	number := 1
+	if len(generationObjects) > 0 {
+		if latest, err := getGeneration(generationObjects[0]); err != nil {
+			return nil, 0, err
+		} else {
+			number = latest.Number + 1
+		}
+	}
+
+	indexGeneration := -1
+	if object, err := store.Get(secretName); err != nil && !kubeApiErrors.IsNotFound(err) {
+		return nil, 0, err
+	} else if err == nil {
+		if manifest, err := getManifest(object); err != nil {
+			return nil, 0, err
+		} else if manifest.Generation == nil {
+			indexGeneration = 0
+			if _, plaintext := getArchiveKeys(object, manifest); plaintext && options.KeyProvider != nil {
+				// the unencrypted archives are not copied, the encrypted backup replaces them
+				return newBackupGeneration(number, options), indexGeneration, nil
+			}
+			// a concurrent writer may have preserved it already
+			backedUpAt := object.CreationTimestamp
+			if backedUpAt.IsZero() {
+				backedUpAt = time.Now()
+			}
+			if err := store.Create(&BackupObject{
+				Name: getGenerationSecretName(secretName, 0),
+				Labels: getBackupLabels(releaseLabelValue, options, map[string]string{
+					backupLabelKey:     secretName,
+					generationLabelKey: "0",
+				}),
+				Annotations:     getBackupAnnotations(options, backedUpAt.UTC()),
+				Data:            object.Data,
+				OwnerReferences: options.OwnerReferences,
+			}); err != nil && !kubeApiErrors.IsAlreadyExists(err) {
+				return nil, 0, fmt.Errorf("error preserving the existing backup as generation 0: %w", err)
+			}
+		} else {
+			indexGeneration = manifest.Generation.Number
+			if indexGeneration >= number {
+				number = indexGeneration + 1
+			}
+		}
+	}
+
+	return newBackupGeneration(number, options), indexGeneration, nil
+}
+
+func newBackupGeneration(number int, options *Options) *BackupGeneration {
+	return &BackupGeneration{
+		Number:             number,
+		Timestamp:          time.Now().UTC(),
+		CRGeneration:       options.CRGeneration,
+		KeysAction:         options.KeysAction,
+		RestoredGeneration: options.RestoredGeneration,
+	}
+}
+
+// applyRetention deletes the generations beyond retention and the chunks no kept generation references
+func applyRetention(store BackupStore, secretName string, retention int) error {
+	if retention <= 0 {
+		retention = defaultRetention
+	}
+	generationObjects, err := listGenerationObjects(store, secretName)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for i := 0; i < len(generationObjects) && i < retention; i++ {
+		keep[generationObjects[i].Name] = true
+		if manifest, err := getManifest(generationObjects[i]); err != nil {
+			return err
+		} else {
+			for _, entry := range manifest.Entries {
+				for _, chunkName := range entry.Chunks {
+					keep[chunkName] = true
+				}
+			}
+		}
+	}
+	return deleteUnreferencedObjects(store, secretName, keep)
+}
+
+// listGenerationObjects returns the generations of secretName, newest first
+func listGenerationObjects(store BackupStore, secretName string) ([]*BackupObject, error) {
+	selector, err := labels.Parse(fmt.Sprintf("%v=%v,%v", backupLabelKey, secretName, generationLabelKey))
+	if err != nil {
+		return nil, err
+	}
+	generationObjects, err := store.List(selector)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(generationObjects, func(i, j int) bool {
+		iNumber, _ := strconv.Atoi(generationObjects[i].Labels[generationLabelKey])
+		jNumber, _ := strconv.Atoi(generationObjects[j].Labels[generationLabelKey])
+		return iNumber > jNumber
+	})
+	return generationObjects, nil
+}
+
+func getGeneration(object *BackupObject) (*BackupGeneration, error) {
+	manifest, err := getManifest(object)
+	if err != nil {
+		return nil, err
+	} else if manifest.Generation != nil {
+		return manifest.Generation, nil
+	}
+	// preserved from before the history was kept
+	number, err := strconv.Atoi(object.Labels[generationLabelKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid generation label of backup: %v, error: %w", object.Name, err)
+	}
+	return &BackupGeneration{Number: number, Timestamp: object.CreationTimestamp.UTC()}, nil
+}
+
+func getGenerationSecretName(secretName string, generation int) string {
+	return fmt.Sprintf("%v-gen-%v", secretName, generation)
+}
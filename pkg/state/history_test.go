@@ -0,0 +1,64 @@
+package state
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestBackupHistory(t *testing.T) {
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	targetDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+
+	// a backup taken before the history was kept
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "key"), []byte("0"), os.ModePerm))
+	binaryData, err := getBinaryData([]BackupDir{{Key: "operator-keys", Directory: sourceDir}})
+	assert.NoError(t, err)
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	_, err = secretsClient.Create(context.TODO(), &v1.Secret{ObjectMeta: metaV1.ObjectMeta{Name: "test"}, Data: binaryData}, metaV1.CreateOptions{})
+	assert.NoError(t, err)
+
+	for _, content := range []string{"1", "2", "3"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "key"), []byte(content), os.ModePerm))
+		assert.NoError(t, backup(store, "test", "", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{
+			Retention:    3,
+			CRGeneration: 7,
+			KeysAction:   "ForceRotate",
+		}))
+	}
+
+	generations, err := listGenerations(store, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(generations))
+	assert.Equal(t, 3, generations[0].Number)
+	assert.Equal(t, int64(7), generations[0].CRGeneration)
+	assert.Equal(t, "ForceRotate", generations[0].KeysAction)
+	assert.False(t, generations[0].Timestamp.IsZero())
+	assert.Equal(t, 1, generations[2].Number)
+
+	// the latest generation is restored by default
+	assert.NoError(t, restore(store, "test", []BackupDir{{Key: "operator-keys", Directory: targetDir}}, &Options{}))
+	restored, err := ioutil.ReadFile(filepath.Join(targetDir, "key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(restored))
+
+	assert.NoError(t, restoreGeneration(store, "test", 1, []BackupDir{{Key: "operator-keys", Directory: targetDir}}, &Options{}))
+	restored, err = ioutil.ReadFile(filepath.Join(targetDir, "key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(restored))
+
+	// generation 0 (the preserved legacy backup) is beyond the retention
+	assert.Error(t, restoreGeneration(store, "test", 0, []BackupDir{{Key: "operator-keys", Directory: targetDir}}, &Options{}))
+}
@@ -0,0 +1,94 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	componentLabelKey   = "app.kubernetes.io/component"
+	componentLabelValue = "operator-state-backup"
+	instanceLabelKey    = "app.kubernetes.io/instance"
+	managedByLabelKey   = "app.kubernetes.io/managed-by"
+	managedByLabelValue = "k-apis"
+
+	versionAnnotationKey    = "qlik.com/k-apis-version"
+	backedUpAtAnnotationKey = "qlik.com/backed-up-at"
+
+	kApisModulePath = "github.com/qlik-oss/k-apis"
+)
+
+// getBackupLabels returns options.Labels with the standard labels and then labels on top
+func getBackupLabels(releaseLabelValue string, options *Options, labels map[string]string) map[string]string {
+	backupLabels := make(map[string]string)
+	for k, v := range options.Labels {
+		backupLabels[k] = v
+	}
+	backupLabels[releaseLabelKey] = releaseLabelValue
+	backupLabels[instanceLabelKey] = releaseLabelValue
+	backupLabels[componentLabelKey] = componentLabelValue
+	backupLabels[managedByLabelKey] = managedByLabelValue
+	for k, v := range labels {
+		backupLabels[k] = v
+	}
+	return backupLabels
+}
+
+func getBackupAnnotations(options *Options, backedUpAt time.Time) map[string]string {
+	annotations := make(map[string]string)
+	for k, v := range options.Annotations {
+		annotations[k] = v
+	}
+	annotations[versionAnnotationKey] = getKApisVersion()
+	annotations[backedUpAtAnnotationKey] = backedUpAt.Format(time.RFC3339)
+	return annotations
+}
+
+// getKApisVersion returns the version of this module in the running binary
+func getKApisVersion() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	} else if buildInfo.Main.Path == kApisModulePath {
+		return buildInfo.Main.Version
+	}
+	for _, dependency := range buildInfo.Deps {
+		if dependency.Path == kApisModulePath {
+			return dependency.Version
+		}
+	}
+	return "unknown"
+}
+
+// deleteBySelector deletes the index objects matching selector first, so no index is left referencing deleted objects
+func deleteBySelector(store BackupStore, selector string) ([]string, error) {
+	if strings.TrimSpace(selector) == "" {
+		return nil, errors.New("a label selector is required to delete backups")
+	}
+	parsedSelector, err := labels.Parse(fmt.Sprintf("%v=%v,%v", componentLabelKey, componentLabelValue, selector))
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector: %v, error: %w", selector, err)
+	}
+	objects, err := store.List(parsedSelector)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, deleteIndexes := range []bool{true, false} {
+		for _, object := range objects {
+			// chunks and generations are labeled with the name of their index
+			if _, isChunkOrGeneration := object.Labels[backupLabelKey]; isChunkOrGeneration == deleteIndexes {
+				continue
+			} else if err := store.Delete(object.Name); err != nil {
+				return deleted, err
+			}
+			deleted = append(deleted, object.Name)
+		}
+	}
+	return deleted, nil
+}
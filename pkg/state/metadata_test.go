@@ -0,0 +1,132 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestBackup_metadata(t *testing.T) {
+	defer func(size int) { maxChunkSize = size }(maxChunkSize)
+	maxChunkSize = 256
+
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	// does not compress below the chunk size
+	key := make([]byte, 1000)
+	_, err = rand.Read(key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "key"), key, os.ModePerm))
+
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	ownerReferences := []metaV1.OwnerReference{{APIVersion: "qlik.com/v1", Kind: "Qliksense", Name: "foo", UID: "1234"}}
+	options := &Options{
+		Labels:          map[string]string{"team": "a", releaseLabelKey: "ignored"},
+		Annotations:     map[string]string{"note": "b"},
+		OwnerReferences: ownerReferences,
+	}
+	assert.NoError(t, backup(store, "test", "foo", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, options))
+
+	secrets, err := secretsClient.List(context.TODO(), metaV1.ListOptions{})
+	assert.NoError(t, err)
+	// index, generation and chunks
+	assert.True(t, len(secrets.Items) > 2)
+	for _, secret := range secrets.Items {
+		assert.Equal(t, "foo", secret.Labels[releaseLabelKey])
+		assert.Equal(t, "foo", secret.Labels[instanceLabelKey])
+		assert.Equal(t, componentLabelValue, secret.Labels[componentLabelKey])
+		assert.Equal(t, "a", secret.Labels["team"])
+		assert.Equal(t, "b", secret.Annotations["note"])
+		assert.NotEmpty(t, secret.Annotations[versionAnnotationKey])
+		assert.NotEmpty(t, secret.Annotations[backedUpAtAnnotationKey])
+		assert.Equal(t, ownerReferences, secret.OwnerReferences)
+	}
+
+	// the owner references are removed once the backup is no longer owned
+	options.OwnerReferences = nil
+	assert.NoError(t, backup(store, "test", "foo", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, options))
+	index, err := secretsClient.Get(context.TODO(), "test", metaV1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, index.OwnerReferences)
+}
+
+func TestBackup_metadataOfPreservedGeneration(t *testing.T) {
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "key"), []byte("foo"), os.ModePerm))
+
+	// a backup taken before the history was kept is preserved as generation 0
+	binaryData, err := getBinaryData([]BackupDir{{Key: "operator-keys", Directory: sourceDir}})
+	assert.NoError(t, err)
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	_, err = secretsClient.Create(context.TODO(), &v1.Secret{ObjectMeta: metaV1.ObjectMeta{Name: "test"}, Data: binaryData}, metaV1.CreateOptions{})
+	assert.NoError(t, err)
+	ownerReferences := []metaV1.OwnerReference{{APIVersion: "qlik.com/v1", Kind: "Qliksense", Name: "foo", UID: "1234"}}
+	options := &Options{
+		Labels:          map[string]string{"team": "a"},
+		Annotations:     map[string]string{"note": "b"},
+		OwnerReferences: ownerReferences,
+	}
+	assert.NoError(t, backup(store, "test", "foo", []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, options))
+
+	preserved, err := secretsClient.Get(context.TODO(), getGenerationSecretName("test", 0), metaV1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", preserved.Labels[releaseLabelKey])
+	assert.Equal(t, "foo", preserved.Labels[instanceLabelKey])
+	assert.Equal(t, componentLabelValue, preserved.Labels[componentLabelKey])
+	assert.Equal(t, "a", preserved.Labels["team"])
+	assert.Equal(t, "b", preserved.Annotations["note"])
+	assert.NotEmpty(t, preserved.Annotations[backedUpAtAnnotationKey])
+	assert.Equal(t, ownerReferences, preserved.OwnerReferences)
+
+	deleted, err := deleteBySelector(store, "release=foo")
+	assert.NoError(t, err)
+	assert.Contains(t, deleted, getGenerationSecretName("test", 0))
+}
+
+func TestDeleteBySelector(t *testing.T) {
+	sourceDir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "key"), []byte("foo"), os.ModePerm))
+
+	secretsClient := fake.NewSimpleClientset().CoreV1().Secrets("test")
+	store := &SecretStore{secretsClient: secretsClient}
+	for _, release := range []string{"foo", "bar"} {
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, backup(store, release+"-backup", release, []BackupDir{{Key: "operator-keys", Directory: sourceDir}}, &Options{}))
+		}
+	}
+	// not a backup
+	_, err = secretsClient.Create(context.TODO(), &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "other", Labels: map[string]string{releaseLabelKey: "foo"}},
+	}, metaV1.CreateOptions{})
+	assert.NoError(t, err)
+
+	_, err = deleteBySelector(store, "")
+	assert.Error(t, err)
+
+	deleted, err := deleteBySelector(store, "release=foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo-backup", "foo-backup-gen-1", "foo-backup-gen-2"}, deleted)
+
+	secrets, err := secretsClient.List(context.TODO(), metaV1.ListOptions{})
+	assert.NoError(t, err)
+	var names []string
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	assert.ElementsMatch(t, []string{"other", "bar-backup", "bar-backup-gen-1", "bar-backup-gen-2"}, names)
+}
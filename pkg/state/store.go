@@ -0,0 +1,294 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qlik-oss/k-apis/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	clientV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// BackupObject is the unit a BackupStore persists, a kubernetes secret for the SecretStore
+type BackupObject struct {
+	Name              string            `json:"name"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	Data              map[string][]byte `json:"data"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	// set on the secrets of the SecretStore only, ex. to garbage collect the backup with the CR
+	OwnerReferences []metaV1.OwnerReference `json:"ownerReferences,omitempty"`
+	// changes on every write, set by Get
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// BackupStore persists the backup index, generation and chunk objects
+// errors are kubernetes api errors (see k8s.io/apimachinery/pkg/api/errors): NotFound for missing objects,
+// AlreadyExists and Conflict when a concurrent writer got there first
+type BackupStore interface {
+	Get(name string) (*BackupObject, error)
+	// Create fails with AlreadyExists if the object exists
+	Create(object *BackupObject) error
+	// Put creates or replaces the object, the creation timestamp of an existing object is kept
+	// if object.ResourceVersion is set, Put fails with Conflict unless the stored object still has that version
+	Put(object *BackupObject) error
+	// Delete does not fail for missing objects
+	Delete(name string) error
+	List(selector labels.Selector) ([]*BackupObject, error)
+}
+
+// SecretStore keeps every backup object in a kubernetes secret of the same name
+type SecretStore struct {
+	secretsClient clientV1.SecretInterface
+}
+
+// NewSecretStore returns the store Backup and Restore use by default
+func NewSecretStore(kubeconfigPath, namespace string) (*SecretStore, error) {
+	secretsClient, err := utils.GetSecretsClient(kubeconfigPath, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretStore{secretsClient: secretsClient}, nil
+}
+
+func (s *SecretStore) Get(name string) (*BackupObject, error) {
+	secret, err := s.secretsClient.Get(context.TODO(), name, metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secretToBackupObject(secret), nil
+}
+
+func (s *SecretStore) Create(object *BackupObject) error {
+	_, err := s.secretsClient.Create(context.TODO(), &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:            object.Name,
+			Labels:          object.Labels,
+			Annotations:     object.Annotations,
+			OwnerReferences: object.OwnerReferences,
+		},
+		Data: object.Data,
+	}, metaV1.CreateOptions{})
+	return err
+}
+
+func (s *SecretStore) Put(object *BackupObject) error {
+	existingSecret, err := s.secretsClient.Get(context.TODO(), object.Name, metaV1.GetOptions{})
+	if err != nil && kubeApiErrors.IsNotFound(err) {
+		if object.ResourceVersion != "" {
+			return newConflictError(object.Name, "it was deleted")
+		}
+		//doesn't exist, create:
+		err = s.Create(object)
+	} else if err == nil {
+		if object.ResourceVersion != "" {
+			if existingSecret.ResourceVersion != object.ResourceVersion {
+				return newConflictError(object.Name, "it was modified")
+			}
+			// the api server rejects the update if the secret changed since
+			existingSecret.ResourceVersion = object.ResourceVersion
+		}
+		//exists, update:
+		existingSecret.Data = object.Data
+		if existingSecret.Labels == nil {
+			existingSecret.Labels = make(map[string]string)
+		}
+		for k, v := range object.Labels {
+			existingSecret.Labels[k] = v
+		}
+		if existingSecret.Annotations == nil {
+			existingSecret.Annotations = make(map[string]string)
+		}
+		for k, v := range object.Annotations {
+			existingSecret.Annotations[k] = v
+		}
+		existingSecret.OwnerReferences = object.OwnerReferences
+		_, err = s.secretsClient.Update(context.TODO(), existingSecret, metaV1.UpdateOptions{})
+	}
+	return err
+}
+
+func (s *SecretStore) Delete(name string) error {
+	if err := s.secretsClient.Delete(context.TODO(), name, metaV1.DeleteOptions{}); err != nil && !kubeApiErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *SecretStore) List(selector labels.Selector) ([]*BackupObject, error) {
+	secretList, err := s.secretsClient.List(context.TODO(), metaV1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var objects []*BackupObject
+	for i := range secretList.Items {
+		objects = append(objects, secretToBackupObject(&secretList.Items[i]))
+	}
+	return objects, nil
+}
+
+func secretToBackupObject(secret *v1.Secret) *BackupObject {
+	return &BackupObject{
+		Name:              secret.Name,
+		Labels:            secret.Labels,
+		Annotations:       secret.Annotations,
+		Data:              secret.Data,
+		CreationTimestamp: secret.CreationTimestamp.Time,
+		OwnerReferences:   secret.OwnerReferences,
+		ResourceVersion:   secret.ResourceVersion,
+	}
+}
+
+// DirectoryStore keeps every backup object in a json file of a local directory, under a subdirectory of the namespace,
+// ex. a volume that outlives the namespace. Writers sharing the directory are serialized by a lock file.
+type DirectoryStore struct {
+	directory string
+}
+
+const (
+	directoryStoreLockFile     = ".lock"
+	directoryStoreLockAttempts = 100
+	directoryStoreLockInterval = 50 * time.Millisecond
+)
+
+// NewDirectoryStore keeps the objects in directory/namespace, the backups of CRs of the same name in other namespaces
+// don't collide
+func NewDirectoryStore(directory, namespace string) (*DirectoryStore, error) {
+	directory = filepath.Join(directory, namespace)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	return &DirectoryStore{directory: directory}, nil
+}
+
+func (s *DirectoryStore) Get(name string) (*BackupObject, error) {
+	objectBytes, err := ioutil.ReadFile(s.getFilePath(name))
+	if os.IsNotExist(err) {
+		return nil, newNotFoundError(fmt.Sprintf("backup object: %v not found in directory: %v", name, s.directory))
+	} else if err != nil {
+		return nil, err
+	}
+	object := &BackupObject{}
+	if err := json.Unmarshal(objectBytes, object); err != nil {
+		return nil, fmt.Errorf("error parsing backup object: %v, error: %w", name, err)
+	}
+	return object, nil
+}
+
+func (s *DirectoryStore) Create(object *BackupObject) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(s.getFilePath(object.Name)); err == nil {
+		return kubeApiErrors.NewAlreadyExists(backupGroupResource, object.Name)
+	}
+	return s.write(object, 1)
+}
+
+func (s *DirectoryStore) Put(object *BackupObject) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	version := 1
+	if existingObject, err := s.Get(object.Name); err != nil && !kubeApiErrors.IsNotFound(err) {
+		return err
+	} else if err != nil && object.ResourceVersion != "" {
+		return newConflictError(object.Name, "it was deleted")
+	} else if err == nil {
+		if object.ResourceVersion != "" && existingObject.ResourceVersion != object.ResourceVersion {
+			return newConflictError(object.Name, "it was modified")
+		}
+		existingVersion, _ := strconv.Atoi(existingObject.ResourceVersion)
+		version = existingVersion + 1
+	}
+	return s.write(object, version)
+}
+
+func (s *DirectoryStore) write(object *BackupObject, version int) error {
+	object = keepCreationTimestamp(s, object)
+	object.ResourceVersion = strconv.Itoa(version)
+	objectBytes, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	// written next to the target and renamed so a crash never leaves a truncated object
+	tmpFilePath := s.getFilePath(object.Name) + ".tmp"
+	if err := ioutil.WriteFile(tmpFilePath, objectBytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilePath, s.getFilePath(object.Name))
+}
+
+func (s *DirectoryStore) Delete(name string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(s.getFilePath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *DirectoryStore) List(selector labels.Selector) ([]*BackupObject, error) {
+	fileInfos, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+	namePrefix := getListNamePrefix(selector)
+	var objects []*BackupObject
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() || filepath.Ext(fileInfo.Name()) != ".json" || !strings.HasPrefix(fileInfo.Name(), namePrefix) {
+			continue
+		} else if object, err := s.Get(strings.TrimSuffix(fileInfo.Name(), ".json")); err != nil {
+			return nil, err
+		} else if selector.Matches(labels.Set(object.Labels)) {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+func (s *DirectoryStore) getFilePath(name string) string {
+	return filepath.Join(s.directory, name+".json")
+}
+
+// getListNamePrefix returns the prefix of the names of the objects a selector of the chunks or generations of a backup
+// may match, they are named after the backup
+func getListNamePrefix(selector labels.Selector) string {
+	if secretName, ok := selector.RequiresExactMatch(backupLabelKey); ok {
+		return secretName
+	}
+	return ""
+}
+
+// lock takes the lock of the directory and returns the func releasing it
+func (s *DirectoryStore) lock() (func(), error) {
+	return utils.LockFile(filepath.Join(s.directory, directoryStoreLockFile), directoryStoreLockAttempts, directoryStoreLockInterval)
+}
+
+// keepCreationTimestamp returns a copy of object with the creation timestamp of the stored object, or now for a new one
+func keepCreationTimestamp(store BackupStore, object *BackupObject) *BackupObject {
+	objectCopy := *object
+	if existingObject, err := store.Get(object.Name); err == nil {
+		objectCopy.CreationTimestamp = existingObject.CreationTimestamp
+	} else {
+		objectCopy.CreationTimestamp = time.Now().UTC()
+	}
+	return &objectCopy
+}
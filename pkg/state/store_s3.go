@@ -0,0 +1,266 @@
+package state
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	kubeApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const defaultS3Region = "us-east-1"
+
+// S3Options configures an S3 compatible object store (AWS S3, MinIO, ...), objects are addressed path-style
+type S3Options struct {
+	// ex. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Endpoint string
+	Bucket   string
+	Prefix   string
+	// the objects are kept under <prefix>/<namespace>/ so the backups of CRs of the same name in other namespaces don't collide
+	Namespace       string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	HTTPClient      *http.Client
+}
+
+// S3Store keeps every backup object as a json object under the prefix of an S3 bucket,
+// so the keys survive the deletion of the namespace or the cluster.
+// The ETag of an object is its resource version, conditional writes require a server supporting If-Match and If-None-Match.
+type S3Store struct {
+	options S3Options
+}
+
+func NewS3Store(options S3Options) (*S3Store, error) {
+	if options.Endpoint == "" || options.Bucket == "" {
+		return nil, fmt.Errorf("the S3 endpoint and bucket are required")
+	} else if _, err := url.Parse(options.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %v, error: %w", options.Endpoint, err)
+	}
+	if options.Region == "" {
+		options.Region = defaultS3Region
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = http.DefaultClient
+	}
+	options.Endpoint = strings.TrimSuffix(options.Endpoint, "/")
+	return &S3Store{options: options}, nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) Get(name string) (*BackupObject, error) {
+	objectBytes, responseHeader, err := s.do(http.MethodGet, s.getObjectKey(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	object := &BackupObject{}
+	if err := json.Unmarshal(objectBytes, object); err != nil {
+		return nil, fmt.Errorf("error parsing backup object: %v, error: %w", name, err)
+	}
+	object.ResourceVersion = responseHeader.Get("ETag")
+	return object, nil
+}
+
+func (s *S3Store) Create(object *BackupObject) error {
+	err := s.put(object, http.Header{"If-None-Match": []string{"*"}})
+	if kubeApiErrors.IsConflict(err) {
+		return kubeApiErrors.NewAlreadyExists(backupGroupResource, object.Name)
+	}
+	return err
+}
+
+func (s *S3Store) Put(object *BackupObject) error {
+	header := http.Header{}
+	if object.ResourceVersion != "" {
+		header.Set("If-Match", object.ResourceVersion)
+	}
+	return s.put(object, header)
+}
+
+func (s *S3Store) put(object *BackupObject, header http.Header) error {
+	object = keepCreationTimestamp(s, object)
+	// the version is the ETag assigned by the server
+	object.ResourceVersion = ""
+	objectBytes, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.do(http.MethodPut, s.getObjectKey(object.Name), nil, objectBytes, header)
+	return err
+}
+
+func (s *S3Store) Delete(name string) error {
+	if _, _, err := s.do(http.MethodDelete, s.getObjectKey(name), nil, nil, nil); err != nil && !kubeApiErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *S3Store) List(selector labels.Selector) ([]*BackupObject, error) {
+	prefix := path.Join(s.options.Prefix, s.options.Namespace)
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	}
+	// only the objects whose name may match the selector are read
+	namePrefix := getListNamePrefix(selector)
+	var objects []*BackupObject
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": []string{"2"}, "prefix": []string{prefix + namePrefix}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		responseBytes, _, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		listBucketResult := &s3ListBucketResult{}
+		if err := xml.Unmarshal(responseBytes, listBucketResult); err != nil {
+			return nil, fmt.Errorf("error parsing the S3 object list: %w", err)
+		}
+		for _, content := range listBucketResult.Contents {
+			name := strings.TrimPrefix(content.Key, prefix)
+			if strings.Contains(name, "/") || path.Ext(name) != ".json" {
+				continue
+			} else if object, err := s.Get(strings.TrimSuffix(name, ".json")); err != nil {
+				return nil, err
+			} else if selector.Matches(labels.Set(object.Labels)) {
+				objects = append(objects, object)
+			}
+		}
+		if !listBucketResult.IsTruncated || listBucketResult.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuationToken = listBucketResult.NextContinuationToken
+	}
+}
+
+func (s *S3Store) getObjectKey(name string) string {
+	return path.Join(s.options.Prefix, s.options.Namespace, name+".json")
+}
+
+// do sends a signed request for objectKey in the bucket, or for the bucket itself if objectKey is empty
+// a failed precondition of the header is returned as a kubernetes Conflict error
+func (s *S3Store) do(method, objectKey string, query url.Values, body []byte, header http.Header) ([]byte, http.Header, error) {
+	requestURL := fmt.Sprintf("%v/%v", s.options.Endpoint, s.options.Bucket)
+	if objectKey != "" {
+		requestURL = fmt.Sprintf("%v/%v", requestURL, uriEncode(objectKey, false))
+	}
+	if len(query) > 0 {
+		requestURL = fmt.Sprintf("%v?%v", requestURL, canonicalQueryString(query))
+	}
+	request, err := http.NewRequest(method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	signS3Request(request, body, s.options.Region, s.options.AccessKeyID, s.options.SecretAccessKey, time.Now().UTC())
+
+	response, err := s.options.HTTPClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil, newNotFoundError(fmt.Sprintf("S3 object: %v not found in bucket: %v", objectKey, s.options.Bucket))
+	} else if response.StatusCode == http.StatusPreconditionFailed {
+		return nil, nil, newConflictError(objectKey, "it was modified")
+	} else if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("S3 %v %v failed with status: %v, response: %v", method, objectKey, response.Status, string(responseBytes))
+	}
+	return responseBytes, response.Header, nil
+}
+
+// signS3Request adds an AWS signature version 4 authorization header, requests are left unsigned without credentials
+func signS3Request(request *http.Request, body []byte, region, accessKeyID, secretAccessKey string, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+	if accessKeyID == "" {
+		return
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		uriEncode(request.URL.Path, false),
+		canonicalQueryString(request.URL.Query()),
+		fmt.Sprintf("host:%v\nx-amz-content-sha256:%v\nx-amz-date:%v\n", request.URL.Host, payloadHash, amzDate),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%v/%v/s3/aws4_request", now.Format("20060102"), region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), now.Format("20060102"))
+	for _, scopePart := range []string{region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, scopePart)
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		accessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))))
+}
+
+func canonicalQueryString(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, fmt.Sprintf("%v=%v", uriEncode(key, true), uriEncode(value, true)))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes everything but the unreserved characters as required by signature version 4
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !encodeSlash) {
+			encoded.WriteByte(b)
+		} else {
+			encoded.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return encoded.String()
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type DriftType string
+
+const (
+	// in the backup but not in the local directory
+	DriftMissing DriftType = "missing"
+	// in the local directory but not in the backup
+	DriftExtra DriftType = "extra"
+	// in both with a different content
+	DriftModified DriftType = "modified"
+)
+
+// FileDrift is a file of a backup directory that does not match the backup
+type FileDrift struct {
+	Key string
+	// slash separated, relative to the backup directory
+	Path string
+	Type DriftType
+}
+
+// VerifyResult lists the files of the backup directories that do not match the backup
+type VerifyResult struct {
+	SecretName string
+	// the generation of the verified backup, 0 for backups taken before the history was kept
+	Generation int
+	Drifts     []*FileDrift
+}
+
+func (r *VerifyResult) HasDrift() bool {
+	return len(r.Drifts) > 0
+}
+
+// Verify compares the sha256 hashes of the files in every backupDir with the files in the archive of its key
+// in the latest backup of secretName. The checksum of the archives is verified as for a restore.
+func Verify(kubeconfigPath, secretName, namespace string, backupDirs []BackupDir, options *Options) (*VerifyResult, error) {
+	store, err := getStore(kubeconfigPath, namespace, options)
+	if err != nil {
+		return nil, err
+	}
+	return verify(store, secretName, backupDirs, options)
+}
+
+func verify(store BackupStore, secretName string, backupDirs []BackupDir, options *Options) (*VerifyResult, error) {
+	object, err := store.Get(secretName)
+	if err != nil {
+		return nil, err
+	}
+	generation, err := getIndexGeneration(object)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	result := &VerifyResult{SecretName: secretName, Generation: generation}
+	for _, backupDir := range backupDirs {
+		backupDirectory := filepath.Join(tmpDir, backupDir.Key)
+		if err := restoreFromObject(store, object, []BackupDir{{Key: backupDir.Key, Directory: backupDirectory}}, options); err != nil {
+			return nil, err
+		}
+		backupHashes, err := getFileHashes(backupDirectory)
+		if err != nil {
+			return nil, err
+		}
+		localHashes, err := getFileHashes(backupDir.Directory)
+		if err != nil {
+			return nil, err
+		}
+		result.Drifts = append(result.Drifts, compareFileHashes(backupDir.Key, backupHashes, localHashes)...)
+	}
+	return result, nil
+}
+
+// getFileHashes returns the hex encoded sha256 hash of every regular file under directory by slash separated relative path
+// a missing directory has no files
+func getFileHashes(directory string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	if _, err := os.Stat(directory); os.IsNotExist(err) {
+		return hashes, nil
+	}
+	err := filepath.Walk(directory, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(directory, filePath)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(content)
+		hashes[filepath.ToSlash(relativePath)] = hex.EncodeToString(hash[:])
+		return nil
+	})
+	return hashes, err
+}
+
+// compareFileHashes returns the drifts of the local files from the backup files, sorted by path
+func compareFileHashes(key string, backupHashes, localHashes map[string]string) []*FileDrift {
+	var drifts []*FileDrift
+	for filePath, backupHash := range backupHashes {
+		if localHash, ok := localHashes[filePath]; !ok {
+			drifts = append(drifts, &FileDrift{Key: key, Path: filePath, Type: DriftMissing})
+		} else if localHash != backupHash {
+			drifts = append(drifts, &FileDrift{Key: key, Path: filePath, Type: DriftModified})
+		}
+	}
+	for filePath := range localHashes {
+		if _, ok := backupHashes[filePath]; !ok {
+			drifts = append(drifts, &FileDrift{Key: key, Path: filePath, Type: DriftExtra})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].Path < drifts[j].Path
+	})
+	return drifts
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewDirectoryStore(filepath.Join(dir, "backups"), "")
+	assert.NoError(t, err)
+
+	keysDir := filepath.Join(dir, "keys")
+	assert.NoError(t, os.MkdirAll(filepath.Join(keysDir, "certs"), os.ModePerm))
+	for _, fileName := range []string{"a", "b", "certs/c"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(keysDir, fileName), []byte(fileName), os.ModePerm))
+	}
+	backupDirs := []BackupDir{{Key: "operator-keys", Directory: keysDir}}
+	options := &Options{KeyProvider: newTestKeyProvider(t)}
+	assert.NoError(t, backup(store, "test", "", backupDirs, options))
+
+	result, err := verify(store, "test", backupDirs, options)
+	assert.NoError(t, err)
+	assert.False(t, result.HasDrift())
+	assert.Equal(t, 1, result.Generation)
+
+	assert.NoError(t, os.Remove(filepath.Join(keysDir, "a")))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(keysDir, "certs/c"), []byte("changed"), os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(keysDir, "d"), []byte("d"), os.ModePerm))
+	result, err = verify(store, "test", backupDirs, options)
+	assert.NoError(t, err)
+	assert.True(t, result.HasDrift())
+	assert.Equal(t, []*FileDrift{
+		{Key: "operator-keys", Path: "a", Type: DriftMissing},
+		{Key: "operator-keys", Path: "certs/c", Type: DriftModified},
+		{Key: "operator-keys", Path: "d", Type: DriftExtra},
+	}, result.Drifts)
+
+	// everything is missing
+	result, err = verify(store, "test", []BackupDir{{Key: "operator-keys", Directory: filepath.Join(dir, "missing")}}, options)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(result.Drifts))
+}
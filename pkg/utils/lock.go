@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// LockFile takes an exclusive lock on filePath, created if missing, retrying attempts times every interval,
+// and returns the func releasing it. The lock is released by the OS when the process exits, so a crashed
+// process never leaves a stale lock behind. The file itself is kept, removing it would let two processes lock
+// different files of the same path.
+func LockFile(filePath string, attempts int, interval time.Duration) (func(), error) {
+	lockFile, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < attempts; i++ {
+		if locked, err := tryLockFile(lockFile); err != nil {
+			lockFile.Close()
+			return nil, err
+		} else if locked {
+			return func() {
+				unlockFile(lockFile)
+				lockFile.Close()
+			}, nil
+		}
+		time.Sleep(interval)
+	}
+	lockFile.Close()
+	return nil, fmt.Errorf("timed out waiting for the lock: %v", filePath)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes an exclusive flock on lockFile without blocking, false if another file holds it
+func tryLockFile(lockFile *os.File) (bool, error) {
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+		return true, nil
+	} else if err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func unlockFile(lockFile *os.File) {
+	syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+}
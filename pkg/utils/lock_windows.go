@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLockFile takes an exclusive lock on the first byte of lockFile without blocking, false if another file holds it
+func tryLockFile(lockFile *os.File) (bool, error) {
+	err := windows.LockFileEx(windows.Handle(lockFile.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{})
+	if err == nil {
+		return true, nil
+	} else if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func unlockFile(lockFile *os.File) {
+	windows.UnlockFileEx(windows.Handle(lockFile.Fd()), 0, 1, 0, &windows.Overlapped{})
+}